@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"google.golang.org/grpc/grpclog"
@@ -23,12 +24,15 @@ import (
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 
+	"github.com/butters-mars/tiki/authz"
 	fmgrpc "github.com/butters-mars/tiki/client/grpc"
 	fmhttp "github.com/butters-mars/tiki/client/http"
 	"github.com/butters-mars/tiki/config"
 	fmsgrpc "github.com/butters-mars/tiki/grpc"
 	"github.com/butters-mars/tiki/healthcheck"
 	"github.com/butters-mars/tiki/logging"
+	"github.com/butters-mars/tiki/propagation"
+	"github.com/butters-mars/tiki/ratelimit"
 	"github.com/butters-mars/tiki/sd"
 	"github.com/butters-mars/tiki/tracing"
 	"github.com/butters-mars/tiki/utils"
@@ -41,6 +45,7 @@ type App interface {
 	NewGRPCConn(addr string) (*grpc.ClientConn, error)
 	SetAuthFunc(func(context.Context) (context.Context, error))
 	RegisterGRPCServer(func(*grpc.Server))
+	SetRateLimitStore(ratelimit.Store)
 	Start()
 }
 
@@ -54,6 +59,11 @@ type _App struct {
 
 	LogEntry      *logrus.Entry
 	tracingCloser io.Closer
+
+	registeror sd.SvcRegisteror
+	svc        *sd.SvcDef
+
+	limitStore ratelimit.Store
 }
 
 // GRPCRegistrar provides a way to register grpc server to the base server
@@ -62,11 +72,17 @@ type _App struct {
 const (
 	//debugAddr  = "debugaddr"
 	//httpAddr   = "httpaddr"
-	appName    = "appname"
-	port       = "port"
-	cfgTracing = "tracing"
-	cfgAuth    = "auth"
-	cfgSD      = "service-discovery"
+	appName     = "appname"
+	port        = "port"
+	cfgTracing  = "tracing"
+	cfgAuth     = "auth"
+	cfgSD       = "service-discovery"
+	cfgShutdown = "shutdown"
+
+	upstreamSetting   = "upstream-setting"
+	grpcServiceConfig = "grpc-service-config"
+	rateLimit         = "rate-limit"
+	authPolicy        = "auth-policy"
 
 	configName        = "config"
 	samplingServerURL = "http://127.0.0.1:5778/sampling"
@@ -88,6 +104,7 @@ func New(cfgName string) App {
 
 	initMetrics()
 	initHealthcheck()
+	initAdmin()
 	grpclog.SetLogger(logging.L)
 	logger.Infof("setup tracing")
 	closer, err := tracing.Init(app.cfg.APPName, app.cfg.Tracing)
@@ -102,7 +119,26 @@ func New(cfgName string) App {
 	if consulCfg != nil {
 		discInfo = fmt.Sprintf("consul::%s/%s", consulCfg.Address, consulCfg.Datacenter)
 	}
+	if app.cfg.ServiceDiscovery.Type == sd.TypeFile {
+		discInfo = fmt.Sprintf("file::%s", app.cfg.ServiceDiscovery.File)
+	}
+	if len(app.cfg.Baggage) > 0 {
+		propagation.SetKeys(app.cfg.Baggage...)
+	}
 	fmhttp.SetupClient(app.cfg.APPName, app.cfg.UpstreamSetting, discInfo)
+	if auth := app.cfg.Auth; auth != nil && auth.ClientTLS {
+		fmgrpc.SetClientTLS(&fmhttp.TLSSetting{
+			CAFile:         auth.CAFile,
+			CertFile:       auth.CertFile,
+			KeyFile:        auth.KeyFile,
+			ReloadInterval: auth.ReloadInterval,
+		})
+	}
+	for target, serviceConfig := range app.cfg.GRPCConfig {
+		if err := fmgrpc.SetServiceConfig(target, serviceConfig); err != nil {
+			logger.Errorf("Fail to set grpc service config of %s: %v", target, err)
+		}
+	}
 
 	return app
 }
@@ -112,7 +148,7 @@ func (app *_App) SetAuthFunc(authFunc func(context.Context) (context.Context, er
 	app.authFunc = authFunc
 }
 
-//RegisterGRPCServer add an registrar, and will do registration when app starts
+// RegisterGRPCServer add an registrar, and will do registration when app starts
 func (app *_App) RegisterGRPCServer(registrar func(base *grpc.Server)) {
 	if registrar == nil {
 		return
@@ -121,6 +157,12 @@ func (app *_App) RegisterGRPCServer(registrar func(base *grpc.Server)) {
 	app.registrars = append(app.registrars, registrar)
 }
 
+// SetRateLimitStore sets store coordinating rate limits among instances, which is used
+// if distributed mode is on in rate limit config
+func (app *_App) SetRateLimitStore(store ratelimit.Store) {
+	app.limitStore = store
+}
+
 // NewGRPCConn creates grpc client conn from given address
 func (app *_App) NewGRPCConn(addr string) (*grpc.ClientConn, error) {
 	return fmgrpc.NewClientConn(addr, app.cfg.ServiceDiscovery)
@@ -139,9 +181,32 @@ func (app *_App) Start() {
 
 	var g run.Group
 
-	// init debug handler
 	ip := utils.GetIP()
 	port := app.cfg.Port
+
+	// The gRPC listener mounts the Go kit gRPC server we created.
+	grpcAddr := fmt.Sprintf(":%d", port)
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		logger.Info("transport", "gRPC", "during", "Listen", "err", err)
+		os.Exit(1)
+	}
+	baseServer := fmsgrpc.NewServer(app.LogEntry, app.authFunc, app.cfg.Auth, app.serverOptions()...)
+	for _, reg := range app.registrars {
+		reg(baseServer)
+	}
+	fmsgrpc.UpdateHealthStatus(baseServer)
+	g.Add(func() error {
+		logger.Info("transport", "gRPC", "addr", grpcAddr)
+		return baseServer.Serve(grpcListener)
+	}, func(error) {
+		// unregister, go offline and drain in-flight calls before the
+		// debug listener (which serves healthcheck) is closed
+		app.shutdown(baseServer)
+		fmsgrpc.RemoveHealthStatus(baseServer)
+	})
+
+	// init debug handler
 	debugPort := port - 2000
 	debugAddr := fmt.Sprintf(":%d", debugPort)
 	debugListener, err := net.Listen("tcp", debugAddr)
@@ -172,36 +237,33 @@ func (app *_App) Start() {
 		})
 	*/
 
-	// The gRPC listener mounts the Go kit gRPC server we created.
-	grpcAddr := fmt.Sprintf(":%d", port)
-	grpcListener, err := net.Listen("tcp", grpcAddr)
-	if err != nil {
-		logger.Info("transport", "gRPC", "during", "Listen", "err", err)
-		os.Exit(1)
-	}
-	g.Add(func() error {
-		logger.Info("transport", "gRPC", "addr", grpcAddr)
-		baseServer := fmsgrpc.NewServer(app.LogEntry, app.authFunc, app.cfg.Auth)
-		for _, reg := range app.registrars {
-			reg(baseServer)
-		}
-
-		return baseServer.Serve(grpcListener)
-	}, func(error) {
-		grpcListener.Close()
-	})
-
-	// Register to consul
+	// Register to service discovery, unregistering is done by shutdown
+	sdCfg := app.cfg.ServiceDiscovery
 	reg, svc, err := sd.InitServiceDiscovery(&sd.ServiceDiscoverySt{
-		Type:          "consul",
+		Type:          sdCfg.Type,
+		RegAddr:       regAddr(sdCfg),
 		SvcName:       app.cfg.APPName,
-		CheckEndpoint: "/healthcheck",
+		CheckEndpoint: "/healthz/ready",
 		CheckAddr:     fmt.Sprintf("%s:%d", ip, port-2000),
+
+		CheckType:        sdCfg.Check.Type,
+		CheckTLS:         app.cfg.Auth != nil && app.cfg.Auth.TLS,
+		CheckTTL:         sdCfg.Check.TTL,
+		DeregisterAfter:  sdCfg.Check.DeregisterAfter,
+		MaintainInterval: sdCfg.Check.MaintainInterval,
+		TTLStatus:        healthcheck.Status,
+
+		Tags: sdCfg.Tags,
+		Meta: sdCfg.Meta,
 	}, fmt.Sprintf("%s:%d", ip, port))
 	if err != nil {
-		logger.Errorf("Fail to reg to consul: %v", err)
+		logger.Errorf("Fail to reg to %s: %v", sdCfg.Type, err)
 	} else {
-		defer reg.Unregister(svc)
+		app.registeror = reg
+		app.svc = svc
+	}
+	if sdCfg.Type == sd.TypeConsul {
+		healthcheck.AddCheck("consul", healthcheck.ConsulCheck(regAddr(sdCfg)), 0, healthcheck.LevelNonCritical)
 	}
 
 	// This function just sits and waits for ctrl-C.
@@ -223,6 +285,62 @@ func (app *_App) Start() {
 
 }
 
+// regAddr returns registry address for the configured service discovery type
+func regAddr(cfg config.ServiceDiscoveryCfg) string {
+	switch cfg.Type {
+	case sd.TypeConsul:
+		if cfg.Consul == nil || cfg.Consul.Address == "" {
+			return ""
+		}
+		addr := cfg.Consul.Address
+		if !strings.Contains(addr, "://") {
+			scheme := cfg.Consul.Scheme
+			if scheme == "" {
+				scheme = "http"
+			}
+			addr = fmt.Sprintf("%s://%s", scheme, addr)
+		}
+		return addr
+	case sd.TypeFile:
+		return cfg.File
+	}
+
+	return ""
+}
+
+// serverOptions returns options of grpc server by config
+func (app *_App) serverOptions() []fmsgrpc.Option {
+	opts := make([]fmsgrpc.Option, 0)
+
+	if app.cfg.AuthPolicy != "" {
+		policy, err := authz.NewFromFile(app.cfg.AuthPolicy)
+		if err != nil {
+			// deny by default rather than serving without the rules, until the file is fixed
+			logger.Errorf("Fail to load auth policy from %s, deny all calls: %v", app.cfg.AuthPolicy, err)
+		} else {
+			logger.Infof("auth policy loaded from %s", app.cfg.AuthPolicy)
+		}
+		policy.Watch(0)
+		opts = append(opts, fmsgrpc.WithPolicy(policy))
+	}
+
+	if app.cfg.RateLimit != "" {
+		limiter, err := ratelimit.NewFromFile(app.cfg.RateLimit)
+		if err != nil {
+			logger.Errorf("Fail to load rate limit from %s: %v", app.cfg.RateLimit, err)
+		} else {
+			logger.Infof("rate limit loaded from %s", app.cfg.RateLimit)
+			if app.limitStore != nil {
+				limiter.SetStore(app.limitStore)
+			}
+			limiter.Watch(0)
+			opts = append(opts, fmsgrpc.WithRateLimiter(limiter))
+		}
+	}
+
+	return opts
+}
+
 func initConfig(cfgName string) *config.Config {
 	cfgViper := viper.New()
 
@@ -239,7 +357,11 @@ func initConfig(cfgName string) *config.Config {
 	)
 	cfgViper.SetDefault(cfgAuth, &config.AuthConfig{})
 	cfgViper.SetDefault(cfgSD, config.ServiceDiscoveryCfg{
-		Type: "direct",
+		Type: sd.TypeDirect,
+	})
+	cfgViper.SetDefault(cfgShutdown, config.ShutdownCfg{
+		GracePeriod: defaultGracePeriod,
+		Timeout:     defaultShutdownTimeout,
 	})
 
 	cfgViper.SetConfigName(cfgName) // name of config file (without extension)
@@ -260,6 +382,18 @@ func initConfig(cfgName string) *config.Config {
 	props := cfgViper.GetStringMapString("props")
 	cfg.Properties = props
 
+	// hyphenated keys are not matched by unmarshal, setup mannually
+	cfg.UpstreamSetting = cfgViper.GetString(upstreamSetting)
+	cfg.GRPCConfig = cfgViper.GetStringMapString(grpcServiceConfig)
+	cfg.RateLimit = cfgViper.GetString(rateLimit)
+	cfg.AuthPolicy = cfgViper.GetString(authPolicy)
+	if err = cfgViper.UnmarshalKey(cfgSD, &cfg.ServiceDiscovery); err != nil {
+		logger.Warnf("Fail to load %s config: %v", cfgSD, err)
+	}
+	if cfg.ServiceDiscovery.Type == "" {
+		cfg.ServiceDiscovery.Type = sd.TypeDirect
+	}
+
 	logger.WithField("cfg", cfg).Info("setup app config")
 	return cfg
 }
@@ -272,5 +406,12 @@ func initMetrics() {
 
 func initHealthcheck() {
 	http.DefaultServeMux.Handle("/healthcheck", healthcheck.Handler())
-	logger.Info("setup healthcheck /healthcheck")
+	http.DefaultServeMux.Handle("/healthz/live", healthcheck.LiveHandler())
+	http.DefaultServeMux.Handle("/healthz/ready", healthcheck.ReadyHandler())
+	logger.Info("setup healthcheck /healthcheck, /healthz/live, /healthz/ready")
+}
+
+func initAdmin() {
+	http.DefaultServeMux.Handle("/debug/outliers", fmhttp.OutlierHandler())
+	logger.Info("setup admin /debug/outliers")
 }
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/butters-mars/tiki/healthcheck"
+)
+
+const (
+	defaultGracePeriod     = 5  // seconds
+	defaultShutdownTimeout = 10 // seconds
+
+	phaseUnregister = "unregister"
+	phaseOffline    = "offline"
+	phaseGrace      = "grace"
+	phaseDrain      = "drain"
+	phaseForceStop  = "force_stop"
+)
+
+var shutdownPhase metrics.Gauge = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+	Namespace: "tiki",
+	Subsystem: "shutdown",
+	Name:      "phase_seconds",
+	Help:      "Duration of each graceful shutdown phase.",
+}, []string{"phase"})
+
+var shutdownForced metrics.Counter = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Namespace: "tiki",
+	Subsystem: "shutdown",
+	Name:      "forced_total",
+	Help:      "Number of shutdowns that hit the drain timeout and stopped the server forcibly.",
+}, []string{})
+
+// drainer is the server drained on shutdown, which is *grpc.Server
+type drainer interface {
+	GracefulStop()
+	Stop()
+}
+
+// sleep waits for the grace period, replaced in tests
+var sleep = time.Sleep
+
+// shutdown stops the app in order: unregister from service discovery, mark healthcheck
+// offline, wait for the grace period so that clients drop this instance, then drain
+// in-flight calls with GracefulStop, falling back to Stop after the timeout
+func (app *_App) shutdown(server drainer) {
+	gracePeriod := app.cfg.Shutdown.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = defaultGracePeriod
+	}
+	timeout := app.cfg.Shutdown.Timeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	logger.Infof("[Shutdown] start, grace=%ds, timeout=%ds", gracePeriod, timeout)
+
+	runPhase(phaseUnregister, func() {
+		if app.registeror == nil || app.svc == nil {
+			logger.Infof("[Shutdown] not registered, skip unregistering")
+			return
+		}
+		if _, err := app.registeror.Unregister(app.svc); err != nil {
+			logger.Errorf("[Shutdown] fail to unregister %s: %v", app.svc.ID, err)
+		}
+	})
+
+	runPhase(phaseOffline, func() {
+		healthcheck.SetOffline(true)
+	})
+
+	runPhase(phaseGrace, func() {
+		sleep(time.Duration(gracePeriod) * time.Second)
+	})
+
+	if server == nil {
+		return
+	}
+
+	runPhase(phaseDrain, func() {
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Duration(timeout) * time.Second):
+			logger.Warnf("[Shutdown] drain not finished in %ds, force stop", timeout)
+			shutdownForced.Add(1)
+			runPhase(phaseForceStop, server.Stop)
+		}
+	})
+
+	logger.Info("[Shutdown] done")
+}
+
+func runPhase(phase string, fn func()) {
+	logger.Infof("[Shutdown] phase %s ...", phase)
+	start := time.Now()
+	fn()
+	elapsed := time.Since(start)
+	shutdownPhase.With("phase", phase).Set(elapsed.Seconds())
+	logger.Infof("[Shutdown] phase %s finished in %v", phase, elapsed)
+}
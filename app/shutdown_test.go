@@ -0,0 +1,99 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/butters-mars/tiki/config"
+	"github.com/butters-mars/tiki/healthcheck"
+	"github.com/butters-mars/tiki/sd"
+)
+
+type phaseRecorder struct {
+	mutex  sync.Mutex
+	phases []string
+}
+
+func (r *phaseRecorder) add(phase string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.phases = append(r.phases, phase)
+}
+
+func (r *phaseRecorder) get() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string{}, r.phases...)
+}
+
+type testRegisteror struct {
+	r *phaseRecorder
+}
+
+func (t testRegisteror) Register(svc *sd.SvcDef) (interface{}, error) {
+	return nil, nil
+}
+
+func (t testRegisteror) Unregister(svc *sd.SvcDef) (interface{}, error) {
+	t.r.add(fmt.Sprintf("unregister offline=%v", healthcheck.IsOffline()))
+	return nil, nil
+}
+
+// testServer blocks GracefulStop until Stop is called if stuck
+type testServer struct {
+	r       *phaseRecorder
+	stuck   bool
+	stopped chan struct{}
+}
+
+func (s *testServer) GracefulStop() {
+	s.r.add("graceful_stop")
+	if s.stuck {
+		<-s.stopped
+	}
+}
+
+func (s *testServer) Stop() {
+	s.r.add("stop")
+	close(s.stopped)
+}
+
+func TestShutdown(t *testing.T) {
+	defer func(fn func(time.Duration)) { sleep = fn }(sleep)
+	defer healthcheck.SetOffline(false)
+
+	for _, stuck := range []bool{false, true} {
+		healthcheck.SetOffline(false)
+		r := &phaseRecorder{}
+		sleep = func(d time.Duration) {
+			r.add(fmt.Sprintf("sleep %v offline=%v", d, healthcheck.IsOffline()))
+		}
+		app := &_App{
+			cfg:        &config.Config{Shutdown: config.ShutdownCfg{GracePeriod: 3, Timeout: 1}},
+			registeror: testRegisteror{r: r},
+			svc:        &sd.SvcDef{ID: "svc-1"},
+		}
+
+		start := time.Now()
+		app.shutdown(&testServer{r: r, stuck: stuck, stopped: make(chan struct{})})
+		elapsed := time.Since(start)
+
+		expected := []string{"unregister offline=false", "sleep 3s offline=true", "graceful_stop"}
+		if stuck {
+			expected = append(expected, "stop")
+			if elapsed < time.Second {
+				t.Errorf("should force stop after timeout: %v", elapsed)
+			}
+		} else if elapsed >= time.Second {
+			t.Errorf("should not wait for timeout: %v", elapsed)
+		}
+		if phases := r.get(); !reflect.DeepEqual(phases, expected) {
+			t.Errorf("stuck=%v should shutdown in order %v: %v", stuck, expected, phases)
+		}
+	}
+}
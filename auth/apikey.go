@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"google.golang.org/grpc/metadata"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// MetadataAPIKey is the metadata key of api key, bearer token is used if absent
+	MetadataAPIKey = "x-api-key"
+
+	providerAPIKey = "apikey"
+)
+
+var errUnknownKey = errors.New("unknown api key")
+
+// APIKey is a static api key and its owner
+type APIKey struct {
+	Key     string   `yaml:"key"`
+	UID     string   `yaml:"uid"`
+	Name    string   `yaml:"name"`
+	Service string   `yaml:"service"` // calling service of the key, if it's issued to a service
+	Roles   []string `yaml:"roles"`
+	Scopes  []string `yaml:"scopes"`
+}
+
+// APIKeyProvider verifies static api keys
+type APIKeyProvider struct {
+	keys map[[sha256.Size]byte]APIKey // by hash, so that lookup takes no time of key comparison
+	uids map[string]APIKey
+}
+
+// NewAPIKeyProvider creates a provider with the keys
+func NewAPIKeyProvider(keys []APIKey) *APIKeyProvider {
+	p := &APIKeyProvider{
+		keys: make(map[[sha256.Size]byte]APIKey),
+		uids: make(map[string]APIKey),
+	}
+	for _, k := range keys {
+		p.keys[sha256.Sum256([]byte(k.Key))] = k
+		p.uids[k.UID] = k
+	}
+	return p
+}
+
+// NewAPIKeyProviderFromFile creates a provider with keys in a yaml list of APIKey
+func NewAPIKeyProviderFromFile(path string) (*APIKeyProvider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0)
+	if err = yaml.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	return NewAPIKeyProvider(keys), nil
+}
+
+// ExtractToken implements TokenExtractor
+func (p *APIKeyProvider) ExtractToken(ctx context.Context) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(MetadataAPIKey); len(vals) > 0 && vals[0] != "" {
+			return vals[0], nil
+		}
+	}
+	return grpc_auth.AuthFromMD(ctx, "bearer")
+}
+
+// VerifyToken implements Service
+func (p *APIKeyProvider) VerifyToken(ctx context.Context, token interface{}) (uid string, err error) {
+	principal, err := p.ResolvePrincipal(ctx, token)
+	return principal.UID, err
+}
+
+// ResolvePrincipal implements PrincipalResolver
+func (p *APIKeyProvider) ResolvePrincipal(ctx context.Context, token interface{}) (Principal, error) {
+	k, ok := p.keys[sha256.Sum256([]byte(tokenString(token)))]
+	if !ok {
+		return Principal{}, errUnknownKey
+	}
+	return Principal{UID: k.UID, Service: k.Service, Roles: k.Roles, Scopes: k.Scopes}, nil
+}
+
+// GetUserInfo implements Service
+func (p *APIKeyProvider) GetUserInfo(ctx context.Context, uid string) (UserInfo, error) {
+	k, ok := p.uids[uid]
+	if !ok {
+		return UserInfo{}, ErrNoUserInfo
+	}
+	return UserInfo{Name: k.Name, Provider: providerAPIKey}, nil
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/butters-mars/tiki/common"
+)
+
+type testStream struct {
+	grpc.ServerTransportStream
+	method string
+}
+
+func (s *testStream) Method() string {
+	return s.method
+}
+
+// incoming creates the server side context of a call to method with metadata
+func incoming(method string, kv ...string) context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &testStream{method: method})
+	grpc_ctxtags.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(tagged context.Context, req interface{}) (interface{}, error) {
+			ctx = tagged
+			return nil, nil
+		})
+	return ctx
+}
+
+func TestAPIKey(t *testing.T) {
+	p := NewAPIKeyProvider([]APIKey{{Key: "k1", UID: "u1", Name: "svc1"}})
+
+	for _, ctx := range []context.Context{
+		incoming("/a.B/C", MetadataAPIKey, "k1"),
+		incoming("/a.B/C", "authorization", "Bearer k1"),
+	} {
+		token, err := p.ExtractToken(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uid, err := p.VerifyToken(ctx, token); err != nil || uid != "u1" {
+			t.Errorf("should verify key: [%s] %v", uid, err)
+		}
+	}
+
+	if _, err := p.VerifyToken(context.TODO(), "k2"); err != errUnknownKey {
+		t.Errorf("should reject unknown key: %v", err)
+	}
+	if info, err := p.GetUserInfo(context.TODO(), "u1"); err != nil || info.Name != "svc1" {
+		t.Errorf("should get user info: %v %v", info, err)
+	}
+	if _, err := p.GetUserInfo(context.TODO(), "u2"); err != ErrNoUserInfo {
+		t.Errorf("should get no user info: %v", err)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	p := NewHMACProvider([]HMACKey{{ID: "id1", Secret: "s1", UID: "u1"}}, 60)
+	method := "/a.B/C"
+
+	digest, _ := ContentDigest(nil)
+	signed := func(keyID, secret string, at time.Time, method string) context.Context {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		return incoming("/a.B/C",
+			MetadataKeyID, keyID,
+			MetadataTimestamp, ts,
+			MetadataContentDigest, digest,
+			MetadataSignature, Sign([]byte(secret), keyID, ts, method, digest))
+	}
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		uid  string
+	}{
+		{"signed", signed("id1", "s1", time.Now(), method), "u1"},
+		{"wrong secret", signed("id1", "s2", time.Now(), method), ""},
+		{"unknown key", signed("id2", "s1", time.Now(), method), ""},
+		{"other method", signed("id1", "s1", time.Now(), "/a.B/D"), ""},
+		{"too old", signed("id1", "s1", time.Now().Add(-2*time.Minute), method), ""},
+		{"too new", signed("id1", "s1", time.Now().Add(2*time.Minute), method), ""},
+		{"unsigned", incoming(method), ""},
+	}
+	for _, c := range cases {
+		var uid string
+		token, err := p.ExtractToken(c.ctx)
+		if err == nil {
+			uid, err = p.VerifyToken(c.ctx, token)
+		}
+		if uid != c.uid || (c.uid == "") != (err != nil) {
+			t.Errorf("%s: should get [%s]: [%s] %v", c.name, c.uid, uid, err)
+		}
+	}
+
+	// signer puts signature into outgoing metadata
+	var out metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		out, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	req := &wrappers.StringValue{Value: "hello"}
+	NewHMACSigner("id1", "s1").UnaryClientInterceptor()(context.Background(), method, req, nil, nil, invoker)
+	ctx := incoming(method, MetadataKeyID, out.Get(MetadataKeyID)[0],
+		MetadataTimestamp, out.Get(MetadataTimestamp)[0],
+		MetadataContentDigest, out.Get(MetadataContentDigest)[0],
+		MetadataSignature, out.Get(MetadataSignature)[0])
+	token, _ := p.ExtractToken(ctx)
+	if uid, err := p.VerifyToken(ctx, token); err != nil || uid != "u1" {
+		t.Errorf("should verify signed call: [%s] %v", uid, err)
+	}
+
+	// the signed digest must match the request message
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil }
+	check := UnaryContentDigestInterceptor()
+	if _, err := check(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler); err != nil {
+		t.Errorf("should accept signed content: %v", err)
+	}
+	tampered := &wrappers.StringValue{Value: "bye"}
+	if _, err := check(ctx, tampered, &grpc.UnaryServerInfo{FullMethod: method}, handler); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("should reject tampered content: %v", err)
+	}
+	if _, err := check(incoming(method), tampered, &grpc.UnaryServerInfo{FullMethod: method}, handler); err != nil {
+		t.Errorf("should pass unsigned call: %v", err)
+	}
+
+	// a replayed signature doesn't verify with other content
+	forged := incoming(method, MetadataKeyID, out.Get(MetadataKeyID)[0],
+		MetadataTimestamp, out.Get(MetadataTimestamp)[0],
+		MetadataContentDigest, digest,
+		MetadataSignature, out.Get(MetadataSignature)[0])
+	token, _ = p.ExtractToken(forged)
+	if _, err := p.VerifyToken(forged, token); err != errSignature {
+		t.Errorf("should reject replayed signature with other digest: %v", err)
+	}
+}
+
+func TestAuthFunc(t *testing.T) {
+	fn := AuthFunc(NewAPIKeyProvider([]APIKey{
+		{Key: "k1", UID: "u1"},
+		{Key: "k3", UID: "u3", Service: "svc-a", Roles: []string{"admin"}},
+	}), "/a.Public/", "/a.B/Open")
+
+	ctx, err := fn(incoming("/a.B/C", MetadataAPIKey, "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, _ := ctx.Value(common.KeyUID{}).(string); uid != "u1" {
+		t.Errorf("should set uid: %s", uid)
+	}
+	if uid := grpc_ctxtags.Extract(ctx).Values()[TagUID]; uid != "u1" {
+		t.Errorf("should tag uid: %v", uid)
+	}
+	if ctx.Value(common.KeyIdentity{}) != nil {
+		t.Errorf("should not set identity without service")
+	}
+
+	ctx, err = fn(incoming("/a.B/C", MetadataAPIKey, "k3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := ctx.Value(common.KeyIdentity{}).(string); id != "svc-a" {
+		t.Errorf("should set identity: %s", id)
+	}
+	if p, _ := PrincipalFromContext(ctx); p.UID != "u3" || !p.HasRole("admin") {
+		t.Errorf("should set principal: %+v", p)
+	}
+
+	for _, method := range []string{"/a.B/C", "/a.B/Open2"} {
+		for _, ctx := range []context.Context{incoming(method), incoming(method, MetadataAPIKey, "k2")} {
+			if _, err = fn(ctx); status.Code(err) != codes.Unauthenticated {
+				t.Errorf("%s should be unauthenticated: %v", method, err)
+			}
+		}
+	}
+
+	for _, method := range []string{"/a.Public/Any", "/a.B/Open", "/grpc.health.v1.Health/Check"} {
+		ctx, err = fn(incoming(method))
+		if err != nil {
+			t.Errorf("%s should be public: %v", method, err)
+		} else if ctx.Value(common.KeyUID{}) != nil {
+			t.Errorf("%s should be anonymous", method)
+		}
+	}
+}
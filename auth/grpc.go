@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/butters-mars/tiki/common"
+	"github.com/butters-mars/tiki/logging"
+)
+
+const (
+	// TagUID is the ctxtags key of authenticated uid, which is logged with the call
+	TagUID = "auth.uid"
+	// TagService is the ctxtags key of authenticated calling service
+	TagService = "auth.service"
+)
+
+// AuthFunc adapts the service to grpc_auth.AuthFunc, uid of verified token is put into context
+// under common.KeyUID, and the Principal if the service is a PrincipalResolver. Calls of public
+// methods (full methods or /package.Service/) and health checks are let through without uid if
+// not authenticated.
+func AuthFunc(s Service, public ...string) grpc_auth.AuthFunc {
+	return func(ctx context.Context) (context.Context, error) {
+		method, _ := grpc.Method(ctx)
+
+		principal, err := authenticate(ctx, s)
+		if err != nil {
+			if isPublic(method, public) {
+				return ctx, nil
+			}
+			logging.FromContext(ctx).Warnf("[Auth] %s unauthenticated: %v", method, err)
+			return nil, err
+		}
+
+		tags := grpc_ctxtags.Extract(ctx)
+		tags.Set(TagUID, principal.UID)
+		if id, _ := ctx.Value(common.KeyIdentity{}).(string); id != "" {
+			// identity of transport, e.g. client certificate, takes precedence
+			principal.Service = id
+		} else if principal.Service != "" {
+			tags.Set(TagService, principal.Service)
+			ctx = context.WithValue(ctx, common.KeyIdentity{}, principal.Service)
+		}
+		ctx = NewContextWithPrincipal(ctx, principal)
+		return context.WithValue(ctx, common.KeyUID{}, principal.UID), nil
+	}
+}
+
+func authenticate(ctx context.Context, s Service) (principal Principal, err error) {
+	var token interface{}
+	if extractor, ok := s.(TokenExtractor); ok {
+		token, err = extractor.ExtractToken(ctx)
+	} else {
+		token, err = grpc_auth.AuthFromMD(ctx, "bearer")
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = status.Error(codes.Unauthenticated, err.Error())
+		}
+		return
+	}
+
+	if resolver, ok := s.(PrincipalResolver); ok {
+		principal, err = resolver.ResolvePrincipal(ctx, token)
+	} else {
+		principal.UID, err = s.VerifyToken(ctx, token)
+	}
+	if err != nil {
+		return Principal{}, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+	return
+}
+
+func isPublic(method string, public []string) bool {
+	if strings.HasPrefix(method, common.HealthService) {
+		return true
+	}
+	for _, m := range public {
+		if m == method || (strings.HasSuffix(m, "/") && strings.HasPrefix(method, m)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MetadataKeyID is the metadata key of signing key id
+	MetadataKeyID = "x-auth-key-id"
+	// MetadataTimestamp is the metadata key of signing time in unix seconds
+	MetadataTimestamp = "x-auth-timestamp"
+	// MetadataSignature is the metadata key of hex signature
+	MetadataSignature = "x-auth-signature"
+	// MetadataContentDigest is the metadata key of hex sha256 of the request message
+	MetadataContentDigest = "x-auth-content-sha256"
+
+	defaultMaxSkew = 300 // seconds
+)
+
+var (
+	errUnsigned = errors.New("request not signed")
+	errSkew     = errors.New("signing time out of range")
+	errDigest   = errors.New("request content does not match signed digest")
+)
+
+// HMACKey is a signing secret and its owner
+type HMACKey struct {
+	ID      string   `yaml:"id"`
+	Secret  string   `yaml:"secret"`
+	UID     string   `yaml:"uid"`
+	Service string   `yaml:"service"` // calling service of the key, if it's issued to a service
+	Roles   []string `yaml:"roles"`
+	Scopes  []string `yaml:"scopes"`
+}
+
+// SignedRequest is the token of HMACProvider
+type SignedRequest struct {
+	KeyID     string
+	Timestamp string
+	Method    string
+	Digest    string
+	Signature string
+}
+
+// Sign returns hex hmac-sha256 of key id, timestamp, full method and content digest of the call
+func Sign(secret []byte, keyID, timestamp, method, digest string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", keyID, timestamp, method, digest)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ContentDigest returns hex sha256 of the deterministic encoding of the request message,
+// requests which are not proto messages, such as streams, are digested as empty content
+func ContentDigest(req interface{}) (string, error) {
+	var data []byte
+	if m, ok := req.(proto.Message); ok && m != nil {
+		buf := proto.NewBuffer(nil)
+		buf.SetDeterministic(true)
+		if err := buf.Marshal(m); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// HMACProvider verifies signed requests, signing time must be within max skew of now
+type HMACProvider struct {
+	keys    map[string]HMACKey
+	maxSkew time.Duration
+}
+
+// NewHMACProvider creates a provider with the keys, maxSkew in seconds defaults to 300
+func NewHMACProvider(keys []HMACKey, maxSkew int) *HMACProvider {
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+
+	p := &HMACProvider{
+		keys:    make(map[string]HMACKey),
+		maxSkew: time.Duration(maxSkew) * time.Second,
+	}
+	for _, k := range keys {
+		p.keys[k.ID] = k
+	}
+	return p
+}
+
+// ExtractToken implements TokenExtractor, returns SignedRequest of the call
+func (p *HMACProvider) ExtractToken(ctx context.Context) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if vals := md.Get(key); len(vals) > 0 {
+			return vals[0]
+		}
+		return ""
+	}
+
+	req := SignedRequest{
+		KeyID:     get(MetadataKeyID),
+		Timestamp: get(MetadataTimestamp),
+		Digest:    get(MetadataContentDigest),
+		Signature: get(MetadataSignature),
+	}
+	req.Method, _ = grpc.Method(ctx)
+	if req.KeyID == "" || req.Signature == "" {
+		return nil, errUnsigned
+	}
+	return req, nil
+}
+
+// VerifyToken implements Service, token is SignedRequest
+func (p *HMACProvider) VerifyToken(ctx context.Context, token interface{}) (uid string, err error) {
+	principal, err := p.ResolvePrincipal(ctx, token)
+	return principal.UID, err
+}
+
+// ResolvePrincipal implements PrincipalResolver
+func (p *HMACProvider) ResolvePrincipal(ctx context.Context, token interface{}) (Principal, error) {
+	req, ok := token.(SignedRequest)
+	if !ok {
+		return Principal{}, errUnsigned
+	}
+
+	k, ok := p.keys[req.KeyID]
+	if !ok {
+		return Principal{}, fmt.Errorf("unknown key %s", req.KeyID)
+	}
+
+	ts, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return Principal{}, errSkew
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > p.maxSkew || skew < -p.maxSkew {
+		return Principal{}, errSkew
+	}
+
+	expected := Sign([]byte(k.Secret), req.KeyID, req.Timestamp, req.Method, req.Digest)
+	if !hmac.Equal([]byte(expected), []byte(req.Signature)) {
+		return Principal{}, errSignature
+	}
+	return Principal{UID: k.UID, Service: k.Service, Roles: k.Roles, Scopes: k.Scopes}, nil
+}
+
+// UnaryContentDigestInterceptor rejects signed unary calls whose request message does not
+// match the signed digest, it's installed after auth since the signature covers the digest.
+// Messages of streams are not covered
+func UnaryContentDigestInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if len(md.Get(MetadataSignature)) == 0 {
+			return handler(ctx, req)
+		}
+
+		digest, err := ContentDigest(req)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "fail to digest request: %v", err)
+		}
+		signed := md.Get(MetadataContentDigest)
+		if len(signed) == 0 || !hmac.Equal([]byte(signed[0]), []byte(digest)) {
+			return nil, status.Error(codes.Unauthenticated, errDigest.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GetUserInfo implements Service, user info is not provided by signing keys
+func (p *HMACProvider) GetUserInfo(ctx context.Context, uid string) (UserInfo, error) {
+	return UserInfo{}, ErrNoUserInfo
+}
+
+// HMACSigner signs outgoing calls for HMACProvider
+type HMACSigner struct {
+	keyID  string
+	secret []byte
+}
+
+// NewHMACSigner creates a signer with the key
+func NewHMACSigner(keyID, secret string) *HMACSigner {
+	return &HMACSigner{keyID: keyID, secret: []byte(secret)}
+}
+
+func (s *HMACSigner) sign(ctx context.Context, method string, req interface{}) (context.Context, error) {
+	digest, err := ContentDigest(req)
+	if err != nil {
+		return ctx, fmt.Errorf("fail to digest request: %v", err)
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	return metadata.AppendToOutgoingContext(ctx,
+		MetadataKeyID, s.keyID,
+		MetadataTimestamp, ts,
+		MetadataContentDigest, digest,
+		MetadataSignature, Sign(s.secret, s.keyID, ts, method, digest)), nil
+}
+
+// UnaryClientInterceptor signs unary calls with the request message
+func (s *HMACSigner) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := s.sign(ctx, method, req)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor signs streams, messages are not signed
+func (s *HMACSigner) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := s.sign(ctx, method, nil)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,459 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AlgRS256 RSASSA-PKCS1-v1_5 with SHA-256
+	AlgRS256 = "RS256"
+	// AlgES256 ECDSA with P-256 and SHA-256
+	AlgES256 = "ES256"
+	// AlgHS256 HMAC with SHA-256
+	AlgHS256 = "HS256"
+
+	defaultUIDClaim        = "sub"
+	defaultRolesClaim      = "roles"
+	defaultScopeClaim      = "scope"
+	defaultLeeway          = 60  // seconds
+	defaultRefreshInterval = 300 // seconds
+	minRefreshInterval     = 30 * time.Second
+	fetchTimeout           = 10 * time.Second
+)
+
+var (
+	errMalformed  = errors.New("malformed token")
+	errNoKey      = errors.New("no key to verify token")
+	errSignature  = errors.New("invalid signature")
+	errExpired    = errors.New("token expired")
+	errNotYet     = errors.New("token not valid yet")
+	errNoUIDClaim = errors.New("no uid in token")
+)
+
+// JWTConfig config of JWTProvider, keys are loaded from jwks file or url, and secret if given
+type JWTConfig struct {
+	JWKSFile        string `yaml:"jwks_file"`
+	JWKSURL         string `yaml:"jwks_url"`
+	Secret          string `yaml:"secret"`           // shared secret of HS256, besides oct keys in jwks
+	Issuer          string `yaml:"issuer"`           // expected iss, empty to skip check
+	Audience        string `yaml:"audience"`         // expected aud, empty to skip check
+	UIDClaim        string `yaml:"uid_claim"`        // claim of uid, default sub
+	RolesClaim      string `yaml:"roles_claim"`      // claim of role list, default roles
+	ScopeClaim      string `yaml:"scope_claim"`      // claim of space separated scopes or scope list, default scope
+	ServiceClaim    string `yaml:"service_claim"`    // claim of calling service, e.g. azp, empty to skip
+	Leeway          int    `yaml:"leeway"`           // seconds of clock skew allowed, default 60
+	RefreshInterval int    `yaml:"refresh_interval"` // seconds between jwks refreshes, default 300
+}
+
+type jwtKey struct {
+	kid string
+	alg string
+	key interface{} // *rsa.PublicKey, *ecdsa.PublicKey or []byte
+}
+
+// JWTProvider verifies RS256, ES256 and HS256 tokens, jwks is refreshed periodically and
+// when an unknown kid shows up, so that rotated keys are picked up
+type JWTProvider struct {
+	cfg    JWTConfig
+	client *http.Client
+
+	mutex     *sync.RWMutex
+	keys      []*jwtKey
+	fetchedAt time.Time
+	fetching  bool
+}
+
+// NewJWTProvider creates a JWT provider, keys are loaded at once
+func NewJWTProvider(cfg JWTConfig) (*JWTProvider, error) {
+	if cfg.JWKSFile == "" && cfg.JWKSURL == "" && cfg.Secret == "" {
+		return nil, fmt.Errorf("no jwks file, url or secret")
+	}
+	if cfg.UIDClaim == "" {
+		cfg.UIDClaim = defaultUIDClaim
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = defaultRolesClaim
+	}
+	if cfg.ScopeClaim == "" {
+		cfg.ScopeClaim = defaultScopeClaim
+	}
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = defaultLeeway
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = defaultRefreshInterval
+	}
+
+	p := &JWTProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: fetchTimeout},
+		mutex:  &sync.RWMutex{},
+	}
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *JWTProvider) loadKeys() ([]*jwtKey, error) {
+	keys := make([]*jwtKey, 0)
+	if p.cfg.Secret != "" {
+		keys = append(keys, &jwtKey{alg: AlgHS256, key: []byte(p.cfg.Secret)})
+	}
+
+	var data []byte
+	var err error
+	if p.cfg.JWKSFile != "" {
+		data, err = ioutil.ReadFile(p.cfg.JWKSFile)
+	} else if p.cfg.JWKSURL != "" {
+		data, err = p.fetch(p.cfg.JWKSURL)
+	} else {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, set...), nil
+}
+
+func (p *JWTProvider) fetch(url string) ([]byte, error) {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to fetch %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (p *JWTProvider) refresh() error {
+	keys, err := p.loadKeys()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.fetchedAt = time.Now()
+	p.fetching = false
+	if err != nil {
+		return err
+	}
+	p.keys = keys
+	return nil
+}
+
+// keysFor returns keys to verify a token with the kid, keys are refreshed in background if
+// stale, or the kid is unknown and no refresh in the last 30s. At most one refresh runs at a
+// time, so tokens of made-up kids can't make every call wait for or trigger a fetch
+func (p *JWTProvider) keysFor(kid string) []*jwtKey {
+	p.mutex.Lock()
+	found := false
+	for _, k := range p.keys {
+		if k.kid == kid {
+			found = true
+			break
+		}
+	}
+	since := time.Since(p.fetchedAt)
+	stale := since > time.Duration(p.cfg.RefreshInterval)*time.Second || (!found && kid != "" && since > minRefreshInterval)
+	if stale && !p.fetching && (p.cfg.JWKSFile != "" || p.cfg.JWKSURL != "") {
+		p.fetching = true
+		go func() {
+			if err := p.refresh(); err != nil {
+				logger.Errorf("[Auth] fail to refresh jwks, keep current keys: %v", err)
+			}
+		}()
+	}
+	keys := p.keys
+	p.mutex.Unlock()
+
+	matched := make([]*jwtKey, 0, len(keys))
+	for _, k := range keys {
+		if kid == "" || k.kid == kid {
+			matched = append(matched, k)
+		}
+	}
+	return matched
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// VerifyToken implements Service, token is the compact jwt string
+func (p *JWTProvider) VerifyToken(ctx context.Context, token interface{}) (uid string, err error) {
+	principal, err := p.ResolvePrincipal(ctx, token)
+	return principal.UID, err
+}
+
+// ResolvePrincipal implements PrincipalResolver, roles, scopes and service are read from
+// claims configured
+func (p *JWTProvider) ResolvePrincipal(ctx context.Context, token interface{}) (principal Principal, err error) {
+	claims, err := p.Verify(tokenString(token))
+	if err != nil {
+		return
+	}
+
+	principal.UID, _ = claims[p.cfg.UIDClaim].(string)
+	if principal.UID == "" {
+		return Principal{}, errNoUIDClaim
+	}
+	principal.Roles = stringList(claims[p.cfg.RolesClaim])
+	principal.Scopes = stringList(claims[p.cfg.ScopeClaim])
+	if p.cfg.ServiceClaim != "" {
+		principal.Service, _ = claims[p.cfg.ServiceClaim].(string)
+	}
+	return
+}
+
+// GetUserInfo implements Service, user info is not provided by jwt
+func (p *JWTProvider) GetUserInfo(ctx context.Context, uid string) (UserInfo, error) {
+	return UserInfo{}, ErrNoUserInfo
+}
+
+// Verify verifies the token and returns its claims
+func (p *JWTProvider) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformed
+	}
+
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformed
+	}
+
+	verified := false
+	signed := []byte(parts[0] + "." + parts[1])
+	for _, k := range p.keysFor(header.Kid) {
+		if verifySignature(k, header.Alg, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errSignature
+	}
+
+	claims := make(map[string]interface{})
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, errMalformed
+	}
+	if err = p.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (p *JWTProvider) checkClaims(claims map[string]interface{}) error {
+	now := float64(time.Now().Unix())
+	leeway := float64(p.cfg.Leeway)
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now > exp+leeway {
+		return errExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now+leeway < nbf {
+		return errNotYet
+	}
+
+	if p.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != p.cfg.Issuer {
+			return fmt.Errorf("unexpected issuer %s", iss)
+		}
+	}
+
+	if p.cfg.Audience != "" {
+		matched := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			matched = aud == p.cfg.Audience
+		case []interface{}:
+			for _, a := range aud {
+				if a == p.cfg.Audience {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return fmt.Errorf("unexpected audience %v", claims["aud"])
+		}
+	}
+
+	return nil
+}
+
+// verifySignature verifies with the key only if alg fits the key type, so that e.g. a public
+// key is never used as HMAC secret
+func verifySignature(k *jwtKey, alg string, signed, sig []byte) bool {
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+
+	digest := sha256.Sum256(signed)
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return alg == AlgRS256 && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if alg != AlgES256 || key.Curve != elliptic.P256() || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	case []byte:
+		if alg != AlgHS256 {
+			return false
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// parseJWKS parses keys of a jwk set, keys not for signature or of unsupported types are skipped
+func parseJWKS(data []byte) ([]*jwtKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*jwtKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("bad key %s: %v", k.Kid, err)
+		}
+		if key == nil {
+			logger.Warnf("[Auth] skip key %s of unsupported type %s %s", k.Kid, k.Kty, k.Crv)
+			continue
+		}
+		keys = append(keys, &jwtKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, errNoKey
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return key, nil
+	case "oct":
+		return decode(k.K)
+	}
+	return nil, nil
+}
+
+func tokenString(token interface{}) string {
+	switch t := token.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	}
+	return ""
+}
+
+// stringList returns a claim of string list or space separated string
+func stringList(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		vals := make([]string, 0, len(c))
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+		return vals
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// sign creates a compact jwt signed by key (*rsa.PrivateKey, *ecdsa.PrivateKey or []byte)
+func sign(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	default:
+		t.Fatalf("unknown key %T", key)
+	}
+
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64.EncodeToString(key.N.Bytes()),
+		"e": b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64.EncodeToString(key.X.Bytes()),
+		"y": b64.EncodeToString(key.Y.Bytes()),
+	}
+}
+
+func jwks(keys ...map[string]string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return data
+}
+
+func claims(sub string, exp time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"sub": sub,
+		"iss": "tiki",
+		"aud": []string{"api", "web"},
+		"exp": time.Now().Add(exp).Unix(),
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	secret := []byte("secret")
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(path, jwks(rsaJWK("r1", rsaKey), ecJWK("e1", ecKey)), 0644)
+
+	p, err := NewJWTProvider(JWTConfig{JWKSFile: path, Secret: string(secret), Issuer: "tiki", Audience: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaPub, _ := json.Marshal(rsaJWK("r1", rsaKey))
+	cases := []struct {
+		name  string
+		token string
+		uid   string
+	}{
+		{"rs256", sign(t, AlgRS256, "r1", rsaKey, claims("u1", time.Hour)), "u1"},
+		{"es256", sign(t, AlgES256, "e1", ecKey, claims("u2", time.Hour)), "u2"},
+		{"hs256", sign(t, AlgHS256, "", secret, claims("u3", time.Hour)), "u3"},
+		{"expired", sign(t, AlgRS256, "r1", rsaKey, claims("u1", -time.Hour)), ""},
+		{"within leeway", sign(t, AlgRS256, "r1", rsaKey, claims("u1", -30*time.Second)), "u1"},
+		{"wrong key", sign(t, AlgRS256, "r1", otherKey, claims("u1", time.Hour)), ""},
+		{"public key as hmac secret", sign(t, AlgHS256, "r1", rsaPub, claims("u1", time.Hour)), ""},
+		{"none", b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"u1"}`)) + ".", ""},
+		{"malformed", "abc", ""},
+	}
+	for _, c := range cases {
+		uid, err := p.VerifyToken(context.TODO(), c.token)
+		if uid != c.uid || (c.uid == "") != (err != nil) {
+			t.Errorf("%s: should get [%s]: [%s] %v", c.name, c.uid, uid, err)
+		}
+	}
+
+	withRoles := claims("u1", time.Hour)
+	withRoles["roles"] = []string{"admin"}
+	withRoles["scope"] = "b.read b.write"
+	principal, err := p.ResolvePrincipal(context.TODO(), sign(t, AlgRS256, "r1", rsaKey, withRoles))
+	if err != nil || !principal.HasRole("admin") || !principal.HasScope("b.write") || principal.HasScope("b") {
+		t.Errorf("should resolve roles and scopes: %+v %v", principal, err)
+	}
+
+	bad := claims("u1", time.Hour)
+	bad["aud"] = "web"
+	if _, err = p.VerifyToken(context.TODO(), sign(t, AlgRS256, "r1", rsaKey, bad)); err == nil {
+		t.Errorf("should check audience")
+	}
+	bad = claims("u1", time.Hour)
+	bad["iss"] = "other"
+	if _, err = p.VerifyToken(context.TODO(), sign(t, AlgRS256, "r1", rsaKey, bad)); err == nil {
+		t.Errorf("should check issuer")
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	mutex := sync.Mutex{}
+	keys := jwks(rsaJWK("old", oldKey))
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetches++
+		w.Write(keys)
+	}))
+	defer srv.Close()
+
+	p, err := NewJWTProvider(JWTConfig{JWKSURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, err := p.VerifyToken(context.TODO(), sign(t, AlgRS256, "old", oldKey, claims("u1", time.Hour))); err != nil || uid != "u1" {
+		t.Fatalf("should verify with cached key: %v", err)
+	}
+
+	mutex.Lock()
+	keys = jwks(rsaJWK("new", newKey))
+	mutex.Unlock()
+
+	token := sign(t, AlgRS256, "new", newKey, claims("u2", time.Hour))
+	if _, err = p.VerifyToken(context.TODO(), token); err == nil {
+		t.Fatalf("unknown kid should not refresh within 30s of last fetch")
+	}
+
+	// last fetch long enough ago, keys are refreshed in background once for all calls
+	p.mutex.Lock()
+	p.fetchedAt = time.Now().Add(-time.Minute)
+	p.mutex.Unlock()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p.VerifyToken(context.TODO(), sign(t, AlgRS256, fmt.Sprintf("random-%d", i), newKey, claims("u2", time.Hour)))
+		}(i)
+	}
+	wg.Wait()
+	var uid string
+	for i := 0; i < 100; i++ {
+		if uid, err = p.VerifyToken(context.TODO(), token); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil || uid != "u2" {
+		t.Errorf("should refresh for unknown kid: %v", err)
+	}
+	mutex.Lock()
+	if fetches != 2 {
+		t.Errorf("should fetch twice: %d", fetches)
+	}
+	mutex.Unlock()
+
+	// keep keys if jwks url fails
+	srv.Close()
+	p.mutex.Lock()
+	p.fetchedAt = time.Time{}
+	p.mutex.Unlock()
+	if _, err = p.VerifyToken(context.TODO(), token); err != nil {
+		t.Errorf("should keep cached keys: %v", err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	if _, err := parseJWKS([]byte(`{"keys":[{"kty":"OKP","kid":"x"}]}`)); err != errNoKey {
+		t.Errorf("unsupported keys should be skipped: %v", err)
+	}
+	if _, err := parseJWKS([]byte(fmt.Sprintf(`{"keys":[{"kty":"EC","crv":"P-256","x":"%s","y":"%s"}]}`,
+		b64.EncodeToString([]byte{1}), b64.EncodeToString([]byte{2})))); err == nil {
+		t.Errorf("should reject point not on curve")
+	}
+}
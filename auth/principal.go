@@ -0,0 +1,49 @@
+package auth
+
+import "context"
+
+// Principal is the authenticated caller of a call
+type Principal struct {
+	UID     string
+	Service string // calling service, put into context as common.KeyIdentity
+	Roles   []string
+	Scopes  []string
+}
+
+// HasRole returns whether the principal has the role
+func (p Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+// HasScope returns whether the principal is granted the scope
+func (p Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+// PrincipalResolver is implemented by services knowing roles and scopes of tokens,
+// AuthFunc uses it instead of VerifyToken
+type PrincipalResolver interface {
+	ResolvePrincipal(ctx context.Context, token interface{}) (Principal, error)
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns principal put into context by AuthFunc
+func PrincipalFromContext(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(principalKey{}).(Principal)
+	return
+}
+
+// NewContextWithPrincipal returns a context carrying the principal
+func NewContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
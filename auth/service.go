@@ -1,6 +1,16 @@
 package auth
 
-import "context"
+import (
+	"context"
+	"errors"
+
+	"github.com/butters-mars/tiki/logging"
+)
+
+var logger = logging.L
+
+// ErrNoUserInfo is returned by GetUserInfo of providers not knowing user info
+var ErrNoUserInfo = errors.New("user info not provided")
 
 type UserInfo struct {
 	Name          string
@@ -18,3 +28,9 @@ type Service interface {
 	VerifyToken(ctx context.Context, token interface{}) (uid string, err error)
 	GetUserInfo(ctx context.Context, uid string) (UserInfo, error)
 }
+
+// TokenExtractor is implemented by services whose token is not the bearer token
+// in authorization metadata
+type TokenExtractor interface {
+	ExtractToken(ctx context.Context) (token interface{}, err error)
+}
@@ -0,0 +1,48 @@
+package authz
+
+import (
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// AuditDeny logs denied calls only
+	AuditDeny = "deny"
+	// AuditAll logs all decisions
+	AuditAll = "all"
+	// AuditNone logs nothing
+	AuditNone = "none"
+)
+
+// Rule authorizes calls of its methods, a call must meet all of the requirements given,
+// a rule without any only requires the caller to be authenticated
+type Rule struct {
+	Methods  []string `yaml:"methods"`  // full methods, /package.Service/ or *
+	Public   bool     `yaml:"public"`   // allow any call, authenticated or not
+	Roles    []string `yaml:"roles"`    // caller has any of the roles
+	Scopes   []string `yaml:"scopes"`   // caller is granted all of the scopes
+	Services []string `yaml:"services"` // caller is one of the services, or of SPIFFE IDs of client certificates
+}
+
+// Config defines rules of a server, a call is ruled by the rule of its full method, or else
+// its service, or else *, and denied if there is none
+type Config struct {
+	Rules  []Rule `yaml:"rules"`
+	DryRun bool   `yaml:"dry_run"` // log and count denials without rejecting calls
+	Audit  string `yaml:"audit"`   // deny, all or none, default is deny
+}
+
+// LoadConfig loads config from a yaml file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,374 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/butters-mars/tiki/auth"
+	"github.com/butters-mars/tiki/common"
+	"github.com/butters-mars/tiki/logging"
+)
+
+const (
+	decisionAllow  = "allow"
+	decisionDeny   = "deny"
+	decisionDryRun = "dry_run_deny"
+
+	anyMethod = "*"
+
+	defaultWatchInterval = 5 * time.Second
+)
+
+var logger = logging.L
+
+var decisions metrics.Counter = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Namespace: "service",
+	Subsystem: "grpc",
+	Name:      "authz",
+	Help:      "Authorization decisions of calls.",
+}, []string{"method", "decision"})
+
+// Decision is the result of authorizing a call
+type Decision struct {
+	Allowed bool
+	Rule    string // method pattern of the rule applied, empty if none
+	Reason  string
+}
+
+type ruleSet struct {
+	cfg   Config
+	rules map[string]*Rule // by full method, /package.Service/ or *
+}
+
+func newRuleSet(cfg Config) *ruleSet {
+	rs := &ruleSet{
+		cfg:   cfg,
+		rules: make(map[string]*Rule),
+	}
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		for _, m := range r.Methods {
+			if _, found := rs.rules[m]; found {
+				logger.Warnf("[Authz] duplicated rule of %s, the first is applied", m)
+				continue
+			}
+			rs.rules[m] = r
+		}
+	}
+	return rs
+}
+
+// match returns the rule of the method and its pattern
+func (rs *ruleSet) match(fullMethod string) (*Rule, string) {
+	if r, found := rs.rules[fullMethod]; found {
+		return r, fullMethod
+	}
+	if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+		service := fullMethod[:i+1]
+		if r, found := rs.rules[service]; found {
+			return r, service
+		}
+	}
+	if r, found := rs.rules[anyMethod]; found {
+		return r, anyMethod
+	}
+	return nil, ""
+}
+
+// Policy authorizes calls of a server by per-method rules
+type Policy struct {
+	mutex *sync.RWMutex
+	rs    *ruleSet
+
+	path    string
+	modTime time.Time
+	size    int64
+	stop    chan struct{}
+}
+
+// New creates a policy with the config
+func New(cfg *Config) *Policy {
+	p := &Policy{
+		mutex: &sync.RWMutex{},
+	}
+	p.SetConfig(cfg)
+	return p
+}
+
+// NewFromFile creates a policy with config in the yaml file, which is reloaded when changed
+// once Watch is called. If the file fails to load, the policy is returned with the error,
+// it denies all calls until a valid file is reloaded
+func NewFromFile(path string) (*Policy, error) {
+	p := New(nil)
+	p.path = path
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return p, err
+	}
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return p, err
+	}
+	p.SetConfig(cfg)
+	return p, nil
+}
+
+// SetConfig changes rules
+func (p *Policy) SetConfig(cfg *Config) {
+	c := Config{}
+	if cfg != nil {
+		c = *cfg
+	}
+	rs := newRuleSet(c)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.rs = rs
+}
+
+// Watch starts polling the config file with given interval
+func (p *Policy) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	p.mutex.Lock()
+	if p.path == "" || p.stop != nil {
+		p.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	p.stop = stop
+	p.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.Reload()
+			}
+		}
+	}()
+}
+
+// StopWatch stops watching the config file
+func (p *Policy) StopWatch() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// Reload reloads the config file if it's changed
+func (p *Policy) Reload() {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		logger.Errorf("[Authz] fail to stat %s: %v", p.path, err)
+		return
+	}
+
+	p.mutex.RLock()
+	unchanged := info.ModTime().Equal(p.modTime) && info.Size() == p.size
+	p.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	cfg, err := LoadConfig(p.path)
+	if err != nil {
+		logger.Errorf("[Authz] fail to reload %s, keep current rules: %v", p.path, err)
+		return
+	}
+
+	p.SetConfig(cfg)
+	p.mutex.Lock()
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+	p.mutex.Unlock()
+	logger.Infof("[Authz] reloaded from %s", p.path)
+}
+
+func (p *Policy) ruleSet() *ruleSet {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.rs
+}
+
+// IsPublic returns whether calls of the method are allowed without authentication
+func (p *Policy) IsPublic(fullMethod string) bool {
+	if strings.HasPrefix(fullMethod, common.HealthService) {
+		return true
+	}
+	r, _ := p.ruleSet().match(fullMethod)
+	return r != nil && r.Public
+}
+
+// caller returns principal of the call, which is the one put by auth.AuthFunc, or made of
+// uid and identity put by other auth funcs
+func caller(ctx context.Context) (principal auth.Principal, authenticated bool) {
+	principal, authenticated = auth.PrincipalFromContext(ctx)
+	if uid, ok := ctx.Value(common.KeyUID{}).(string); ok && uid != "" {
+		principal.UID = uid
+		authenticated = true
+	}
+	if id, ok := ctx.Value(common.KeyIdentity{}).(string); ok && id != "" {
+		principal.Service = id
+		authenticated = true
+	}
+	return
+}
+
+// Decide evaluates rules for the call without side effects
+func (p *Policy) Decide(ctx context.Context, fullMethod string) Decision {
+	if strings.HasPrefix(fullMethod, common.HealthService) {
+		return Decision{Allowed: true, Reason: "health check"}
+	}
+
+	r, pattern := p.ruleSet().match(fullMethod)
+	if r == nil {
+		return Decision{Reason: "no rule"}
+	}
+	d := Decision{Rule: pattern}
+	if r.Public {
+		d.Allowed, d.Reason = true, "public"
+		return d
+	}
+
+	principal, authenticated := caller(ctx)
+	if !authenticated {
+		d.Reason = "unauthenticated"
+		return d
+	}
+	spiffeID, _ := ctx.Value(common.KeySPIFFEID{}).(string)
+	if len(r.Services) > 0 && !contains(r.Services, principal.Service) &&
+		(spiffeID == "" || !contains(r.Services, spiffeID)) {
+		d.Reason = fmt.Sprintf("service [%s] not allowed", principal.Service)
+		return d
+	}
+	if len(r.Roles) > 0 {
+		found := false
+		for _, role := range r.Roles {
+			if principal.HasRole(role) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.Reason = fmt.Sprintf("none of roles %v", r.Roles)
+			return d
+		}
+	}
+	for _, scope := range r.Scopes {
+		if !principal.HasScope(scope) {
+			d.Reason = fmt.Sprintf("scope %s not granted", scope)
+			return d
+		}
+	}
+
+	d.Allowed, d.Reason = true, "granted"
+	return d
+}
+
+// Authorize decides the call, and returns PermissionDenied, or Unauthenticated for anonymous
+// callers, if it's denied. In dry run mode, denials are logged and counted only.
+func (p *Policy) Authorize(ctx context.Context, fullMethod string) error {
+	rs := p.ruleSet()
+	d := p.Decide(ctx, fullMethod)
+	principal, authenticated := caller(ctx)
+
+	decision := decisionAllow
+	if !d.Allowed {
+		decision = decisionDeny
+		if rs.cfg.DryRun {
+			decision = decisionDryRun
+		}
+	}
+	decisions.With("method", fullMethod, "decision", decision).Add(1)
+
+	switch {
+	case rs.cfg.Audit == AuditNone:
+	case !d.Allowed:
+		logging.FromContext(ctx).Warnf("[Authz] %s %s uid=[%s] service=[%s] rule=[%s]: %s",
+			decision, fullMethod, principal.UID, principal.Service, d.Rule, d.Reason)
+	case rs.cfg.Audit == AuditAll:
+		logging.FromContext(ctx).Infof("[Authz] %s %s uid=[%s] service=[%s] rule=[%s]: %s",
+			decision, fullMethod, principal.UID, principal.Service, d.Rule, d.Reason)
+	}
+
+	if d.Allowed || rs.cfg.DryRun {
+		return nil
+	}
+	if !authenticated {
+		return status.Errorf(codes.Unauthenticated, "%s requires authentication", fullMethod)
+	}
+	return status.Errorf(codes.PermissionDenied, "permission denied to %s: %s", fullMethod, d.Reason)
+}
+
+// AuthFunc wraps the auth func so that unauthenticated calls of public methods are let through
+func (p *Policy) AuthFunc(next grpc_auth.AuthFunc) grpc_auth.AuthFunc {
+	return func(ctx context.Context) (context.Context, error) {
+		newCtx, err := next(ctx)
+		if err != nil {
+			if method, _ := grpc.Method(ctx); p.IsPublic(method) {
+				return ctx, nil
+			}
+		}
+		return newCtx, err
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor authorizing unary calls, it should be chained
+// after auth so that callers are identified
+func (p *Policy) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := p.Authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor authorizing streams
+func (p *Policy) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := p.Authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
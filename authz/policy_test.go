@@ -0,0 +1,227 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/butters-mars/tiki/auth"
+	"github.com/butters-mars/tiki/common"
+)
+
+var testConfig = Config{
+	Rules: []Rule{
+		{Methods: []string{"/a.Public/", "/a.B/Open"}, Public: true},
+		{Methods: []string{"/a.B/"}},
+		{Methods: []string{"/a.B/Delete"}, Roles: []string{"admin", "owner"}},
+		{Methods: []string{"/a.B/Write"}, Scopes: []string{"b.read", "b.write"}},
+		{Methods: []string{"/a.B/Sync"}, Services: []string{"svc-a"}, Scopes: []string{"sync"}},
+		{Methods: []string{"/a.B/Refund"}, Services: []string{"spiffe://tiki.test/sa/order-service"}},
+		{Methods: []string{"/a.B/Delete"}, Public: true},
+	},
+}
+
+func withPrincipal(p auth.Principal) context.Context {
+	ctx := auth.NewContextWithPrincipal(context.Background(), p)
+	ctx = context.WithValue(ctx, common.KeyUID{}, p.UID)
+	if p.Service != "" {
+		ctx = context.WithValue(ctx, common.KeyIdentity{}, p.Service)
+	}
+	return ctx
+}
+
+func TestDecide(t *testing.T) {
+	p := New(&testConfig)
+
+	anonymous := context.Background()
+	user := withPrincipal(auth.Principal{UID: "u1"})
+	admin := withPrincipal(auth.Principal{UID: "u2", Roles: []string{"user", "admin"}})
+	writer := withPrincipal(auth.Principal{UID: "u3", Scopes: []string{"b.read", "b.write"}})
+	reader := withPrincipal(auth.Principal{UID: "u4", Scopes: []string{"b.read"}})
+	svcA := withPrincipal(auth.Principal{UID: "s1", Service: "svc-a", Scopes: []string{"sync"}})
+	svcB := withPrincipal(auth.Principal{UID: "s2", Service: "svc-b", Scopes: []string{"sync"}})
+	// identity put by other auth, e.g. mtls
+	mtls := context.WithValue(context.Background(), common.KeyIdentity{}, "svc-a")
+	order := context.WithValue(context.Background(), common.KeyIdentity{}, "order-service")
+	order = context.WithValue(order, common.KeySPIFFEID{}, "spiffe://tiki.test/sa/order-service")
+
+	cases := []struct {
+		ctx     context.Context
+		method  string
+		allowed bool
+		rule    string
+	}{
+		{anonymous, "/a.Public/Any", true, "/a.Public/"},
+		{anonymous, "/a.B/Open", true, "/a.B/Open"},
+		{anonymous, "/grpc.health.v1.Health/Check", true, ""},
+		{anonymous, "/a.B/Get", false, "/a.B/"},
+		{user, "/a.B/Get", true, "/a.B/"},
+		{user, "/a.C/Get", false, ""},
+		{user, "/a.B/Delete", false, "/a.B/Delete"},
+		{admin, "/a.B/Delete", true, "/a.B/Delete"},
+		{anonymous, "/a.B/Delete", false, "/a.B/Delete"},
+		{writer, "/a.B/Write", true, "/a.B/Write"},
+		{reader, "/a.B/Write", false, "/a.B/Write"},
+		{svcA, "/a.B/Sync", true, "/a.B/Sync"},
+		{svcB, "/a.B/Sync", false, "/a.B/Sync"},
+		{mtls, "/a.B/Sync", false, "/a.B/Sync"},
+		{mtls, "/a.B/Get", true, "/a.B/"},
+		{order, "/a.B/Refund", true, "/a.B/Refund"},
+		{mtls, "/a.B/Refund", false, "/a.B/Refund"},
+	}
+	for _, c := range cases {
+		d := p.Decide(c.ctx, c.method)
+		if d.Allowed != c.allowed || d.Rule != c.rule {
+			t.Errorf("%s: should get %v by [%s]: %+v", c.method, c.allowed, c.rule, d)
+		}
+	}
+
+	p.SetConfig(&Config{Rules: []Rule{{Methods: []string{"*"}, Roles: []string{"admin"}}}})
+	if d := p.Decide(admin, "/a.C/Get"); !d.Allowed || d.Rule != "*" {
+		t.Errorf("should fall back to *: %+v", d)
+	}
+	if d := p.Decide(user, "/a.C/Get"); d.Allowed {
+		t.Errorf("should deny by *: %+v", d)
+	}
+
+	if New(nil).Decide(admin, "/a.B/Get").Allowed {
+		t.Errorf("should deny by default")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	p := New(&testConfig)
+	user := withPrincipal(auth.Principal{UID: "u1"})
+
+	if err := p.Authorize(user, "/a.B/Get"); err != nil {
+		t.Errorf("should allow: %v", err)
+	}
+	if err := p.Authorize(user, "/a.B/Delete"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("should be permission denied: %v", err)
+	}
+	if err := p.Authorize(context.Background(), "/a.B/Get"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("should be unauthenticated: %v", err)
+	}
+
+	cfg := testConfig
+	cfg.DryRun = true
+	p.SetConfig(&cfg)
+	if err := p.Authorize(user, "/a.B/Delete"); err != nil {
+		t.Errorf("should allow in dry run: %v", err)
+	}
+}
+
+func TestAuthFunc(t *testing.T) {
+	p := New(&testConfig)
+	fail := func(ctx context.Context) (context.Context, error) {
+		return nil, status.Error(codes.Unauthenticated, "no token")
+	}
+	interceptor := p.UnaryServerInterceptor()
+
+	call := func(method string) error {
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), &testStream{method: method})
+		ctx, err := p.AuthFunc(fail)(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+		return err
+	}
+
+	if err := call("/a.Public/Any"); err != nil {
+		t.Errorf("public method should be let through: %v", err)
+	}
+	if err := call("/a.B/Get"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("should fail auth: %v", err)
+	}
+}
+
+type testStream struct {
+	grpc.ServerTransportStream
+	method string
+}
+
+func (s *testStream) Method() string {
+	return s.method
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("rules:\n- methods: [/a.B/]\n  roles: [admin]\n")
+
+	p, err := NewFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := withPrincipal(auth.Principal{UID: "u1"})
+	if err = p.Authorize(user, "/a.B/Get"); err == nil {
+		t.Fatal(errors.New("should deny before reload"))
+	}
+
+	write("dry_run: true\naudit: all\nrules:\n- methods: [/a.B/]\n  roles: [admin]\n")
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	p.Reload()
+	if err = p.Authorize(user, "/a.B/Get"); err != nil {
+		t.Errorf("should allow in dry run after reload: %v", err)
+	}
+
+	write("rules: [")
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	p.Reload()
+	if err = p.Authorize(user, "/a.B/Get"); err != nil {
+		t.Errorf("should keep rules if config is invalid: %v", err)
+	}
+}
+
+func TestReloadAfterLoadFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(path, []byte("rules: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewFromFile(path)
+	if err == nil {
+		t.Fatal(errors.New("should fail to load invalid config"))
+	}
+	user := withPrincipal(auth.Principal{UID: "u1"})
+	if err = p.Authorize(user, "/a.B/Get"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("should deny all before the config is fixed: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("rules:\n- methods: [/a.B/]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	p.Reload()
+	if err = p.Authorize(user, "/a.B/Get"); err != nil {
+		t.Errorf("should allow after the config is fixed: %v", err)
+	}
+}
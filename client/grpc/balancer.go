@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/butters-mars/tiki/client/http/lb"
+)
+
+const (
+	// BalancerWeighted is the name of tag-aware weighted balancer
+	BalancerWeighted = "tiki_weighted"
+	// RouteTagKey is the metadata key to route a call only to instances with the tag
+	RouteTagKey = "x-route-tag"
+	// CanaryTag instances are avoided by calls without route tag
+	CanaryTag = "stg"
+)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilderV2(BalancerWeighted, &weightedPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+// WithRouteTag returns a context routing outgoing calls to instances tagged with the given tag
+func WithRouteTag(ctx context.Context, tag string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, RouteTagKey, tag)
+}
+
+type weightedSubConn struct {
+	sc     balancer.SubConn
+	addr   string
+	tags   map[string]bool
+	weight int
+}
+
+type weightedPickerBuilder struct {
+}
+
+// Build implements base.V2PickerBuilder
+func (b *weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+
+	scs := make([]*weightedSubConn, 0, len(info.ReadySCs))
+	for sc, scInfo := range info.ReadySCs {
+		var tags []string
+		if scInfo.Address.Attributes != nil {
+			tags, _ = scInfo.Address.Attributes.Value(TagsKey{}).([]string)
+		}
+
+		wsc := &weightedSubConn{
+			sc:     sc,
+			addr:   scInfo.Address.Addr,
+			tags:   make(map[string]bool),
+			weight: lb.TagWeight(tags),
+		}
+		for _, tag := range tags {
+			wsc.tags[tag] = true
+		}
+		scs = append(scs, wsc)
+	}
+
+	return &weightedPicker{
+		scs:  scs,
+		rand: rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+type weightedPicker struct {
+	scs   []*weightedSubConn
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// Pick implements balancer.V2Picker, calls with route tag only go to instances with
+// the tag, others avoid canary instances unless there are only canary ones, then select
+// by weight
+func (p *weightedPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	routeTag := ""
+	if md, ok := metadata.FromOutgoingContext(info.Ctx); ok {
+		if vals := md.Get(RouteTagKey); len(vals) > 0 {
+			routeTag = vals[len(vals)-1]
+		}
+	}
+
+	candidates, totalWeight := p.candidates(routeTag, false)
+	if len(candidates) == 0 && routeTag == "" {
+		// fall back to canary instances if there is no other, e.g. a staging fleet
+		candidates, totalWeight = p.candidates(routeTag, true)
+	}
+
+	if len(candidates) == 0 {
+		return balancer.PickResult{}, status.Errorf(codes.Unavailable, "no instance for %s with route tag [%s]", info.FullMethodName, routeTag)
+	}
+
+	if len(candidates) == 1 || totalWeight <= 0 {
+		p.mutex.Lock()
+		idx := p.rand.Intn(len(candidates))
+		p.mutex.Unlock()
+		return balancer.PickResult{SubConn: candidates[idx].sc}, nil
+	}
+
+	p.mutex.Lock()
+	num := p.rand.Intn(totalWeight)
+	p.mutex.Unlock()
+
+	for _, sc := range candidates {
+		if num < sc.weight {
+			return balancer.PickResult{SubConn: sc.sc}, nil
+		}
+		num -= sc.weight
+	}
+
+	return balancer.PickResult{SubConn: candidates[len(candidates)-1].sc}, nil
+}
+
+// candidates returns instances with the route tag, or instances not tagged canary if no
+// route tag unless canary instances are included
+func (p *weightedPicker) candidates(routeTag string, canary bool) ([]*weightedSubConn, int) {
+	candidates := make([]*weightedSubConn, 0, len(p.scs))
+	totalWeight := 0
+	for _, sc := range p.scs {
+		if routeTag != "" && !sc.tags[routeTag] {
+			continue
+		}
+		if routeTag == "" && !canary && sc.tags[CanaryTag] {
+			continue
+		}
+		candidates = append(candidates, sc)
+		totalWeight += sc.weight
+	}
+	return candidates, totalWeight
+}
@@ -0,0 +1,95 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeSubConn struct {
+	addr string
+}
+
+func (sc *fakeSubConn) UpdateAddresses([]resolver.Address) {}
+func (sc *fakeSubConn) Connect()                           {}
+
+func TestWeightedPick(t *testing.T) {
+	tags := map[string][]string{
+		"a:80": {},
+		"b:80": {"weight_25"},
+		"c:80": {"stg"},
+	}
+	addrs := makeAddresses([]string{"a:80", "b:80", "c:80"}, tags, nil)
+
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for _, addr := range addrs {
+		info.ReadySCs[&fakeSubConn{addr: addr.Addr}] = base.SubConnInfo{Address: addr}
+	}
+	picker := (&weightedPickerBuilder{}).Build(info)
+
+	count := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: context.TODO()})
+		if err != nil {
+			t.Errorf("should be ok to pick: %v", err)
+			return
+		}
+		count[res.SubConn.(*fakeSubConn).addr]++
+	}
+	if count["c:80"] != 0 {
+		t.Errorf("stg should be avoided without route tag: %v", count)
+		return
+	}
+	if count["b:80"] < 120 || count["b:80"] > 280 {
+		t.Errorf("b should be selected 120 - 280 : %v", count)
+		return
+	}
+
+	ctx := WithRouteTag(context.TODO(), "stg")
+	for i := 0; i < 100; i++ {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: ctx})
+		if err != nil {
+			t.Errorf("should be ok to pick: %v", err)
+			return
+		}
+		if addr := res.SubConn.(*fakeSubConn).addr; addr != "c:80" {
+			t.Errorf("should route to stg only: %s", addr)
+			return
+		}
+	}
+
+	_, err := picker.Pick(balancer.PickInfo{Ctx: WithRouteTag(context.TODO(), "none")})
+	if err == nil {
+		t.Errorf("should fail to pick unknown route tag")
+	}
+}
+
+func TestWeightedPickCanaryOnly(t *testing.T) {
+	tags := map[string][]string{
+		"a:80": {"stg"},
+		"b:80": {"stg"},
+	}
+	addrs := makeAddresses([]string{"a:80", "b:80"}, tags, nil)
+
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for _, addr := range addrs {
+		info.ReadySCs[&fakeSubConn{addr: addr.Addr}] = base.SubConnInfo{Address: addr}
+	}
+	picker := (&weightedPickerBuilder{}).Build(info)
+
+	count := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: context.TODO()})
+		if err != nil {
+			t.Errorf("should fall back to stg instances: %v", err)
+			return
+		}
+		count[res.SubConn.(*fakeSubConn).addr]++
+	}
+	if count["a:80"] == 0 || count["b:80"] == 0 {
+		t.Errorf("should pick all stg instances: %v", count)
+	}
+}
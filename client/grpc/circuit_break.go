@@ -0,0 +1,299 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fmhttp "github.com/butters-mars/tiki/client/http"
+	"github.com/butters-mars/tiki/client/http/middleware"
+)
+
+// methodGRPC is the method label of grpc commands in hystrix metrics
+const methodGRPC = "GRPC"
+
+var (
+	// breakers by target, shared by all conns to the target
+	breakers      = make(map[string]*methodBreaker)
+	breakersMutex = sync.Mutex{}
+)
+
+func init() {
+	fmhttp.AddSettingListener(func(target string, setting fmhttp.EndpointSetting) {
+		breakersMutex.Lock()
+		b, ok := breakers[target]
+		breakersMutex.Unlock()
+
+		if ok {
+			b.apply(setting)
+		}
+	})
+}
+
+// methodBreaker keeps hystrix commands of methods of a target, a method is configured by the
+// upstream setting whose uri is the full method name, i.e. /package.Service/Method, and
+// hystrix.timeout is the default deadline, hystrix.max_concurrent_requests is the bulkhead.
+type methodBreaker struct {
+	target   string
+	mutex    *sync.RWMutex
+	settings map[string]hystrix.CommandConfig // by full method
+	commands map[string]hystrix.CommandConfig // configured commands
+	// generation by full method, bumped when the bulkhead changes
+	generations map[string]int
+}
+
+func getBreaker(target string) *methodBreaker {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+
+	if b, ok := breakers[target]; ok {
+		return b
+	}
+
+	b := &methodBreaker{
+		target:   target,
+		mutex:    &sync.RWMutex{},
+		settings: make(map[string]hystrix.CommandConfig),
+		commands: make(map[string]hystrix.CommandConfig),
+
+		generations: make(map[string]int),
+	}
+	for _, setting := range fmhttp.EndpointSettings(target) {
+		b.settings[setting.URI] = withDefaultCBConfig(setting.CBConfig)
+	}
+	breakers[target] = b
+
+	return b
+}
+
+func withDefaultCBConfig(cfg hystrix.CommandConfig) hystrix.CommandConfig {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = middleware.DefaultCBConfig.Timeout
+	}
+	if cfg.ErrorPercentThreshold == 0 {
+		cfg.ErrorPercentThreshold = middleware.DefaultCBConfig.ErrorPercentThreshold
+	}
+	if cfg.MaxConcurrentRequests == 0 {
+		cfg.MaxConcurrentRequests = middleware.DefaultCBConfig.MaxConcurrentRequests
+	}
+	if cfg.RequestVolumeThreshold == 0 {
+		cfg.RequestVolumeThreshold = middleware.DefaultCBConfig.RequestVolumeThreshold
+	}
+	if cfg.SleepWindow == 0 {
+		cfg.SleepWindow = middleware.DefaultCBConfig.SleepWindow
+	}
+	return cfg
+}
+
+func normal(str string) string {
+	return strings.Replace(str, "-", "_", -1)
+}
+
+// commandName follows format of http commands <src>-<target>-<uri>-<method>-<addr>, so that
+// the hystrix collector labels it the same way, addr is empty since the breaker is per method,
+// and the generation is suffixed if any
+func (b *methodBreaker) commandName(fullMethod string, gen int) string {
+	cmd := fmt.Sprintf("%s-%s-%s-%s-", normal(fmhttp.Source()), normal(b.target), normal(fullMethod), methodGRPC)
+	if gen > 0 {
+		cmd = fmt.Sprintf("%s#%d", cmd, gen)
+	}
+	return cmd
+}
+
+// apply reconfigures the method with changed setting
+func (b *methodBreaker) apply(setting fmhttp.EndpointSetting) {
+	if !strings.HasPrefix(setting.URI, "/") {
+		return
+	}
+
+	cfg := withDefaultCBConfig(setting.CBConfig)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.settings[setting.URI] = cfg
+	cmd := b.commandName(setting.URI, b.generations[setting.URI])
+	old, ok := b.commands[cmd]
+	if !ok {
+		return
+	}
+
+	if old.MaxConcurrentRequests != cfg.MaxConcurrentRequests {
+		// hystrix keeps the pool of a circuit once created, so the method moves to a command of
+		// new generation, which is configured on next call, circuits of others are kept
+		b.generations[setting.URI]++
+		delete(b.commands, cmd)
+		logger.Warnf("[CB] max concurrent requests of %s changed %d -> %d, move to generation %d",
+			cmd, old.MaxConcurrentRequests, cfg.MaxConcurrentRequests, b.generations[setting.URI])
+		return
+	}
+
+	b.commands[cmd] = cfg
+	hystrix.ConfigureCommand(cmd, cfg)
+	logger.Infof("[CB] command %s reconfigured: %+v", cmd, cfg)
+}
+
+// command returns hystrix command of the method, configures it if not yet
+func (b *methodBreaker) command(fullMethod string) (cmd string, timeout time.Duration) {
+	b.mutex.RLock()
+	cmd = b.commandName(fullMethod, b.generations[fullMethod])
+	cfg, ok := b.commands[cmd]
+	b.mutex.RUnlock()
+	if ok {
+		return cmd, time.Duration(cfg.Timeout) * time.Millisecond
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd = b.commandName(fullMethod, b.generations[fullMethod])
+
+	if cfg, ok = b.commands[cmd]; !ok {
+		if cfg, ok = b.settings[fullMethod]; !ok {
+			cfg = withDefaultCBConfig(hystrix.CommandConfig{})
+		}
+		hystrix.ConfigureCommand(cmd, cfg)
+		b.commands[cmd] = cfg
+		logger.Infof("[CB] method %s of %s -> %s configured", fullMethod, b.target, cmd)
+	}
+
+	return cmd, time.Duration(cfg.Timeout) * time.Millisecond
+}
+
+// isOpen returns whether circuit of the method is open
+func (b *methodBreaker) isOpen(fullMethod string) bool {
+	b.mutex.RLock()
+	cmd := b.commandName(fullMethod, b.generations[fullMethod])
+	_, configured := b.commands[cmd]
+	b.mutex.RUnlock()
+	if !configured {
+		// GetCircuit creates the circuit, whose pool is sized before the command is configured
+		return false
+	}
+
+	c, _, err := hystrix.GetCircuit(cmd)
+	return err == nil && c.IsOpen()
+}
+
+// isFailure returns whether the error counts for the circuit, errors caused by the caller
+// like InvalidArgument or NotFound do not
+func isFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown,
+		codes.ResourceExhausted, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// toStatus converts hystrix and context errors to grpc status
+func toStatus(err error, target, fullMethod string) error {
+	switch err {
+	case hystrix.ErrCircuitOpen:
+		return status.Errorf(codes.Unavailable, "circuit open for %s of %s", fullMethod, target)
+	case hystrix.ErrMaxConcurrency:
+		return status.Errorf(codes.ResourceExhausted, "max concurrency reached for %s of %s", fullMethod, target)
+	case hystrix.ErrTimeout, context.DeadlineExceeded:
+		return status.Errorf(codes.DeadlineExceeded, "%s of %s timed out", fullMethod, target)
+	case context.Canceled:
+		return status.Errorf(codes.Canceled, "%s of %s canceled", fullMethod, target)
+	}
+	return err
+}
+
+// UnaryCircuitBreaker returns an interceptor with per method circuit breaker, bulkhead and
+// deadline, the deadline of ctx is shortened to the timeout of the method if it's later
+func UnaryCircuitBreaker(target string) grpc.UnaryClientInterceptor {
+	b := getBreaker(target)
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		cmd, timeout := b.command(method)
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > timeout {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		// errors not counted for the circuit are returned after hystrix
+		var callErr error
+		if err := hystrix.DoC(ctx, cmd, func(ctx context.Context) error {
+			callErr = invoker(ctx, method, req, reply, cc, opts...)
+			if isFailure(callErr) {
+				return callErr
+			}
+			return nil
+		}, nil); err != nil {
+			return toStatus(err, b.target, method)
+		}
+
+		return callErr
+	}
+}
+
+// StreamCircuitBreaker returns an interceptor with per method circuit breaker and bulkhead
+// on establishing streams, no deadline is set since streams could be long-lived
+func StreamCircuitBreaker(target string) grpc.StreamClientInterceptor {
+	b := getBreaker(target)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cmd, _ := b.command(method)
+
+		// the stream is created under its own ctx, which is canceled if hystrix gives up
+		// establishing it, so that a stream created late is not leaked
+		streamCtx, cancel := context.WithCancel(ctx)
+		mutex := sync.Mutex{}
+		abandoned := false
+
+		var stream grpc.ClientStream
+		var callErr error
+		if err := hystrix.DoC(ctx, cmd, func(context.Context) error {
+			s, err := streamer(streamCtx, desc, cc, method, opts...)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if abandoned {
+				return err
+			}
+			stream, callErr = s, err
+			if isFailure(callErr) {
+				return callErr
+			}
+			return nil
+		}, nil); err != nil {
+			mutex.Lock()
+			abandoned = true
+			mutex.Unlock()
+			cancel()
+			return nil, toStatus(err, b.target, method)
+		}
+
+		if callErr != nil {
+			cancel()
+			return nil, callErr
+		}
+		return &cancelOnFinishStream{ClientStream: stream, cancel: cancel}, nil
+	}
+}
+
+// cancelOnFinishStream releases ctx of the stream when it's finished
+type cancelOnFinishStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+// RecvMsg implements grpc.ClientStream, the stream is finished once it returns an error,
+// io.EOF included
+func (s *cancelOnFinishStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+	return err
+}
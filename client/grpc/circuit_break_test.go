@@ -0,0 +1,277 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fmhttp "github.com/butters-mars/tiki/client/http"
+)
+
+// serveTest serves /test.Svc/* methods with the handler
+func serveTest(t *testing.T, handler func(method string) (proto.Message, error)) (addr string, stop func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		if err := stream.RecvMsg(&empty.Empty{}); err != nil {
+			return err
+		}
+
+		method, _ := grpc.MethodFromServerStream(stream)
+		reply, err := handler(method)
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg(reply)
+	}))
+	go srv.Serve(lis)
+
+	return lis.Addr().String(), srv.Stop
+}
+
+// newTestServer serves /test.Svc/* methods: Fail returns Unavailable, Missing returns NotFound,
+// Slow sleeps 200ms, others succeed
+func newTestServer(t *testing.T, hits *int32) (addr string, stop func()) {
+	return serveTest(t, func(method string) (proto.Message, error) {
+		atomic.AddInt32(hits, 1)
+		switch method {
+		case "/test.Svc/Fail":
+			return nil, status.Error(codes.Unavailable, "down")
+		case "/test.Svc/Missing":
+			return nil, status.Error(codes.NotFound, "missing")
+		case "/test.Svc/Slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+		return &empty.Empty{}, nil
+	})
+}
+
+func dialTest(t *testing.T, addr string) *grpc.ClientConn {
+	cc, err := grpc.Dial(addr, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(UnaryRetry(addr), UnaryCircuitBreaker(addr))),
+		grpc.WithStreamInterceptor(StreamCircuitBreaker(addr)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func invoke(cc *grpc.ClientConn, method string) error {
+	return cc.Invoke(context.TODO(), method, &empty.Empty{}, &empty.Empty{})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var hits int32
+	addr, stop := newTestServer(t, &hits)
+	defer stop()
+
+	getBreaker(addr).apply(fmhttp.EndpointSetting{
+		URI: "/test.Svc/Fail",
+		CBConfig: hystrix.CommandConfig{
+			RequestVolumeThreshold: 3,
+			ErrorPercentThreshold:  50,
+			SleepWindow:            10000,
+		},
+	})
+	cc := dialTest(t, addr)
+	defer cc.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := invoke(cc, "/test.Svc/Missing"); status.Code(err) != codes.NotFound {
+			t.Fatalf("should be NotFound: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := invoke(cc, "/test.Svc/Missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("NotFound should not open circuit: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		invoke(cc, "/test.Svc/Fail")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	before := atomic.LoadInt32(&hits)
+	err := invoke(cc, "/test.Svc/Fail")
+	if status.Code(err) != codes.Unavailable || atomic.LoadInt32(&hits) != before {
+		t.Errorf("circuit should be open: %v", err)
+	}
+	if err = invoke(cc, "/test.Svc/Ok"); err != nil {
+		t.Errorf("circuit is per method: %v", err)
+	}
+}
+
+func TestDefaultDeadline(t *testing.T) {
+	var hits int32
+	addr, stop := newTestServer(t, &hits)
+	defer stop()
+
+	cc := dialTest(t, addr)
+	defer cc.Close()
+
+	if err := invoke(cc, "/test.Svc/Slow"); err != nil {
+		t.Fatalf("should succeed with default timeout: %v", err)
+	}
+
+	// reconfigured for conns already created
+	getBreaker(addr).apply(fmhttp.EndpointSetting{
+		URI:      "/test.Svc/Slow",
+		CBConfig: hystrix.CommandConfig{Timeout: 50},
+	})
+	start := time.Now()
+	err := invoke(cc, "/test.Svc/Slow")
+	if status.Code(err) != codes.DeadlineExceeded || time.Since(start) > 150*time.Millisecond {
+		t.Errorf("should time out by timeout of method: %v %v", err, time.Since(start))
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	var hits int32
+	addr, stop := newTestServer(t, &hits)
+	defer stop()
+
+	getBreaker(addr).apply(fmhttp.EndpointSetting{
+		URI:      "/test.Svc/Slow",
+		CBConfig: hystrix.CommandConfig{MaxConcurrentRequests: 2},
+	})
+	cc := dialTest(t, addr)
+	defer cc.Close()
+
+	var rejected int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status.Code(invoke(cc, "/test.Svc/Slow")) == codes.ResourceExhausted {
+				atomic.AddInt32(&rejected, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rejected != 2 {
+		t.Errorf("2 calls should be rejected: %d", rejected)
+	}
+}
+
+func TestBulkheadChange(t *testing.T) {
+	var hits int32
+	addr, stop := newTestServer(t, &hits)
+	defer stop()
+
+	b := getBreaker(addr)
+	b.apply(fmhttp.EndpointSetting{
+		URI:      "/test.Svc/Fail",
+		CBConfig: hystrix.CommandConfig{RequestVolumeThreshold: 3, SleepWindow: 10000},
+	})
+	cc := dialTest(t, addr)
+	defer cc.Close()
+
+	for i := 0; i < 5; i++ {
+		invoke(cc, "/test.Svc/Fail")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !b.isOpen("/test.Svc/Fail") {
+		t.Fatal("circuit should be open")
+	}
+
+	concurrent := func() (rejected int32) {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if status.Code(invoke(cc, "/test.Svc/Slow")) == codes.ResourceExhausted {
+					atomic.AddInt32(&rejected, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		return
+	}
+	if rejected := concurrent(); rejected != 0 {
+		t.Errorf("no call should be rejected: %d", rejected)
+	}
+
+	b.apply(fmhttp.EndpointSetting{
+		URI:      "/test.Svc/Slow",
+		CBConfig: hystrix.CommandConfig{MaxConcurrentRequests: 1},
+	})
+	if rejected := concurrent(); rejected != 2 {
+		t.Errorf("2 calls should be rejected by new bulkhead: %d", rejected)
+	}
+	if !b.isOpen("/test.Svc/Fail") {
+		t.Errorf("circuits of other methods should be kept")
+	}
+}
+
+func TestStreamTimeoutCancelsLateStream(t *testing.T) {
+	target := "stream-timeout"
+	getBreaker(target).apply(fmhttp.EndpointSetting{
+		URI:      "/test.Svc/Stream",
+		CBConfig: hystrix.CommandConfig{Timeout: 20},
+	})
+
+	streamCtx := make(chan context.Context, 1)
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		time.Sleep(50 * time.Millisecond)
+		streamCtx <- ctx
+		return nil, nil
+	}
+	_, err := StreamCircuitBreaker(target)(context.TODO(), &grpc.StreamDesc{}, nil, "/test.Svc/Stream", streamer)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("should time out: %v", err)
+	}
+
+	select {
+	case ctx := <-streamCtx:
+		if ctx.Err() != context.Canceled {
+			t.Errorf("late stream should be canceled: %v", ctx.Err())
+		}
+	case <-time.After(time.Second):
+		t.Errorf("streamer should return")
+	}
+}
+
+func TestStreamCircuitBreaker(t *testing.T) {
+	var hits int32
+	addr, stop := newTestServer(t, &hits)
+	defer stop()
+
+	cc := dialTest(t, addr)
+	defer cc.Close()
+
+	stream, err := cc.NewStream(context.TODO(), &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/test.Svc/Stream")
+	if err != nil {
+		t.Fatalf("fail to create stream: %v", err)
+	}
+	if err = stream.SendMsg(&empty.Empty{}); err != nil {
+		t.Fatalf("fail to send: %v", err)
+	}
+	stream.CloseSend()
+	if err = stream.RecvMsg(&empty.Empty{}); err != nil {
+		t.Fatalf("fail to receive: %v", err)
+	}
+	if err = stream.RecvMsg(&empty.Empty{}); err != io.EOF {
+		t.Fatalf("should end: %v", err)
+	}
+	if stream.Context().Err() != context.Canceled {
+		t.Errorf("ctx of finished stream should be released: %v", stream.Context().Err())
+	}
+}
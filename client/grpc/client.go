@@ -1,49 +1,79 @@
 package grpc
 
 import (
+	"fmt"
+
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/naming"
 
 	"github.com/butters-mars/tiki/config"
 	"github.com/butters-mars/tiki/logging"
+	"github.com/butters-mars/tiki/propagation"
 )
 
 var logger = logging.L
 
-type grpcClient struct {
-}
-
+// NewClientConn creates client conn to the given address, which is service name
+// when using consul or file service discovery, or host:port otherwise
 func NewClientConn(address string, cfg config.ServiceDiscoveryCfg) (*grpc.ClientConn, error) {
 	options := DialOptions(address, cfg)
-	return grpc.Dial(address, options...)
+	return grpc.Dial(Target(address, cfg), options...)
+}
+
+// Target returns dial target of the address with service discovery config
+func Target(address string, cfg config.ServiceDiscoveryCfg) string {
+	switch cfg.Type {
+	case "consul":
+		return consulTarget(address, cfg.Consul)
+	case "file":
+		return fileTarget(address, cfg.File)
+	}
+	return directTarget(address)
 }
 
+// DialOptions returns dial options with load balancing and interceptors setup, calls are
+// guarded by per method circuit breakers and retried by policies in upstream setting or
+// service config of the address. Connections are secured by tls in upstream setting of the
+// address, or the one set by SetClientTLS. Baggage of the context is propagated to upstreams.
 func DialOptions(address string, cfg config.ServiceDiscoveryCfg) []grpc.DialOption {
 	logEntry := logrus.NewEntry(logger)
 
-	var r naming.Resolver
-	if cfg.Type == "consul" {
-		r = newConsulResolver(cfg.Consul)
-	} else {
-		r = newDirectResolver(address)
-	}
-	return []grpc.DialOption{
-		grpc.WithInsecure(),
-		grpc.WithBalancer(grpc.RoundRobin(r)),
+	options := []grpc.DialOption{
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingPolicy":"%s"}`, BalancerWeighted)),
 		grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(
 			grpc_opentracing.StreamClientInterceptor(),
 			grpc_prometheus.StreamClientInterceptor,
 			grpc_logrus.StreamClientInterceptor(logEntry),
+			propagation.StreamClientInterceptor(),
+			StreamCircuitBreaker(address),
 		)),
 		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(
 			grpc_opentracing.UnaryClientInterceptor(),
 			grpc_prometheus.UnaryClientInterceptor,
 			grpc_logrus.UnaryClientInterceptor(logEntry),
+			propagation.UnaryClientInterceptor(),
+			UnaryRetry(address),
+			UnaryCircuitBreaker(address),
 		)),
 	}
+	creds, err := transportCredentials(address)
+	switch {
+	case err != nil:
+		// no transport security option, so that dialing fails rather than going insecure
+		logger.Errorf("[TLS] fail to load certificates of %s: %v", address, err)
+	case creds != nil:
+		options = append(options, grpc.WithTransportCredentials(creds))
+	default:
+		options = append(options, grpc.WithInsecure())
+	}
+	if cfg.Type == "consul" || cfg.Type == "file" {
+		// use service name instead of registry address as :authority
+		options = append(options, grpc.WithAuthority(address))
+	}
+
+	return options
 }
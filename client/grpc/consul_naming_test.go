@@ -1,3 +0,0 @@
-package grpc
-
-func Test
\ No newline at end of file
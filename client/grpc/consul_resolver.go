@@ -0,0 +1,189 @@
+package grpc
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	csd "github.com/go-kit/kit/sd/consul"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/butters-mars/tiki/client/sd/instancer"
+)
+
+const (
+	// SchemeConsul resolves target consul://<agent-addr>/<service>?dc=<datacenter>
+	SchemeConsul = "consul"
+)
+
+// TagsKey is the attribute key of consul tags ([]string) of a resolved address
+type TagsKey struct{}
+
+// MetaKey is the attribute key of consul service meta (map[string]string) of a resolved address
+type MetaKey struct{}
+
+func init() {
+	resolver.Register(&consulBuilder{})
+}
+
+type sdLogger struct {
+}
+
+func (l sdLogger) Log(keyvals ...interface{}) error {
+	logger.Infof("[Naming] %v", keyvals)
+	return nil
+}
+
+// consulTarget builds target for the given service with consul config
+func consulTarget(service string, cfg *consul.Config) string {
+	addr := ""
+	query := url.Values{}
+	if cfg != nil {
+		addr = cfg.Address
+		if cfg.Datacenter != "" {
+			query.Set("dc", cfg.Datacenter)
+		}
+		if cfg.Scheme != "" {
+			query.Set("scheme", cfg.Scheme)
+		}
+	}
+
+	target := fmt.Sprintf("%s://%s/%s", SchemeConsul, addr, service)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target
+}
+
+type consulBuilder struct {
+}
+
+// Build implements resolver.Builder
+func (b *consulBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	service := target.Endpoint
+	cfg := consul.DefaultConfig()
+	if target.Authority != "" {
+		cfg.Address = target.Authority
+	}
+	if idx := strings.Index(service, "?"); idx >= 0 {
+		query, err := url.ParseQuery(service[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("bad consul target %v: %v", target, err)
+		}
+		service = service[:idx]
+		if dc := query.Get("dc"); dc != "" {
+			cfg.Datacenter = dc
+		}
+		if scheme := query.Get("scheme"); scheme != "" {
+			cfg.Scheme = scheme
+		}
+	}
+	if service == "" {
+		return nil, fmt.Errorf("service not given in consul target %v", target)
+	}
+
+	c, err := consul.NewClient(cfg)
+	if err != nil {
+		logger.Errorf("fail to connect to consul: %v", err)
+		return nil, err
+	}
+
+	r := &consulResolver{
+		service: service,
+		cc:      cc,
+		addrs:   make(map[string]resolver.Address),
+	}
+	r.instancer = instancer.NewInstancer(csd.NewClient(c), sdLogger{}, service, nil, true, r.update)
+
+	return r, nil
+}
+
+// Scheme implements resolver.Builder
+func (b *consulBuilder) Scheme() string {
+	return SchemeConsul
+}
+
+type consulResolver struct {
+	service   string
+	cc        resolver.ClientConn
+	instancer *instancer.Instancer
+
+	// last resolved addresses, reused when unchanged, since balancer
+	// keys SubConns by address (including attributes pointer)
+	addrs map[string]resolver.Address
+}
+
+func (r *consulResolver) update(instances []string, tagMap map[string][]string, metaMap map[string]map[string]string, err error) {
+	if err != nil {
+		logger.Errorf("Fail to watch updates of %s: %v", r.service, err)
+		r.cc.ReportError(err)
+		return
+	}
+
+	addrs := makeAddresses(instances, tagMap, metaMap)
+	current := make(map[string]resolver.Address)
+	for i, addr := range addrs {
+		if last, ok := r.addrs[addr.Addr]; ok && sameAttributes(last, addr) {
+			addrs[i] = last
+		}
+		current[addr.Addr] = addrs[i]
+	}
+	r.addrs = current
+
+	for _, addr := range addrs {
+		logger.WithFields(logrus.Fields{
+			"event":   "consul_naming",
+			"service": r.service,
+			"address": addr.Addr,
+			"tags":    addr.Attributes.Value(TagsKey{}),
+			"meta":    addr.Attributes.Value(MetaKey{}),
+		}).Info("naming update")
+	}
+
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+func makeAddresses(instances []string, tagMap map[string][]string, metaMap map[string]map[string]string) []resolver.Address {
+	sorted := append([]string{}, instances...)
+	sort.Strings(sorted)
+
+	addrs := make([]resolver.Address, 0, len(sorted))
+	for _, i := range sorted {
+		tags := tagMap[i]
+		if tags == nil {
+			tags = []string{}
+		}
+		meta := metaMap[i]
+		if meta == nil {
+			meta = map[string]string{}
+		}
+
+		addrs = append(addrs, resolver.Address{
+			Addr:       i,
+			Attributes: attributes.New(TagsKey{}, tags, MetaKey{}, meta),
+		})
+	}
+
+	return addrs
+}
+
+func sameAttributes(a, b resolver.Address) bool {
+	return reflect.DeepEqual(a.Attributes.Value(TagsKey{}), b.Attributes.Value(TagsKey{})) &&
+		reflect.DeepEqual(a.Attributes.Value(MetaKey{}), b.Attributes.Value(MetaKey{}))
+}
+
+// ResolveNow implements resolver.Resolver, it's a no-op since instancer
+// watches consul with blocking queries
+func (r *consulResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close implements resolver.Resolver
+func (r *consulResolver) Close() {
+	logger.Infof("consul_naming of %s closed", r.service)
+	r.instancer.SetListener(nil)
+	r.instancer.Stop()
+}
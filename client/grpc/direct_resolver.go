@@ -0,0 +1,61 @@
+package grpc
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// SchemeDirect resolves target direct:///<addr1>,<addr2> to the given addresses
+	SchemeDirect = "direct"
+)
+
+func init() {
+	resolver.Register(&directBuilder{})
+}
+
+func directTarget(addr string) string {
+	return fmt.Sprintf("%s:///%s", SchemeDirect, addr)
+}
+
+type directBuilder struct {
+}
+
+// Build implements resolver.Builder
+func (b *directBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	addrs := make([]resolver.Address, 0)
+	for _, addr := range strings.Split(target.Endpoint, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		addrs = append(addrs, resolver.Address{
+			Addr:       addr,
+			Attributes: attributes.New(TagsKey{}, []string{}, MetaKey{}, map[string]string{}),
+		})
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address in direct target %v", target)
+	}
+
+	logger.Infof("direct naming add %v", target.Endpoint)
+	cc.UpdateState(resolver.State{Addresses: addrs})
+
+	return &directResolver{}, nil
+}
+
+// Scheme implements resolver.Builder
+func (b *directBuilder) Scheme() string {
+	return SchemeDirect
+}
+
+type directResolver struct {
+}
+
+// ResolveNow implements resolver.Resolver
+func (r *directResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close implements resolver.Resolver
+func (r *directResolver) Close() {}
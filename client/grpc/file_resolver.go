@@ -0,0 +1,115 @@
+package grpc
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/resolver"
+
+	"github.com/butters-mars/tiki/sd"
+)
+
+const (
+	// SchemeFile resolves target file:///<service>?path=<registry-file> by watching the
+	// registry file of sd.FileRegisteror
+	SchemeFile = "file"
+)
+
+func init() {
+	resolver.Register(&fileBuilder{})
+}
+
+// fileTarget builds target for the given service with the registry file
+func fileTarget(service, path string) string {
+	if path == "" {
+		path = sd.DefaultFilePath
+	}
+	return fmt.Sprintf("%s:///%s?%s", SchemeFile, service, url.Values{"path": {path}}.Encode())
+}
+
+type fileBuilder struct {
+}
+
+// Build implements resolver.Builder
+func (b *fileBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	service, path := target.Endpoint, sd.DefaultFilePath
+	if idx := strings.Index(service, "?"); idx >= 0 {
+		query, err := url.ParseQuery(service[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("bad file target %v: %v", target, err)
+		}
+		service = service[:idx]
+		if p := query.Get("path"); p != "" {
+			path = p
+		}
+	}
+	if service == "" {
+		return nil, fmt.Errorf("service not given in file target %v", target)
+	}
+
+	r := &fileResolver{
+		service: service,
+		cc:      cc,
+		addrs:   make(map[string]resolver.Address),
+	}
+	r.watcher = sd.WatchFile(path, service, 0, r.update)
+
+	return r, nil
+}
+
+// Scheme implements resolver.Builder
+func (b *fileBuilder) Scheme() string {
+	return SchemeFile
+}
+
+type fileResolver struct {
+	service string
+	cc      resolver.ClientConn
+	watcher *sd.FileWatcher
+
+	// last resolved addresses, see consulResolver
+	addrs map[string]resolver.Address
+}
+
+func (r *fileResolver) update(entries []sd.FileEntry, err error) {
+	if err != nil {
+		logger.Errorf("Fail to watch updates of %s: %v", r.service, err)
+		r.cc.ReportError(err)
+		return
+	}
+
+	instances := make([]string, 0, len(entries))
+	tagMap := make(map[string][]string)
+	metaMap := make(map[string]map[string]string)
+	for _, e := range entries {
+		addr := net.JoinHostPort(e.Address, strconv.Itoa(e.Port))
+		instances = append(instances, addr)
+		tagMap[addr] = e.Tags
+		metaMap[addr] = e.Meta
+	}
+
+	addrs := makeAddresses(instances, tagMap, metaMap)
+	current := make(map[string]resolver.Address)
+	for i, addr := range addrs {
+		if last, ok := r.addrs[addr.Addr]; ok && sameAttributes(last, addr) {
+			addrs[i] = last
+		}
+		current[addr.Addr] = addrs[i]
+	}
+	r.addrs = current
+
+	logger.Infof("file naming of %s update %v", r.service, instances)
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+// ResolveNow implements resolver.Resolver, it's a no-op since the file is polled
+func (r *fileResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close implements resolver.Resolver
+func (r *fileResolver) Close() {
+	logger.Infof("file naming of %s closed", r.service)
+	r.watcher.Stop()
+}
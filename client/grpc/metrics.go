@@ -0,0 +1,38 @@
+package grpc
+
+import (
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	fmhttp "github.com/butters-mars/tiki/client/http"
+)
+
+const (
+	labelSrc    = "src"
+	labelTgt    = "tgt"
+	labelMethod = "method"
+	labelKind   = "kind"
+)
+
+var attempts metrics.Counter = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Namespace: "service",
+	Subsystem: "grpc",
+	Name:      "attempt",
+	Help:      "Attempts of grpc calls by kind, i.e. first, retry or hedge.",
+}, []string{labelSrc, labelTgt, labelMethod, labelKind})
+
+var hedgeWins metrics.Counter = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Namespace: "service",
+	Subsystem: "grpc",
+	Name:      "hedge_win",
+	Help:      "Grpc calls whose result is taken from a hedged request.",
+}, []string{labelSrc, labelTgt, labelMethod})
+
+func recordAttempt(target, method, kind string) {
+	attempts.With(labelSrc, fmhttp.Source(), labelTgt, target, labelMethod, method, labelKind, kind).Add(1)
+}
+
+func recordHedgeWin(target, method string) {
+	hedgeWins.With(labelSrc, fmhttp.Source(), labelTgt, target, labelMethod, method).Add(1)
+}
@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/butters-mars/tiki/config"
+	"github.com/butters-mars/tiki/sd"
+)
+
+func TestMakeAddresses(t *testing.T) {
+	tags := map[string][]string{"b:80": {"stg"}}
+	meta := map[string]map[string]string{"b:80": {"version": "1.0"}}
+
+	addrs := makeAddresses([]string{"b:80", "a:80"}, tags, meta)
+	if len(addrs) != 2 || addrs[0].Addr != "a:80" || addrs[1].Addr != "b:80" {
+		t.Errorf("wrong addresses: %v", addrs)
+		return
+	}
+
+	if ts, ok := addrs[1].Attributes.Value(TagsKey{}).([]string); !ok || len(ts) != 1 || ts[0] != "stg" {
+		t.Errorf("wrong tags: %v", addrs[1].Attributes)
+		return
+	}
+	if m, ok := addrs[1].Attributes.Value(MetaKey{}).(map[string]string); !ok || m["version"] != "1.0" {
+		t.Errorf("wrong meta: %v", addrs[1].Attributes)
+		return
+	}
+	if ts, ok := addrs[0].Attributes.Value(TagsKey{}).([]string); !ok || len(ts) != 0 {
+		t.Errorf("should have empty tags: %v", addrs[0].Attributes)
+	}
+}
+
+func TestDirectConn(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("fail to listen: %v", err)
+		return
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := NewClientConn(lis.Addr().String(), config.ServiceDiscoveryCfg{Type: "direct"})
+	if err != nil {
+		t.Errorf("fail to dial: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Errorf("fail to call: %v", err)
+		return
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("should be serving: %v", resp.Status)
+	}
+}
+
+func TestFileConn(t *testing.T) {
+	fn := fmt.Sprintf("/tmp/sd-%d.json", time.Now().Nanosecond())
+	defer os.Remove(fn)
+	defer os.Remove(fn + ".lock")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("fail to listen: %v", err)
+		return
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cfg := config.ServiceDiscoveryCfg{Type: "file", File: fn}
+	conn, err := NewClientConn("svc-a", cfg)
+	if err != nil {
+		t.Errorf("fail to dial: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// registered after dialing, picked up by watching the file
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+	p, _ := strconv.Atoi(port)
+	sd.NewFileRegisteror(fn).Register(&sd.SvcDef{ID: "svc-a-1", Name: "svc-a", Addr: "127.0.0.1", Port: p})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Errorf("fail to call: %v", err)
+		return
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("should be serving: %v", resp.Status)
+	}
+}
@@ -0,0 +1,462 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fmhttp "github.com/butters-mars/tiki/client/http"
+	"github.com/butters-mars/tiki/logging"
+)
+
+const (
+	maxAttemptsLimit      = 5  // same as grpc
+	defaultInitialBackoff = 25 // ms
+	defaultMaxBackoff     = 250
+	defaultMultiplier     = 2.0
+
+	attemptFirst = "first"
+	attemptRetry = "retry"
+	attemptHedge = "hedge"
+)
+
+var (
+	// retriers by target, shared by all conns to the target
+	retriers      = make(map[string]*methodRetrier)
+	retriersMutex = sync.Mutex{}
+)
+
+func init() {
+	fmhttp.AddSettingListener(func(target string, setting fmhttp.EndpointSetting) {
+		retriersMutex.Lock()
+		r, ok := retriers[target]
+		retriersMutex.Unlock()
+
+		if ok {
+			r.apply(setting)
+		}
+	})
+}
+
+// serviceConfig is the retry and hedging part of grpc service config
+type serviceConfig struct {
+	MethodConfig []struct {
+		Name []struct {
+			Service string `json:"service"`
+			Method  string `json:"method"`
+		} `json:"name"`
+		RetryPolicy *struct {
+			MaxAttempts          int          `json:"maxAttempts"`
+			InitialBackoff       string       `json:"initialBackoff"`
+			MaxBackoff           string       `json:"maxBackoff"`
+			BackoffMultiplier    float64      `json:"backoffMultiplier"`
+			RetryableStatusCodes []codes.Code `json:"retryableStatusCodes"`
+		} `json:"retryPolicy"`
+		HedgingPolicy *struct {
+			MaxAttempts         int          `json:"maxAttempts"`
+			HedgingDelay        string       `json:"hedgingDelay"`
+			NonFatalStatusCodes []codes.Code `json:"nonFatalStatusCodes"`
+		} `json:"hedgingPolicy"`
+	} `json:"methodConfig"`
+}
+
+// retryPolicy is a retry or hedging policy of a method
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	perTryTimeout  time.Duration
+	codes          map[codes.Code]bool // retryable codes, or non-fatal codes of hedging
+
+	hedging      bool
+	hedgingDelay time.Duration
+}
+
+func newRetryPolicy(maxAttempts int, initialBackoff, maxBackoff time.Duration, multiplier float64, retryOn []codes.Code) *retryPolicy {
+	if maxAttempts <= 1 {
+		return nil
+	}
+	if maxAttempts > maxAttemptsLimit {
+		maxAttempts = maxAttemptsLimit
+	}
+	if initialBackoff <= 0 {
+		initialBackoff = defaultInitialBackoff * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff * time.Millisecond
+	}
+	if multiplier <= 0 {
+		multiplier = defaultMultiplier
+	}
+
+	return &retryPolicy{
+		maxAttempts:    maxAttempts,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		multiplier:     multiplier,
+		codes:          codeSet(retryOn),
+	}
+}
+
+func newHedgingPolicy(maxAttempts int, delay time.Duration, nonFatal []codes.Code) *retryPolicy {
+	if maxAttempts <= 1 {
+		return nil
+	}
+	if maxAttempts > maxAttemptsLimit {
+		maxAttempts = maxAttemptsLimit
+	}
+
+	return &retryPolicy{
+		maxAttempts:  maxAttempts,
+		codes:        codeSet(nonFatal),
+		hedging:      true,
+		hedgingDelay: delay,
+	}
+}
+
+func codeSet(list []codes.Code) map[codes.Code]bool {
+	if len(list) == 0 {
+		list = []codes.Code{codes.Unavailable}
+	}
+
+	set := make(map[codes.Code]bool)
+	for _, c := range list {
+		set[c] = true
+	}
+	return set
+}
+
+// parseCodes parses code names like UNAVAILABLE or numbers
+func parseCodes(names []string) ([]codes.Code, error) {
+	list := make([]codes.Code, 0, len(names))
+	for _, name := range names {
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(`"` + strings.ToUpper(name) + `"`)); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, nil
+}
+
+// policyFromSetting returns policy of upstream setting, hedging takes precedence over retry
+func policyFromSetting(setting fmhttp.EndpointSetting) (*retryPolicy, error) {
+	if h := setting.Hedging; h != nil && h.MaxAttempts > 1 {
+		nonFatal, err := parseCodes(h.NonFatalCodes)
+		if err != nil {
+			return nil, err
+		}
+		return newHedgingPolicy(h.MaxAttempts, time.Duration(h.Delay)*time.Millisecond, nonFatal), nil
+	}
+
+	if r := setting.Retry; r != nil && r.MaxAttempts > 1 {
+		retryOn, err := parseCodes(r.RetryOnCodes)
+		if err != nil {
+			return nil, err
+		}
+		p := newRetryPolicy(r.MaxAttempts, time.Duration(r.BackoffBase)*time.Millisecond,
+			time.Duration(r.BackoffMax)*time.Millisecond, defaultMultiplier, retryOn)
+		p.perTryTimeout = time.Duration(r.PerTryTimeout) * time.Millisecond
+		return p, nil
+	}
+
+	return nil, nil
+}
+
+// parseServiceConfig returns policies by full method, or by /service/ for all methods of a service
+func parseServiceConfig(js string) (map[string]*retryPolicy, error) {
+	sc := serviceConfig{}
+	if err := json.Unmarshal([]byte(js), &sc); err != nil {
+		return nil, err
+	}
+
+	policies := make(map[string]*retryPolicy)
+	for _, mc := range sc.MethodConfig {
+		var p *retryPolicy
+		if h := mc.HedgingPolicy; h != nil {
+			delay, err := parseDuration(h.HedgingDelay)
+			if err != nil {
+				return nil, err
+			}
+			p = newHedgingPolicy(h.MaxAttempts, delay, h.NonFatalStatusCodes)
+		} else if r := mc.RetryPolicy; r != nil {
+			initial, err := parseDuration(r.InitialBackoff)
+			if err != nil {
+				return nil, err
+			}
+			maxBackoff, err := parseDuration(r.MaxBackoff)
+			if err != nil {
+				return nil, err
+			}
+			p = newRetryPolicy(r.MaxAttempts, initial, maxBackoff, r.BackoffMultiplier, r.RetryableStatusCodes)
+		}
+		if p == nil {
+			continue
+		}
+
+		for _, name := range mc.Name {
+			policies[fmt.Sprintf("/%s/%s", name.Service, name.Method)] = p
+		}
+	}
+
+	return policies, nil
+}
+
+func parseDuration(d string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(d)
+}
+
+// backoff waits before the given retry (starts from 1) with exponential backoff and full jitter,
+// returns false if ctx is done or its deadline is before the end of waiting
+func (p *retryPolicy) backoff(ctx context.Context, retry int) bool {
+	d := float64(p.initialBackoff)
+	for i := 1; i < retry && d < float64(p.maxBackoff); i++ {
+		d *= p.multiplier
+	}
+	if d > float64(p.maxBackoff) {
+		d = float64(p.maxBackoff)
+	}
+	wait := time.Duration(rand.Int63n(int64(d) + 1))
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// tryContext returns context for a single try with per try timeout
+func (p *retryPolicy) tryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.perTryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.perTryTimeout)
+}
+
+// methodRetrier keeps retry policies of methods of a target, policies in upstream setting
+// take precedence over the ones in service config
+type methodRetrier struct {
+	target   string
+	mutex    *sync.RWMutex
+	settings map[string]*retryPolicy // from upstream setting, by full method
+	config   map[string]*retryPolicy // from service config, by full method or /service/
+}
+
+func getRetrier(target string) *methodRetrier {
+	retriersMutex.Lock()
+	defer retriersMutex.Unlock()
+
+	if r, ok := retriers[target]; ok {
+		return r
+	}
+
+	r := &methodRetrier{
+		target:   target,
+		mutex:    &sync.RWMutex{},
+		settings: make(map[string]*retryPolicy),
+		config:   make(map[string]*retryPolicy),
+	}
+	for _, setting := range fmhttp.EndpointSettings(target) {
+		r.apply(setting)
+	}
+	retriers[target] = r
+
+	return r
+}
+
+// apply changes policy of the method with changed setting
+func (r *methodRetrier) apply(setting fmhttp.EndpointSetting) {
+	if !strings.HasPrefix(setting.URI, "/") {
+		return
+	}
+
+	p, err := policyFromSetting(setting)
+	if err != nil {
+		logger.Errorf("[Retry] bad retry setting of %s of %s, keep current: %v", setting.URI, r.target, err)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if p == nil {
+		delete(r.settings, setting.URI)
+	} else {
+		r.settings[setting.URI] = p
+	}
+}
+
+func (r *methodRetrier) setServiceConfig(js string) error {
+	config, err := parseServiceConfig(js)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.config = config
+	return nil
+}
+
+func (r *methodRetrier) policy(fullMethod string) *retryPolicy {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if p, ok := r.settings[fullMethod]; ok {
+		return p
+	}
+	if p, ok := r.config[fullMethod]; ok {
+		return p
+	}
+	if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+		return r.config[fullMethod[:i+1]]
+	}
+	return nil
+}
+
+// SetServiceConfig sets retry and hedging policies of the target in grpc service config json,
+// it replaces the previous one of the target
+func SetServiceConfig(target, serviceConfig string) error {
+	return getRetrier(target).setServiceConfig(serviceConfig)
+}
+
+// UnaryRetry returns an interceptor retrying or hedging calls by policy of the method, attempts
+// never go beyond the deadline of ctx. Hedged calls get responses into their own copies of reply,
+// so they work with proto replies only, and header or trailer call options should not be used.
+func UnaryRetry(target string) grpc.UnaryClientInterceptor {
+	r := getRetrier(target)
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		p := r.policy(method)
+		if p == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		if msg, ok := reply.(proto.Message); ok && p.hedging {
+			return r.hedge(ctx, p, method, req, msg, cc, invoker, opts...)
+		}
+		return r.retry(ctx, p, method, req, reply, cc, invoker, opts...)
+	}
+}
+
+func (r *methodRetrier) retry(ctx context.Context, p *retryPolicy, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+	for attempt := 1; ; attempt++ {
+		kind := attemptFirst
+		if attempt > 1 {
+			kind = attemptRetry
+		}
+		recordAttempt(r.target, method, kind)
+
+		tryCtx, cancel := p.tryContext(ctx)
+		err = invoker(tryCtx, method, req, reply, cc, opts...)
+		cancel()
+
+		if err == nil || attempt >= p.maxAttempts || !p.codes[status.Code(err)] || ctx.Err() != nil {
+			return
+		}
+		// retrying a method with open circuit only gets rejected
+		if getBreaker(r.target).isOpen(method) {
+			return
+		}
+		if !p.backoff(ctx, attempt) {
+			return
+		}
+		logging.FromContext(ctx).Debugf("[Retry] retry %s of %s after %v", method, r.target, err)
+	}
+}
+
+type hedgeResult struct {
+	attempt int
+	reply   proto.Message
+	err     error
+}
+
+func (r *methodRetrier) hedge(ctx context.Context, p *retryPolicy, method string, req interface{}, reply proto.Message, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	// losers are canceled once a result is taken
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, p.maxAttempts)
+	sent, pending := 0, 0
+	send := func() {
+		sent++
+		pending++
+		kind := attemptFirst
+		if sent > 1 {
+			kind = attemptHedge
+		}
+		recordAttempt(r.target, method, kind)
+
+		attempt, out := sent, proto.Clone(reply)
+		out.Reset()
+		go func() {
+			err := invoker(ctx, method, req, out, cc, opts...)
+			results <- hedgeResult{attempt: attempt, reply: out, err: err}
+		}()
+	}
+
+	send()
+	timer := time.NewTimer(p.hedgingDelay)
+	defer timer.Stop()
+
+	var err error
+	for {
+		select {
+		case <-timer.C:
+			if sent < p.maxAttempts {
+				send()
+				timer.Reset(p.hedgingDelay)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil || !p.codes[status.Code(res.err)] {
+				if res.err == nil {
+					reply.Reset()
+					proto.Merge(reply, res.reply)
+					if res.attempt > 1 {
+						recordHedgeWin(r.target, method)
+					}
+				}
+				return res.err
+			}
+
+			// non-fatal error, wait for others or send the next one at once
+			err = res.err
+			if pending > 0 {
+				continue
+			}
+			if sent >= p.maxAttempts || ctx.Err() != nil {
+				return err
+			}
+			send()
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(p.hedgingDelay)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fmhttp "github.com/butters-mars/tiki/client/http"
+)
+
+func TestRetry(t *testing.T) {
+	var hits, flaky int32
+	addr, stop := serveTest(t, func(method string) (proto.Message, error) {
+		atomic.AddInt32(&hits, 1)
+		switch method {
+		case "/test.Svc/Flaky":
+			if atomic.AddInt32(&flaky, 1) <= 2 {
+				return nil, status.Error(codes.Unavailable, "down")
+			}
+		case "/test.Svc/Fail":
+			return nil, status.Error(codes.Unavailable, "down")
+		case "/test.Svc/Missing":
+			return nil, status.Error(codes.NotFound, "missing")
+		}
+		return &empty.Empty{}, nil
+	})
+	defer stop()
+
+	r := getRetrier(addr)
+	for _, uri := range []string{"/test.Svc/Flaky", "/test.Svc/Fail", "/test.Svc/Missing"} {
+		r.apply(fmhttp.EndpointSetting{URI: uri, Retry: &fmhttp.Retry{MaxAttempts: 3, BackoffBase: 1}})
+	}
+	cc := dialTest(t, addr)
+	defer cc.Close()
+
+	cases := []struct {
+		method string
+		code   codes.Code
+		hits   int32
+	}{
+		{"/test.Svc/Flaky", codes.OK, 3},
+		{"/test.Svc/Fail", codes.Unavailable, 3},
+		{"/test.Svc/Missing", codes.NotFound, 1},
+		{"/test.Svc/Other", codes.OK, 1},
+	}
+	for _, c := range cases {
+		atomic.StoreInt32(&hits, 0)
+		err := invoke(cc, c.method)
+		if status.Code(err) != c.code || atomic.LoadInt32(&hits) != c.hits {
+			t.Errorf("%s should get %v with %d attempts: %v %d", c.method, c.code, c.hits, err, hits)
+		}
+	}
+
+	// no retry beyond deadline of caller
+	r.apply(fmhttp.EndpointSetting{URI: "/test.Svc/Fail", Retry: &fmhttp.Retry{MaxAttempts: 3, BackoffBase: 500, BackoffMax: 500}})
+	atomic.StoreInt32(&hits, 0)
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := cc.Invoke(ctx, "/test.Svc/Fail", &empty.Empty{}, &empty.Empty{})
+	if status.Code(err) != codes.Unavailable || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("should give up before deadline: %v %v %d", err, time.Since(start), hits)
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	// retriers are shared by target, use one of this run only
+	target := fmt.Sprintf("service-config-%d", time.Now().UnixNano())
+	defer func() {
+		retriersMutex.Lock()
+		delete(retriers, target)
+		retriersMutex.Unlock()
+	}()
+	r := getRetrier(target)
+	err := r.setServiceConfig(`{"methodConfig": [{
+		"name": [{"service": "test.Svc"}],
+		"retryPolicy": {"maxAttempts": 10, "initialBackoff": "0.1s", "maxBackoff": "1s",
+			"backoffMultiplier": 2, "retryableStatusCodes": ["UNAVAILABLE", "ABORTED"]}
+	}, {
+		"name": [{"service": "test.Svc", "method": "Tail"}],
+		"hedgingPolicy": {"maxAttempts": 2, "hedgingDelay": "0.05s"}
+	}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := r.policy("/test.Svc/Any")
+	if p == nil || p.hedging || p.maxAttempts != maxAttemptsLimit || p.initialBackoff != 100*time.Millisecond ||
+		!p.codes[codes.Aborted] || p.codes[codes.Internal] {
+		t.Errorf("wrong retry policy: %+v", p)
+	}
+	if p = r.policy("/test.Svc/Tail"); p == nil || !p.hedging || p.hedgingDelay != 50*time.Millisecond {
+		t.Errorf("wrong hedging policy: %+v", p)
+	}
+	if p = r.policy("/other.Svc/Any"); p != nil {
+		t.Errorf("should have no policy: %+v", p)
+	}
+
+	r.apply(fmhttp.EndpointSetting{URI: "/test.Svc/Any", Retry: &fmhttp.Retry{MaxAttempts: 2, RetryOnCodes: []string{"internal"}}})
+	if p = r.policy("/test.Svc/Any"); p == nil || p.maxAttempts != 2 || !p.codes[codes.Internal] {
+		t.Errorf("upstream setting should take precedence: %+v", p)
+	}
+
+	if err = r.setServiceConfig(`{"methodConfig": [{"retryPolicy": {"maxAttempts": 2, "initialBackoff": "bad"}}]}`); err == nil {
+		t.Errorf("should fail with bad duration")
+	}
+}
+
+func TestHedging(t *testing.T) {
+	var hits int32
+	addr, stop := serveTest(t, func(method string) (proto.Message, error) {
+		hit := atomic.AddInt32(&hits, 1)
+		switch method {
+		case "/test.Svc/Tail":
+			// the first request hits tail latency
+			if hit == 1 {
+				time.Sleep(300 * time.Millisecond)
+			}
+		case "/test.Svc/Down":
+			return nil, status.Error(codes.Unavailable, "down")
+		}
+		return &wrappers.StringValue{Value: fmt.Sprint(hit)}, nil
+	})
+	defer stop()
+
+	r := getRetrier(addr)
+	for _, uri := range []string{"/test.Svc/Tail", "/test.Svc/Down"} {
+		r.apply(fmhttp.EndpointSetting{URI: uri, Hedging: &fmhttp.Hedging{MaxAttempts: 3, Delay: 50}})
+	}
+	cc := dialTest(t, addr)
+	defer cc.Close()
+
+	start := time.Now()
+	reply := &wrappers.StringValue{}
+	err := cc.Invoke(context.TODO(), "/test.Svc/Tail", &empty.Empty{}, reply)
+	if err != nil || reply.Value != "2" || time.Since(start) > 200*time.Millisecond {
+		t.Errorf("hedged request should win: %v %v %v", err, reply, time.Since(start))
+	}
+
+	// non-fatal errors send the next request at once
+	atomic.StoreInt32(&hits, 0)
+	start = time.Now()
+	err = cc.Invoke(context.TODO(), "/test.Svc/Down", &empty.Empty{}, reply)
+	if status.Code(err) != codes.Unavailable || atomic.LoadInt32(&hits) != 3 || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("should fail after 3 attempts: %v %d %v", err, hits, time.Since(start))
+	}
+}
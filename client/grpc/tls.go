@@ -0,0 +1,99 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+
+	fmhttp "github.com/butters-mars/tiki/client/http"
+	"github.com/butters-mars/tiki/utils"
+)
+
+var (
+	clientTLSMutex = &sync.RWMutex{}
+	clientTLS      *fmhttp.TLSSetting
+)
+
+// SetClientTLS sets tls of upstreams without tls in upstream setting, nil means insecure
+func SetClientTLS(setting *fmhttp.TLSSetting) {
+	clientTLSMutex.Lock()
+	defer clientTLSMutex.Unlock()
+
+	clientTLS = setting
+}
+
+// tlsSetting returns tls setting of the target in upstream setting, or the default one
+func tlsSetting(target string) *fmhttp.TLSSetting {
+	settings := fmhttp.EndpointSettings(target)
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if setting := settings[k]; setting.TLS != nil {
+			return setting.TLS
+		}
+	}
+
+	clientTLSMutex.RLock()
+	defer clientTLSMutex.RUnlock()
+	return clientTLS
+}
+
+// reloadingCredentials makes tls handshakes with current certificates of the reloader,
+// so that rotated client certificates and CA bundles are used by new connections
+type reloadingCredentials struct {
+	credentials.TransportCredentials
+
+	reloader   *utils.CertReloader
+	serverName string
+	insecure   bool
+}
+
+// transportCredentials returns client tls credentials of the target, nil if tls is not set
+func transportCredentials(target string) (credentials.TransportCredentials, error) {
+	setting := tlsSetting(target)
+	if setting == nil {
+		return nil, nil
+	}
+
+	reloader, err := utils.NewCertReloader(setting.CAFile, setting.CertFile, setting.KeyFile,
+		time.Duration(setting.ReloadInterval)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if setting.InsecureSkipVerify {
+		logger.Warnf("[TLS] certificate verification of %s is disabled", target)
+	}
+
+	c := &reloadingCredentials{
+		reloader:   reloader,
+		serverName: setting.ServerName,
+		insecure:   setting.InsecureSkipVerify,
+	}
+	c.TransportCredentials = c.current()
+	return c, nil
+}
+
+func (c *reloadingCredentials) current() credentials.TransportCredentials {
+	return credentials.NewTLS(c.reloader.ClientConfig(c.serverName, c.insecure))
+}
+
+func (c *reloadingCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.current().ClientHandshake(ctx, authority, conn)
+}
+
+func (c *reloadingCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+func (c *reloadingCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
@@ -26,12 +26,22 @@ var (
 	//mutext = sync.RWMutex{}
 	// use global map to avoid recreating apiclient
 	//globalClients = make(map[string]*DefaultClient)
+
+	// live clients by host and id, to apply setting changes
+	liveClients      = make(map[string]map[string]DefaultClient)
+	liveClientsMutex = sync.RWMutex{}
+
+	// listeners of setting changes other than http clients, e.g. grpc clients
+	settingListeners      = make([]func(target string, setting EndpointSetting), 0)
+	settingListenersMutex = sync.RWMutex{}
 )
 
 // Client the new client that supports circuitbreak, client-side lb, metrics etc.
 type Client interface {
-	Do(ctx context.Context, uri, method string, param interface{}, resp interface{}) (err error)
-	DoRaw(ctx context.Context, uri, method string, param interface{}) (resp []byte, code int, err error)
+	Do(ctx context.Context, uri, method string, param interface{}, resp interface{}, opts ...RequestOption) (err error)
+	DoRaw(ctx context.Context, uri, method string, param interface{}, opts ...RequestOption) (resp []byte, code int, err error)
+	Request(ctx context.Context, uri, method string, param interface{}, opts ...RequestOption) (resp *Response, err error)
+	Close() error
 }
 
 // DefaultClient provides a default implementation of ApiClient
@@ -49,28 +59,102 @@ func setSource(s string) {
 	source = s
 }
 
+// Source returns the src tag for metrics
+func Source() string {
+	return source
+}
+
 // SetServiceDiscoveryCfg setup service discovery configuration
 func SetServiceDiscoveryCfg(cfg string) {
 	serviceDiscoveryCfgStr = cfg
 	serviceDiscoveryCfg = parseSDCfg(cfg)
 }
 
-// SetSettingProvider setup endpoint setting provider
+// SetSettingProvider setup endpoint setting provider, setting changes from
+// the provider are applied to live clients
 func SetSettingProvider(p SettingProvider) {
 	settingProvider = p
+	if p != nil {
+		p.SetHandler(applySetting)
+	}
+}
+
+// EndpointSettings returns settings of the target from the setting provider, keyed by <method>-<uri>
+func EndpointSettings(target string) map[string]EndpointSetting {
+	if settingProvider == nil {
+		return nil
+	}
+
+	settings, err := settingProvider.GetSettings(target)
+	if err != nil {
+		logger.Errorf("fail to get setting of %s from settingProvider: %v", target, err)
+	}
+	return settings
+}
+
+// AddSettingListener adds a listener called with the changed setting of a target
+func AddSettingListener(listener func(target string, setting EndpointSetting)) {
+	settingListenersMutex.Lock()
+	defer settingListenersMutex.Unlock()
+
+	settingListeners = append(settingListeners, listener)
+}
+
+// applySetting applies changed setting to all live clients of the target
+func applySetting(target string, setting EndpointSetting) error {
+	settingListenersMutex.RLock()
+	listeners := settingListeners
+	settingListenersMutex.RUnlock()
+	for _, listener := range listeners {
+		listener(target, setting)
+	}
+
+	liveClientsMutex.RLock()
+	clients := make([]DefaultClient, 0, len(liveClients[target]))
+	for _, c := range liveClients[target] {
+		clients = append(clients, c)
+	}
+	liveClientsMutex.RUnlock()
+
+	// apply to all clients even if some fail, so that none is left on the old setting silently
+	errs := make([]string, 0)
+	for _, c := range clients {
+		s := setting
+		if err := c.SetEndpointSetting(&s); err != nil {
+			logger.Errorf("[%s] fail to apply setting %s-%s: %v", c.id, setting.Method, setting.URI, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", c.id, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("fail to apply setting %s-%s to %d of %d clients: %s",
+			setting.Method, setting.URI, len(errs), len(clients), strings.Join(errs, "; "))
+	}
+
+	return nil
 }
 
-// SetupClient initializes global api client setting
+// SetupClient initializes global api client setting, upstreamSetting is a yaml
+// file path or a consul kv url, see NewConsulSettingProviderFromURL
 func SetupClient(source, upstreamSetting, discoveryInfo string) {
 	setSource(source)
 	initMetrics()
-	if upstreamSetting != "" {
+	if strings.HasPrefix(upstreamSetting, SchemeConsulSetting+"://") {
+		provider, err := NewConsulSettingProviderFromURL(upstreamSetting)
+		if err != nil {
+			logger.Errorf("fail to create setting provider from %s: %v", upstreamSetting, err)
+		} else {
+			logger.Infof("upstream setting loaded from %s", upstreamSetting)
+			SetSettingProvider(provider)
+			provider.Watch()
+		}
+	} else if upstreamSetting != "" {
 		provider, err := NewFileSettingProvider(upstreamSetting)
 		if err != nil {
 			logger.Errorf("fail to create setting provider from %s: %v", upstreamSetting, err)
 		} else {
 			logger.Infof("upstream setting loaded from %s", upstreamSetting)
 			SetSettingProvider(provider)
+			provider.Watch(defaultWatchInterval)
 		}
 	}
 
@@ -110,15 +194,17 @@ func NewClient(host string) Client {
 // NewClientWithSD returns client with given host
 func NewClientWithSD(host string, useServiceDiscovery bool) Client {
 	id := fmt.Sprintf("%s-%d-%d", host, time.Now().Nanosecond(), rand.Intn(10000))
-	logger.Info("creating new api client %s", id)
+	logger.Infof("creating new api client %s", id)
 
-	var settings map[string]EndpointSetting
+	settings := make(map[string]EndpointSetting)
 	if settingProvider != nil {
 		_settings, err := settingProvider.GetSettings(host)
 		if err != nil {
-			logger.Error("fail to get setting from settingProvider: %v", err)
+			logger.Errorf("fail to get setting from settingProvider: %v", err)
+		}
+		for key, setting := range _settings {
+			settings[key] = setting
 		}
-		settings = _settings
 	}
 
 	client := DefaultClient{
@@ -131,24 +217,95 @@ func NewClientWithSD(host string, useServiceDiscovery bool) Client {
 	}
 
 	for key, setting := range settings {
+		setting := setting
 		c, err := client.createEndpointClient(&setting)
 		if err != nil {
-			logger.Error("fail to create endpoint client for %s, err: %v", key, err)
+			logger.Errorf("fail to create endpoint client for %s, err: %v", key, err)
 			continue
 		}
 		client.endpoints[key] = c
 	}
 
+	liveClientsMutex.Lock()
+	if _, ok := liveClients[host]; !ok {
+		liveClients[host] = make(map[string]DefaultClient)
+	}
+	liveClients[host][id] = client
+	liveClientsMutex.Unlock()
+
 	return client
 }
 
-// SetEndpointSetting dynamically changes setting (NOT IMPL YET)
+// Close unregisters the client from setting changes and stops service discovery of its
+// endpoints, the client should not be used once closed
+func (c DefaultClient) Close() error {
+	liveClientsMutex.Lock()
+	delete(liveClients[c.host], c.id)
+	if len(liveClients[c.host]) == 0 {
+		delete(liveClients, c.host)
+	}
+	liveClientsMutex.Unlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, client := range c.endpoints {
+		client.close()
+		delete(c.endpoints, key)
+	}
+	logger.Infof("api client %s closed", c.id)
+	return nil
+}
+
+// SetEndpointSetting dynamically changes setting, the endpoint client is
+// updated in place so in-flight requests are not dropped
 func (c DefaultClient) SetEndpointSetting(setting *EndpointSetting) (err error) {
+	if setting == nil {
+		return fmt.Errorf("nil setting")
+	}
+
+	s := *setting
+	key := fmt.Sprintf("%s-%s", s.Method, s.URI)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.settings[key] = s
+	if client, ok := c.endpoints[key]; ok {
+		applyDefaultCBConfig(&s)
+		return client.update(&s)
+	}
+
+	client, err := c.createEndpointClient(&s)
+	if err != nil {
+		logger.Errorf("[%s]cannot create endpoint client for %s, err: %v", c.id, key, err)
+		return
+	}
+	c.endpoints[key] = client
+
 	return
 }
 
-// SetEndpointSettings dynamically changes setting (NOT IMPL YET)
+// SetEndpointSettings dynamically changes settings, see SetEndpointSetting
 func (c DefaultClient) SetEndpointSettings(settings []*EndpointSetting) (err error) {
+	for _, setting := range settings {
+		if err = c.SetEndpointSetting(setting); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// SetFallback sets fallback of the endpoint, it takes precedence over the one in setting,
+// nil to use the one in setting
+func (c DefaultClient) SetFallback(uri, method string, fallback Fallback) (err error) {
+	client, err := c.getEndpointClient(uri, method)
+	if err != nil {
+		logger.Errorf("cannot get endpoint client for %s-%s, err: %v", uri, method, err)
+		return
+	}
+
+	client.setFallback(fallback)
 	return
 }
 
@@ -158,37 +315,54 @@ func initMetrics() {
 }
 
 // Do delegates the request to endpoint client
-func (c DefaultClient) Do(ctx context.Context, uri, method string, param interface{}, resp interface{}) (err error) {
+func (c DefaultClient) Do(ctx context.Context, uri, method string, param interface{}, resp interface{}, opts ...RequestOption) (err error) {
 	client, err := c.getEndpointClient(uri, method)
 	if err != nil {
-		logger.Error("cannot get endpoint client for %s-%s, err: %v", uri, method, err)
+		logging.FromContext(ctx).Errorf("cannot get endpoint client for %s-%s, err: %v", uri, method, err)
 		return
 	}
 
 	if client == nil {
-		logger.Error("nil client for %s_%s", uri, method)
+		logging.FromContext(ctx).Errorf("nil client for %s_%s", uri, method)
 		err = fmt.Errorf("nil client")
 		return
 	}
 
-	return client.Do(ctx, uri, method, param, resp)
+	return client.Do(ctx, uri, method, param, resp, opts...)
 }
 
 // DoRaw delegates the request to endpoint client, supports byte[] as param, and returns []byte, status code
-func (c DefaultClient) DoRaw(ctx context.Context, uri, method string, param interface{}) (resp []byte, code int, err error) {
+func (c DefaultClient) DoRaw(ctx context.Context, uri, method string, param interface{}, opts ...RequestOption) (resp []byte, code int, err error) {
+	client, err := c.getEndpointClient(uri, method)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("cannot get endpoint client for %s-%s, err: %v", uri, method, err)
+		return
+	}
+
+	if client == nil {
+		logging.FromContext(ctx).Errorf("nil client for %s_%s", uri, method)
+		err = fmt.Errorf("nil client")
+		return
+	}
+
+	return client.DoRaw(ctx, uri, method, param, opts...)
+}
+
+// Request delegates the request to endpoint client, and returns status, headers and body
+func (c DefaultClient) Request(ctx context.Context, uri, method string, param interface{}, opts ...RequestOption) (resp *Response, err error) {
 	client, err := c.getEndpointClient(uri, method)
 	if err != nil {
-		logger.Error("cannot get endpoint client for %s-%s, err: %v", uri, method, err)
+		logging.FromContext(ctx).Errorf("cannot get endpoint client for %s-%s, err: %v", uri, method, err)
 		return
 	}
 
 	if client == nil {
-		logger.Error("nil client for %s_%s", uri, method)
+		logging.FromContext(ctx).Errorf("nil client for %s_%s", uri, method)
 		err = fmt.Errorf("nil client")
 		return
 	}
 
-	return client.DoRaw(ctx, uri, method, param)
+	return client.Request(ctx, uri, method, param, opts...)
 }
 
 func (c DefaultClient) getEndpointClient(uri, method string) (*endpointClient, error) {
@@ -229,12 +403,12 @@ func (c DefaultClient) createByDefaultCfg(key, uri, method string) (*endpointCli
 	}
 	_c, err := c.createEndpointClient(setting)
 	if err != nil {
-		logger.Error("[%s]cannot create endpoint client for %s_%s", c.id, uri, method)
+		logger.Errorf("[%s]cannot create endpoint client for %s_%s", c.id, uri, method)
 		return nil, err
 	}
 
 	if _c == nil {
-		logger.Error("[%s]nil endpoint client for %s_%s", c.id, uri, method)
+		logger.Errorf("[%s]nil endpoint client for %s_%s", c.id, uri, method)
 		return nil, fmt.Errorf("nil endpoint client")
 	}
 
@@ -245,8 +419,21 @@ func (c DefaultClient) createByDefaultCfg(key, uri, method string) (*endpointCli
 }
 
 func (c DefaultClient) createEndpointClient(setting *EndpointSetting) (*endpointClient, error) {
-	logger.Info("[APIClient] %s create apiclient for %s%s-%s", c.id, c.host, setting.URI, setting.Method)
+	logger.Infof("[APIClient] %s create apiclient for %s%s-%s", c.id, c.host, setting.URI, setting.Method)
+
+	applyDefaultCBConfig(setting)
+
+	sdType := endpointer.SDTypeNone
+	if c.useServiceDiscovery {
+		sdType = endpointer.SDTypeConsul
+		if serviceDiscoveryCfg["type"] == string(endpointer.SDTypeFile) {
+			sdType = endpointer.SDTypeFile
+		}
+	}
+	return newEndpointClient(c.host, setting, sdType)
+}
 
+func applyDefaultCBConfig(setting *EndpointSetting) {
 	defaultCBConfig := middleware.DefaultCBConfig
 
 	if setting.CBConfig.Timeout == 0 {
@@ -264,14 +451,9 @@ func (c DefaultClient) createEndpointClient(setting *EndpointSetting) (*endpoint
 	if setting.CBConfig.SleepWindow == 0 {
 		setting.CBConfig.SleepWindow = defaultCBConfig.SleepWindow
 	}
-
-	sdType := endpointer.SDTypeNone
-	if c.useServiceDiscovery {
-		sdType = endpointer.SDTypeConsul
-	}
-	return newEndpointClient(c.host, setting, sdType)
 }
 
+// parseSDCfg parses consul::<addr>/<datacenter> or file::<registry-file>
 func parseSDCfg(cfg string) map[string]string {
 	var cfgMap map[string]string
 	if cfg == "" {
@@ -281,7 +463,7 @@ func parseSDCfg(cfg string) map[string]string {
 
 	segs := strings.Split(cfg, "::")
 	if len(segs) < 2 {
-		logger.Error("bad service discovery config: %s", cfg)
+		logger.Errorf("bad service discovery config: %s", cfg)
 		return nil
 	}
 
@@ -291,7 +473,7 @@ func parseSDCfg(cfg string) map[string]string {
 	case "consul":
 		segs = strings.Split(info, "/")
 		if len(segs) != 2 {
-			logger.Error("bad consul service discovery config: %s", cfg)
+			logger.Errorf("bad consul service discovery config: %s", cfg)
 			return nil
 		}
 		cfgMap = make(map[string]string)
@@ -300,10 +482,16 @@ func parseSDCfg(cfg string) map[string]string {
 		cfgMap["datacenter"] = segs[1]
 		return cfgMap
 
+	case "file":
+		cfgMap = make(map[string]string)
+		cfgMap["type"] = _type
+		cfgMap["path"] = info
+		return cfgMap
+
 	default:
 
 	}
 
-	logger.Error("unsupported service discovery config: %s", cfg)
+	logger.Errorf("unsupported service discovery config: %s", cfg)
 	return nil
 }
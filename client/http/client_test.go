@@ -2,10 +2,18 @@ package http
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/afex/hystrix-go/hystrix"
+
+	"github.com/butters-mars/tiki/sd"
 )
 
 var (
@@ -49,19 +57,26 @@ func (p mockSettingProvider) GetSettings(tgt string) (map[string]EndpointSetting
 	return nil, nil
 }
 
-func (p mockSettingProvider) SetHandler(h func(EndpointSetting) error) {
+func (p mockSettingProvider) SetHandler(h func(string, EndpointSetting) error) {
 }
 
 func TestApiClient(t *testing.T) {
 	sdConfig := "consul::localhost:8500/dc1"
 
-	SetSource("A")
+	// needs consul and the "test" service running locally
+	if conn, err := net.DialTimeout("tcp", "localhost:8500", 100*time.Millisecond); err != nil {
+		t.Skipf("consul not available: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	setSource("A")
 	SetServiceDiscoveryCfg(sdConfig)
 
 	SetSettingProvider(mockSettingProvider{})
 
 	//cl := NewDefaultClient("localhost:8886", []*EndpointSetting{s1, s2})
-	cl := GetDefaultClientWithSD("test", true)
+	cl := NewClientWithSD("test", true)
 
 	resp := make(map[string]interface{})
 	err := cl.Do(context.TODO(), "/good", "GET", nil, &resp)
@@ -100,7 +115,7 @@ func TestApiClient(t *testing.T) {
 		return
 	}
 
-	cl = GetDefaultClientWithSD("badxxsdssfsfs", true)
+	cl = NewClientWithSD("badxxsdssfsfs", true)
 	resp = make(map[string]interface{})
 	err = cl.Do(context.TODO(), "/haha", "GET", nil, &resp)
 	if err == nil {
@@ -112,7 +127,7 @@ func TestApiClient(t *testing.T) {
 		return
 	}
 
-	cl = GetDefaultClientWithSD("test", true)
+	cl = NewClientWithSD("test", true)
 	resp = make(map[string]interface{})
 	for i := 0; i < 5; i++ {
 		err = cl.Do(context.TODO(), "/5ms-15ms", "GET", nil, &resp)
@@ -127,3 +142,25 @@ func TestApiClient(t *testing.T) {
 		return
 	}
 }
+
+func TestFileServiceDiscovery(t *testing.T) {
+	fn := fmt.Sprintf("/tmp/sd-%d.json", time.Now().Nanosecond())
+	defer os.Remove(fn)
+	defer os.Remove(fn + ".lock")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	sd.NewFileRegisteror(fn).Register(&sd.SvcDef{ID: "file-svc-1", Name: "file-svc", Addr: addr.IP.String(), Port: addr.Port})
+
+	defer SetServiceDiscoveryCfg(serviceDiscoveryCfgStr)
+	SetServiceDiscoveryCfg("file::" + fn)
+
+	resp := make(map[string]bool)
+	if err := NewClientWithSD("file-svc", true).Do(context.TODO(), "/ok", "GET", nil, &resp); err != nil || !resp["ok"] {
+		t.Errorf("should call instance in registry file: %v %v", resp, err)
+	}
+}
@@ -0,0 +1,271 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// SchemeConsulSetting is the scheme of upstream setting in consul kv,
+	// e.g. consul://localhost:8500/tiki/upstream?dc=dc1&snapshot=/var/tiki/upstream.yml
+	SchemeConsulSetting = "consul"
+
+	consulWaitTime = 5 * time.Minute
+	consulRetryMin = time.Second
+	consulRetryMax = 30 * time.Second
+)
+
+// ConsulSettingProvider reads endpoint settings from consul kv, each key
+// <prefix>/<target> holds a yaml list of EndpointSetting of the target.
+// Changes are watched with blocking queries once Watch is called, the last
+// settings are saved to an optional snapshot file in the same format as
+// FileSettingProvider, which is used when consul is unreachable at boot.
+type ConsulSettingProvider struct {
+	kv         *consul.KV
+	prefix     string
+	snapshot   string
+	settingMap map[string]map[string]EndpointSetting
+	handler    func(target string, setting EndpointSetting) error
+	index      uint64
+	mutex      *sync.RWMutex
+	cancel     context.CancelFunc
+}
+
+// NewConsulSettingProvider creates a setting provider with the kv prefix,
+// snapshot is the fallback file path, empty to disable it.
+func NewConsulSettingProvider(cfg *consul.Config, prefix, snapshot string) (*ConsulSettingProvider, error) {
+	if cfg == nil {
+		cfg = consul.DefaultConfig()
+	}
+	c, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ConsulSettingProvider{
+		kv:       c.KV(),
+		prefix:   strings.Trim(prefix, "/") + "/",
+		snapshot: snapshot,
+		mutex:    &sync.RWMutex{},
+	}
+
+	pairs, meta, err := p.kv.List(p.prefix, nil)
+	if err != nil {
+		if snapshot == "" {
+			return nil, err
+		}
+
+		logger.Warnf("[ConsulSetting] fail to list %s, fallback to snapshot %s: %v", p.prefix, snapshot, err)
+		settingMap, serr := loadSnapshot(snapshot)
+		if serr != nil {
+			return nil, fmt.Errorf("consul: %v, snapshot: %v", err, serr)
+		}
+		p.settingMap = settingMap
+		return p, nil
+	}
+
+	p.settingMap = p.parse(pairs)
+	p.index = meta.LastIndex
+	p.saveSnapshot(p.settingMap)
+
+	return p, nil
+}
+
+// NewConsulSettingProviderFromURL creates a setting provider with url in form of
+// consul://<agent-addr>/<prefix>?dc=<datacenter>&scheme=<http|https>&snapshot=<path>
+func NewConsulSettingProviderFromURL(rawurl string) (*ConsulSettingProvider, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != SchemeConsulSetting {
+		return nil, fmt.Errorf("bad consul setting url %s", rawurl)
+	}
+
+	cfg := consul.DefaultConfig()
+	if u.Host != "" {
+		cfg.Address = u.Host
+	}
+	query := u.Query()
+	if dc := query.Get("dc"); dc != "" {
+		cfg.Datacenter = dc
+	}
+	if scheme := query.Get("scheme"); scheme != "" {
+		cfg.Scheme = scheme
+	}
+
+	return NewConsulSettingProvider(cfg, u.Path, query.Get("snapshot"))
+}
+
+// parse converts kv pairs to settings by target, bad values are skipped
+func (p *ConsulSettingProvider) parse(pairs consul.KVPairs) map[string]map[string]EndpointSetting {
+	settingMap := make(map[string]map[string]EndpointSetting)
+	for _, pair := range pairs {
+		target := strings.TrimPrefix(pair.Key, p.prefix)
+		if target == "" || strings.HasSuffix(target, "/") {
+			continue
+		}
+
+		var settings []EndpointSetting
+		if err := yaml.Unmarshal(pair.Value, &settings); err != nil {
+			logger.Errorf("[ConsulSetting] bad setting of %s, skipped: %v", pair.Key, err)
+			continue
+		}
+		settingMap[target] = settingsByKey(settings)
+	}
+
+	return settingMap
+}
+
+// GetSettings implements SettingProvider.GetSettings
+func (p *ConsulSettingProvider) GetSettings(target string) (map[string]EndpointSetting, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	epMap := make(map[string]EndpointSetting)
+	for key, setting := range p.settingMap[target] {
+		epMap[key] = setting
+	}
+
+	return epMap, nil
+}
+
+// SetHandler implements SettingProvider.SetHandler
+func (p *ConsulSettingProvider) SetHandler(handler func(target string, setting EndpointSetting) error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.handler = handler
+}
+
+// Watch starts watching the prefix with blocking queries
+func (p *ConsulSettingProvider) Watch() {
+	p.mutex.Lock()
+	if p.cancel != nil {
+		p.mutex.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.mutex.Unlock()
+
+	go p.watch(ctx)
+}
+
+// Stop stops watching
+func (p *ConsulSettingProvider) Stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+}
+
+func (p *ConsulSettingProvider) watch(ctx context.Context) {
+	retry := consulRetryMin
+	for {
+		p.mutex.RLock()
+		index := p.index
+		p.mutex.RUnlock()
+
+		opts := (&consul.QueryOptions{WaitIndex: index, WaitTime: consulWaitTime}).WithContext(ctx)
+		pairs, meta, err := p.kv.List(p.prefix, opts)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Errorf("[ConsulSetting] fail to watch %s, retry in %v: %v", p.prefix, retry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			if retry *= 2; retry > consulRetryMax {
+				retry = consulRetryMax
+			}
+			continue
+		}
+		retry = consulRetryMin
+
+		if meta.LastIndex == index {
+			continue
+		}
+		p.update(pairs, meta.LastIndex)
+	}
+}
+
+// update swaps settings and notifies the handler of changes
+func (p *ConsulSettingProvider) update(pairs consul.KVPairs, index uint64) {
+	settingMap := p.parse(pairs)
+
+	p.mutex.Lock()
+	// index going backwards means the kv store is reset, restart from 0
+	if index < p.index {
+		index = 0
+	}
+	p.index = index
+	old := p.settingMap
+	p.settingMap = settingMap
+	handler := p.handler
+	p.mutex.Unlock()
+
+	logger.Infof("[ConsulSetting] reloaded %s at index %d", p.prefix, index)
+	notifyChanges(handler, old, settingMap)
+	p.saveSnapshot(settingMap)
+}
+
+func (p *ConsulSettingProvider) saveSnapshot(settingMap map[string]map[string]EndpointSetting) {
+	if p.snapshot == "" {
+		return
+	}
+
+	holder := &settingHolder{Settings: make(map[string][]EndpointSetting)}
+	for target, epMap := range settingMap {
+		settings := make([]EndpointSetting, 0, len(epMap))
+		for _, setting := range epMap {
+			settings = append(settings, setting)
+		}
+		holder.Settings[target] = settings
+	}
+
+	data, err := yaml.Marshal(holder)
+	if err != nil {
+		logger.Errorf("[ConsulSetting] fail to marshal snapshot: %v", err)
+		return
+	}
+
+	// write to temp file and rename, so that a crash never leaves a partial snapshot
+	tmp, err := ioutil.TempFile(filepath.Dir(p.snapshot), filepath.Base(p.snapshot)+".tmp")
+	if err != nil {
+		logger.Errorf("[ConsulSetting] fail to save snapshot %s: %v", p.snapshot, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p.snapshot)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Errorf("[ConsulSetting] fail to save snapshot %s: %v", p.snapshot, err)
+	}
+}
+
+func loadSnapshot(path string) (map[string]map[string]EndpointSetting, error) {
+	p := &FileSettingProvider{path: path, mutex: &sync.RWMutex{}}
+	return p.load()
+}
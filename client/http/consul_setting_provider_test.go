@@ -0,0 +1,170 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// fakeKV is an in-process fake of consul kv http api with blocking queries
+type fakeKV struct {
+	mutex   sync.Mutex
+	index   uint64
+	pairs   map[string][]byte
+	changed chan struct{}
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{index: 1, pairs: make(map[string][]byte), changed: make(chan struct{})}
+}
+
+func (f *fakeKV) put(key, value string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pairs[key] = []byte(value)
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	prefix := strings.TrimPrefix(req.URL.Path, "/v1/kv/")
+	wait, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64)
+
+	f.mutex.Lock()
+	if wait > 0 && wait >= f.index {
+		changed := f.changed
+		f.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+		f.mutex.Lock()
+	}
+	defer f.mutex.Unlock()
+
+	pairs := make([]*consul.KVPair, 0)
+	for key, value := range f.pairs {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, &consul.KVPair{Key: key, Value: value, ModifyIndex: f.index})
+		}
+	}
+
+	w.Header().Set("X-Consul-Index", fmt.Sprint(f.index))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	if len(pairs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func consulSetting(timeout int) string {
+	return fmt.Sprintf(`
+- uri: /hello
+  method: GET
+  hystrix:
+    timeout: %d
+`, timeout)
+}
+
+func TestConsulSettingProvider(t *testing.T) {
+	kv := newFakeKV()
+	kv.put("tiki/upstream/test.srv", consulSetting(100))
+	kv.put("tiki/upstream/bad.srv", "{{")
+	srv := httptest.NewServer(kv)
+	defer srv.Close()
+
+	snapshot := fmt.Sprintf("/tmp/consul-snapshot-%d.yml", time.Now().UnixNano())
+	defer os.Remove(snapshot)
+
+	url := fmt.Sprintf("consul://%s/tiki/upstream?snapshot=%s", strings.TrimPrefix(srv.URL, "http://"), snapshot)
+	p, err := NewConsulSettingProviderFromURL(url)
+	if err != nil {
+		t.Fatalf("fail to create provider: %v", err)
+	}
+	defer p.Stop()
+
+	s, _ := p.GetSettings("test.srv")
+	if s["GET-/hello"].CBConfig.Timeout != 100 {
+		t.Fatalf("wrong settings: %v", s)
+	}
+
+	changed := make(chan EndpointSetting, 1)
+	p.SetHandler(func(target string, setting EndpointSetting) error {
+		if target == "test.srv" {
+			changed <- setting
+		}
+		return nil
+	})
+	p.Watch()
+
+	kv.put("tiki/upstream/test.srv", consulSetting(2000))
+	select {
+	case setting := <-changed:
+		if setting.CBConfig.Timeout != 2000 {
+			t.Errorf("wrong changed setting: %v", setting)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler not called on change")
+	}
+
+	// snapshot is saved after update, wait for it
+	time.Sleep(50 * time.Millisecond)
+	fallback, err := loadSnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("fail to load snapshot: %v", err)
+	}
+	if fallback["test.srv"]["GET-/hello"].CBConfig.Timeout != 2000 {
+		t.Errorf("wrong snapshot: %v", fallback)
+	}
+}
+
+func TestConsulSettingSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	cfg := consul.DefaultConfig()
+	cfg.Address = addr
+	if _, err := NewConsulSettingProvider(cfg, "tiki/upstream", ""); err == nil {
+		t.Fatal("should fail without consul and snapshot")
+	}
+
+	snapshot := fmt.Sprintf("/tmp/consul-snapshot-%d.yml", time.Now().UnixNano())
+	defer os.Remove(snapshot)
+	str := `
+settings:
+ test.srv:
+  - uri: /hello
+    method: GET
+    hystrix:
+     timeout: 300
+`
+	if err := ioutil.WriteFile(snapshot, []byte(str), 0644); err != nil {
+		t.Fatalf("fail to write snapshot: %v", err)
+	}
+
+	p, err := NewConsulSettingProvider(cfg, "tiki/upstream", snapshot)
+	if err != nil {
+		t.Fatalf("should fallback to snapshot: %v", err)
+	}
+	s, _ := p.GetSettings("test.srv")
+	if s["GET-/hello"].CBConfig.Timeout != 300 {
+		t.Errorf("wrong settings from snapshot: %v", s)
+	}
+}
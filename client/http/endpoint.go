@@ -3,14 +3,13 @@ package http
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"net"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,6 +22,7 @@ import (
 	"github.com/butters-mars/tiki/client/http/lb"
 	"github.com/butters-mars/tiki/client/http/middleware"
 	"github.com/butters-mars/tiki/client/sd/endpointer"
+	"github.com/butters-mars/tiki/logging"
 )
 
 // endpointClient represents client for a certain (http://host/uri - METHOD) which contains several
@@ -40,33 +40,59 @@ type endpointClient struct {
 
 	endpointMap map[string]endpoint.Endpoint
 	mutext      *sync.RWMutex
+
+	cmdName      string
+	retrier      *retrier
+	status       *statusPolicy
+	outlier      *outlierDetector
+	fallback     Fallback // from setting
+	custom       Fallback // set by code, takes precedence
+	settingMutex *sync.RWMutex
 }
 
 type requestBuilder func(addr string, uri string, method string, body []byte) (*http.Request, error)
 
-// Retry retry policies
-type Retry struct {
-}
-
 // EndpointSetting hystrix & retry settings for endpoint
 type EndpointSetting struct {
 	URI      string                `yaml:"uri"`
 	Method   string                `yaml:"method"`
 	CBConfig hystrix.CommandConfig `yaml:"hystrix"`
-	//lbType   string
-	//retry    *Retry
+	Retry    *Retry                `yaml:"retry"`
+	Status   *StatusPolicy         `yaml:"status"`
+	Scheme   string                `yaml:"scheme"` // http or https, default http unless the instance is tagged https
+	TLS      *TLSSetting           `yaml:"tls"`
+	LBType   string                `yaml:"lb"`          // random, round_robin, least_outstanding, p2c_ewma or consistent_hash
+	HashKey  string                `yaml:"hash_header"` // request header as key of consistent_hash, default lb.HashKey of ctx
+	Outlier  *OutlierDetection     `yaml:"outlier"`
+	Fallback *FallbackSetting      `yaml:"fallback"`
+	Hedging  *Hedging              `yaml:"hedging"` // grpc only, takes precedence over retry
 }
 
 func newEndpointClient(host string, setting *EndpointSetting, sdType endpointer.SDType) (ep *endpointClient, err error) {
+	balancer, err := lb.New(setting.LBType)
+	if err != nil {
+		return
+	}
+	fallback, err := newFallback(setting.Fallback)
+	if err != nil {
+		return
+	}
+
 	ep = &endpointClient{
 		host:        host,
 		uri:         setting.URI,
 		method:      setting.Method,
 		setting:     setting,
 		endpointMap: make(map[string]endpoint.Endpoint),
-		lb:          lb.NewRandomLoadBalancer(),
+		lb:          balancer,
 		sdType:      sdType,
 		mutext:      &sync.RWMutex{},
+		retrier:     newRetrier(setting.Retry),
+		status:      newStatusPolicy(setting.Status),
+		outlier:     newOutlierDetector(setting.Outlier, host, setting.URI, setting.Method),
+		fallback:    fallback,
+
+		settingMutex: &sync.RWMutex{},
 	}
 
 	err = ep.init()
@@ -77,23 +103,28 @@ func (client *endpointClient) init() (err error) {
 	// http client
 	timeout := time.Duration(client.setting.CBConfig.Timeout) * time.Millisecond
 	maxConcurrentRequests := client.setting.CBConfig.MaxConcurrentRequests
-	client.httpClient = client.createHTTPClient(timeout, maxConcurrentRequests)
+	client.httpClient, err = client.createHTTPClient(timeout, maxConcurrentRequests, client.setting.TLS)
+	if err != nil {
+		return
+	}
 
 	// middleware
 	source = normal(source)
 	host := normal(client.host)
 	uri := normal(client.uri)
-	cmdName := fmt.Sprintf("%s-%s-%s-%s", source, host, uri, client.method)
-	circuitbreaker := middleware.CircuitBreaker(cmdName, client.setting.CBConfig)
+	client.cmdName = fmt.Sprintf("%s-%s-%s-%s", source, host, uri, client.method)
+	circuitbreaker := middleware.CircuitBreaker(client.cmdName, client.setting.CBConfig, client.hystrixFallback)
 	metrics := middleware.Metrics(source, host, uri, client.method)
 	tracing := middleware.Tracing(client.uri)
-	middleware := endpoint.Chain(circuitbreaker, metrics, tracing, middleware.Cleanup())
+	middleware := endpoint.Chain(circuitbreaker, metrics, tracing, middleware.Propagation(), middleware.Cleanup())
 
 	// sd resolver
-	factory := client.createEndpointFactory(client.httpClient, middleware)
+	factory := client.createEndpointFactory(middleware)
 	var epr endpointer.WithTag
 	if client.sdType == endpointer.SDTypeConsul {
 		epr, err = endpointer.NewConsulEndpointer(serviceDiscoveryCfg, factory, client.host, nil, true)
+	} else if client.sdType == endpointer.SDTypeFile {
+		epr, err = endpointer.NewFileEndpointer(serviceDiscoveryCfg["path"], factory, client.host)
 	} else if client.sdType == endpointer.SDTypeNone {
 		epr, err = endpointer.NewDirectEndpointer(client.host, factory)
 	} else {
@@ -107,77 +138,337 @@ func (client *endpointClient) init() (err error) {
 	return
 }
 
+// update applies new setting, http client is swapped so in-flight requests finish on the old one
+func (client *endpointClient) update(setting *EndpointSetting) (err error) {
+	timeout := time.Duration(setting.CBConfig.Timeout) * time.Millisecond
+	httpClient, err := client.createHTTPClient(timeout, setting.CBConfig.MaxConcurrentRequests, setting.TLS)
+	if err != nil {
+		logger.Errorf("[EP] fail to update setting of %s%s-%s, keep current: %v", client.host, client.uri, client.method, err)
+		return
+	}
+
+	// keep the balancer and its stats if type unchanged
+	balancer := client.getLB()
+	if setting.LBType != client.getSetting().LBType {
+		if balancer, err = lb.New(setting.LBType); err != nil {
+			logger.Errorf("[EP] fail to update setting of %s%s-%s, keep current: %v", client.host, client.uri, client.method, err)
+			return
+		}
+	}
+
+	// keep the fallback and its stale cache if unchanged
+	client.settingMutex.RLock()
+	fb := client.fallback
+	client.settingMutex.RUnlock()
+	if !reflect.DeepEqual(setting.Fallback, client.getSetting().Fallback) {
+		if fb, err = newFallback(setting.Fallback); err != nil {
+			logger.Errorf("[EP] fail to update setting of %s%s-%s, keep current: %v", client.host, client.uri, client.method, err)
+			return
+		}
+	}
+
+	client.settingMutex.Lock()
+	old := client.httpClient
+	client.fallback = fb
+	client.lb = balancer
+	client.setting = setting
+	client.httpClient = httpClient
+	client.retrier = newRetrier(setting.Retry)
+	client.status = newStatusPolicy(setting.Status)
+	client.settingMutex.Unlock()
+
+	client.outlier.setPolicy(setting.Outlier)
+
+	middleware.UpdateCommandConfig(client.cmdName, setting.CBConfig)
+
+	// close idle connections only, active ones are closed when done
+	if transport, ok := old.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+
+	logger.Infof("[EP] setting of %s%s-%s updated: %+v", client.host, client.uri, client.method, setting)
+	return
+}
+
+// close stops service discovery and closes idle connections of the endpoint client
+func (client *endpointClient) close() {
+	if client.taggedEPR != nil {
+		client.taggedEPR.Stop()
+	}
+	if httpClient := client.getHTTPClient(); httpClient != nil {
+		if transport, ok := httpClient.Transport.(*http.Transport); ok {
+			transport.CloseIdleConnections()
+		}
+	}
+}
+
+func (client *endpointClient) getHTTPClient() *http.Client {
+	client.settingMutex.RLock()
+	defer client.settingMutex.RUnlock()
+	return client.httpClient
+}
+
+func (client *endpointClient) getRetrier() *retrier {
+	client.settingMutex.RLock()
+	defer client.settingMutex.RUnlock()
+	return client.retrier
+}
+
+// scheme returns scheme of the instance, https if set by setting or the instance is tagged https
+func (client *endpointClient) scheme(addr string) string {
+	client.settingMutex.RLock()
+	scheme := client.setting.Scheme
+	client.settingMutex.RUnlock()
+	if scheme != "" {
+		return scheme
+	}
+
+	for _, tag := range client.getTagMap()[addr] {
+		if tag == TagHTTPS {
+			return schemeHTTPS
+		}
+	}
+	return schemeHTTP
+}
+
+// setFallback sets fallback by code, nil to use the one from setting
+func (client *endpointClient) setFallback(fallback Fallback) {
+	client.settingMutex.Lock()
+	defer client.settingMutex.Unlock()
+	client.custom = fallback
+}
+
+func (client *endpointClient) getFallback() Fallback {
+	client.settingMutex.RLock()
+	defer client.settingMutex.RUnlock()
+	if client.custom != nil {
+		return client.custom
+	}
+	return client.fallback
+}
+
+// hystrixFallback adapts current fallback to hystrix, response of fallback is marked
+func (client *endpointClient) hystrixFallback() middleware.Fallback {
+	fallback := client.getFallback()
+	if fallback == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, request interface{}, err error) (interface{}, error) {
+		req, _ := request.(*http.Request)
+		resp, ferr := fallback.Fallback(ctx, req, err)
+		if ferr != nil {
+			return nil, ferr
+		}
+		if resp == nil {
+			return nil, fmt.Errorf("nil fallback response")
+		}
+
+		logger.Warnf("[Fallback] %s%s-%s served by fallback: %v", client.host, client.uri, client.method, err)
+		return []interface{}{resp.Body, resp.StatusCode, resp.Header, true}, nil
+	}
+}
+
+func (client *endpointClient) getLB() lb.LoadBalancer {
+	client.settingMutex.RLock()
+	defer client.settingMutex.RUnlock()
+	return client.lb
+}
+
+func (client *endpointClient) getSetting() *EndpointSetting {
+	client.settingMutex.RLock()
+	defer client.settingMutex.RUnlock()
+	return client.setting
+}
+
+func (client *endpointClient) getStatusPolicy() *statusPolicy {
+	client.settingMutex.RLock()
+	defer client.settingMutex.RUnlock()
+	return client.status
+}
+
 func normal(str string) string {
 	return strings.Replace(str, "-", "_", -1)
 }
 
-func (client *endpointClient) DoRaw(ctx context.Context, uri, method string, param interface{}) (resp []byte, code int, err error) {
+func (client *endpointClient) DoRaw(ctx context.Context, uri, method string, param interface{}, opts ...RequestOption) (resp []byte, code int, err error) {
+	r, err := client.Request(ctx, uri, method, param, opts...)
+	if r != nil {
+		resp, code = r.Body, r.StatusCode
+	}
+	return
+}
+
+// Request calls the endpoint with options, and returns the whole response
+func (client *endpointClient) Request(ctx context.Context, uri, method string, param interface{}, opts ...RequestOption) (resp *Response, err error) {
 	body := []byte("")
+	options := newRequestOptions(opts)
 
 	// check if param is already []byte
+	jsonBody := false
 	if bs, ok := param.([]byte); ok {
 		body = bs
 	} else if param != nil {
 		var bs []byte
 		bs, err = json.Marshal(param)
 		if err != nil {
-			logger.Error("json Marshal err: %v, param: %v", err, param)
+			logging.FromContext(ctx).Errorf("json Marshal err: %v, param: %v", err, param)
 			return
 		}
 		body = bs
+		jsonBody = true
+	}
+
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
 	}
 
-	_endpoint, addr, err := client.resolveHost(uri, method)
+	call := &call{
+		uri:      uri,
+		method:   method,
+		body:     body,
+		jsonBody: jsonBody,
+		options:  options,
+	}
+
+	retrier := client.getRetrier()
+	if retrier == nil {
+		resp, _, err = client.doOnce(ctx, call, nil, nil)
+		return
+	}
+
+	// retry on another instance if possible
+	retrier.budget.request()
+	tried := make(map[string]bool)
+	maxAttempts := retrier.policy.MaxAttempts
+	for attempt := 1; ; attempt++ {
+		var addr string
+		resp, addr, err = client.doOnce(ctx, call, tried, retrier)
+		tried[addr] = true
+
+		code := 0
+		if resp != nil {
+			code = resp.StatusCode
+		}
+		reason := retrier.retryable(method, code, err)
+		if reason == "" || attempt >= maxAttempts || ctx.Err() != nil {
+			return
+		}
+		if !retrier.budget.acquire() {
+			logging.FromContext(ctx).Warnf("[Retry] budget of %s%s-%s exhausted", client.host, uri, method)
+			return
+		}
+		if !retrier.backoff(ctx, attempt) {
+			return
+		}
+
+		logging.FromContext(ctx).Infof("[Retry] %s%s-%s attempt %d failed on %s (%s), retrying", client.host, uri, method, attempt, addr, reason)
+		middleware.RecordRetry(normal(source), normal(client.host), normal(uri), method, reason)
+	}
+}
+
+// call is a request to be sent, possibly more than once
+type call struct {
+	uri      string
+	method   string
+	body     []byte
+	jsonBody bool
+	options  *requestOptions
+}
+
+// doOnce calls an instance other than tried ones if possible, returns the address called
+func (client *endpointClient) doOnce(ctx context.Context, c *call, tried map[string]bool, retrier *retrier) (resp *Response, addr string, err error) {
+	balancer := client.getLB()
+	_endpoint, addr, err := client.resolveHost(ctx, balancer, c, tried)
 	if err != nil {
-		logger.Error("resolve host [%s] err: %v", client.host, err)
+		logging.FromContext(ctx).Errorf("resolve host [%s] err: %v", client.host, err)
 		return
 	}
 
-	url := fmt.Sprintf("http://%s%s", addr, uri)
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	defer func() {
+		// calls canceled by the caller say nothing about the instance
+		failed := ctx.Err() != context.Canceled &&
+			(isHostFailure(err, client.getStatusPolicy()) || (resp != nil && resp.Fallback))
+		client.outlier.end(addr, failed, client.instanceCount())
+	}()
+
+	if tracker, ok := balancer.(lb.Tracker); ok {
+		tracker.Begin(addr)
+		defer func(start time.Time) {
+			tracker.End(addr, time.Since(start), err)
+		}(time.Now())
+	}
+
+	ctx, cancel := retrier.tryContext(ctx)
+	defer cancel()
+
+	url := c.options.buildURL(client.scheme(addr), addr, c.uri)
+	req, err := http.NewRequest(c.method, url, bytes.NewBuffer(c.body))
 	if err != nil {
-		logger.Error("fail to build request for %s[%s], err: %v", url, string(body), err)
+		logging.FromContext(ctx).Errorf("fail to build request for %s[%s], err: %v", url, string(c.body), err)
 		return
 	}
+	req = req.WithContext(ctx)
+	c.options.apply(req, c.jsonBody)
 
 	response, err := _endpoint(ctx, req)
 	if err != nil {
-		logger.Error("fail to call %s[%s], err: %v", url, string(body), err)
+		// failure status counted by hystrix, keep the response
+		if httpErr, ok := err.(*HTTPError); ok {
+			resp = httpErr.resp
+		}
+		logging.FromContext(ctx).Errorf("fail to call %s[%s], err: %v", url, string(c.body), err)
 		return
 	}
 
 	arr, ok := response.([]interface{})
 	if !ok {
-		logger.Error("resp is not array of interface")
+		logging.FromContext(ctx).Error("resp is not array of interface")
 		err = fmt.Errorf("resp not []interface{}")
 		return
 	}
 
-	resp, ok = arr[0].([]byte)
+	resp = &Response{Addr: addr}
+	resp.Body, ok = arr[0].([]byte)
 	if !ok {
-		logger.Error("arr[0] not []byte")
+		logging.FromContext(ctx).Error("arr[0] not []byte")
 		err = fmt.Errorf("arr[0] not []byte")
 		return
 	}
-	code, ok = arr[1].(int)
+	resp.StatusCode, ok = arr[1].(int)
 	if !ok {
-		logger.Error("arr[1] not int")
+		logging.FromContext(ctx).Error("arr[1] not int")
 		err = fmt.Errorf("arr[1] not int")
 		return
 	}
+	resp.Header, _ = arr[2].(http.Header)
+	if len(arr) > 3 {
+		resp.Fallback, _ = arr[3].(bool)
+	}
+
+	if !client.getStatusPolicy().success.match(resp.StatusCode) {
+		err = newHTTPError(resp, c.uri, c.method)
+		return
+	}
+
+	if recorder, ok := client.getFallback().(fallbackRecorder); ok && !resp.Fallback {
+		recorder.record(ctx, req, resp)
+	}
 
 	return
 }
 
-func (client *endpointClient) Do(ctx context.Context, uri, method string, param interface{}, resp interface{}) (err error) {
-	contentBytes, _, err := client.DoRaw(ctx, uri, method, param)
+func (client *endpointClient) Do(ctx context.Context, uri, method string, param interface{}, resp interface{}, opts ...RequestOption) (err error) {
+	contentBytes, _, err := client.DoRaw(ctx, uri, method, param, opts...)
 	if err != nil {
 		return
 	}
 
 	err = json.Unmarshal(contentBytes, resp)
 	if err != nil {
-		logger.Error("fail to parse response body of %s [%s]: %v", uri, string(contentBytes), err)
+		logging.FromContext(ctx).Errorf("fail to parse response body of %s [%s]: %v", uri, string(contentBytes), err)
 		return
 	}
 
@@ -188,44 +479,77 @@ func (client *endpointClient) getTagMap() map[string][]string {
 	return client.taggedEPR.GetTagMap()
 }
 
-func (client *endpointClient) resolveHost(uri, method string) (ep endpoint.Endpoint, addr string, err error) {
+// resolveHost selects an endpoint, tried addresses are skipped unless there's no other choice
+func (client *endpointClient) resolveHost(ctx context.Context, balancer lb.LoadBalancer, c *call, tried map[string]bool) (ep endpoint.Endpoint, addr string, err error) {
+	uri, method := c.uri, c.method
+
 	client.mutext.RLock()
 	defer client.mutext.RUnlock()
 
-	// filter out all circuit-open endpoints
-	endpoints := make(map[string]endpoint.Endpoint)
-	for addr, ep := range client.endpointMap {
-		cbKey := fmt.Sprintf("%s-%s-%s", addr, uri, method)
-		if cbOpen, ok := middleware.IsCircuitOpen(cbKey); ok && cbOpen {
-			// give 10% chance to let go of circuit-opened endpoint
-			if rand.Intn(10) != 1 {
-				logger.Warn("[EP] circuit %s open=true, ignore", cbKey)
-				continue
-			} else {
-				logger.Info("[EP] circuit %s open=true, let go of it", cbKey)
+	candidates := client.endpointMap
+	if len(tried) > 0 {
+		untried := make(map[string]endpoint.Endpoint)
+		for addr, ep := range client.endpointMap {
+			if !tried[addr] {
+				untried[addr] = ep
+			}
+		}
+		if len(untried) > 0 {
+			candidates = untried
+		}
+	}
+
+	// filter out ejected endpoints, an ejected one is let go as a probe when its ejection time passes
+	endpoints := client.outlier.filter(candidates)
+
+	selector, isContextSelector := balancer.(lb.ContextSelector)
+	if isContextSelector {
+		if header := client.getSetting().HashKey; header != "" {
+			if key := c.options.header.Get(header); key != "" {
+				ctx = lb.WithHashKey(ctx, key)
 			}
 		}
+	}
+
+	for {
+		if isContextSelector {
+			ep, addr, err = selector.SelectContext(ctx, uri, method, endpoints, client.getTagMap())
+		} else {
+			ep, addr, err = balancer.Select(uri, method, endpoints, client.getTagMap())
+		}
+		if err != nil || client.outlier.begin(addr) {
+			return
+		}
 
-		endpoints[addr] = ep
+		// probe of the instance taken by another request
+		delete(endpoints, addr)
 	}
+}
 
-	return client.lb.Select(uri, method, endpoints, client.getTagMap())
+func (client *endpointClient) instanceCount() int {
+	client.mutext.RLock()
+	defer client.mutext.RUnlock()
+	return len(client.endpointMap)
 }
 
-func (client *endpointClient) createHTTPClient(timeout time.Duration, maxConcurrentRequests int) *http.Client {
-	tlsCfg := &tls.Config{
-		InsecureSkipVerify: true,
+func (client *endpointClient) createHTTPClient(timeout time.Duration, maxConcurrentRequests int, tlsSetting *TLSSetting) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   timeout / 2,
+		KeepAlive: 3600 * time.Second,
+	}
+
+	dialTLS, err := newTLSDialer(dialer, client.host, tlsSetting)
+	if err != nil {
+		logger.Errorf("[EP] fail to create tls config of %s%s-%s: %v", client.host, client.uri, client.method, err)
+		return nil, err
 	}
 
 	transport := &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout:   timeout / 2,
-			KeepAlive: 3600 * time.Second,
-		}).Dial,
+		Dial:                dialer.Dial,
+		DialTLS:             dialTLS,
 		MaxIdleConnsPerHost: maxConcurrentRequests,
 		MaxIdleConns:        maxConcurrentRequests,
 		TLSHandshakeTimeout: timeout,
-		TLSClientConfig:     tlsCfg,
 	}
 
 	c := &http.Client{
@@ -233,20 +557,20 @@ func (client *endpointClient) createHTTPClient(timeout time.Duration, maxConcurr
 		Timeout:   timeout,
 	}
 
-	return c
+	return c, nil
 }
 
-func (client *endpointClient) createEndpoint(httpClient *http.Client) endpoint.Endpoint {
+func (client *endpointClient) createEndpoint() endpoint.Endpoint {
 	ep := func(ctx context.Context, req interface{}) (resp interface{}, err error) {
 		httpReq, _ := req.(*http.Request)
 
-		response, err := httpClient.Do(httpReq)
+		response, err := client.getHTTPClient().Do(httpReq)
 		if err != nil {
 			return
 		}
 
 		if response.Body == nil {
-			logger.Error("resp body is empty for %s", httpReq.URL.String())
+			logger.Errorf("resp body is empty for %s", httpReq.URL.String())
 			err = fmt.Errorf("resp body empty")
 			return
 		}
@@ -254,10 +578,21 @@ func (client *endpointClient) createEndpoint(httpClient *http.Client) endpoint.E
 
 		content, err := ioutil.ReadAll(response.Body)
 		if err != nil {
-			logger.Error("Failed to read response body of %s: %v", httpReq.URL.String(), err)
+			logger.Errorf("Failed to read response body of %s: %v", httpReq.URL.String(), err)
 			return
 		}
 
+		// failure status is returned as error so that hystrix counts it
+		if client.getStatusPolicy().failure.match(response.StatusCode) {
+			resp := &Response{
+				StatusCode: response.StatusCode,
+				Header:     response.Header,
+				Body:       content,
+				Addr:       httpReq.URL.Host,
+			}
+			return nil, newHTTPError(resp, client.uri, client.method)
+		}
+
 		return []interface{}{content, response.StatusCode, response.Header}, err
 	}
 
@@ -271,22 +606,22 @@ type fakeCloser struct {
 }
 
 func (c fakeCloser) Close() error {
-	logger.Info("[EPFactory] close endpoint %s", c.addr)
+	logger.Infof("[EPFactory] close endpoint %s", c.addr)
 	middleware.CleanupEndpoint(c.action)
 	c.onClose()
 	return nil
 }
 
-func (client *endpointClient) createEndpointFactory(httpClient *http.Client, middleware endpoint.Middleware) sd.Factory {
+func (client *endpointClient) createEndpointFactory(middleware endpoint.Middleware) sd.Factory {
 	return func(addr string) (endpoint.Endpoint, io.Closer, error) {
 		client.mutext.Lock()
 		defer client.mutext.Unlock()
 
-		ep := client.createEndpoint(httpClient)
+		ep := client.createEndpoint()
 		ep = middleware(ep)
 
 		client.endpointMap[addr] = ep
-		logger.Info("[EPFactory] create endpoint %s%s(%s), list=%s", client.host, client.uri, addr, client.debugEndpointMap())
+		logger.Infof("[EPFactory] create endpoint %s%s(%s), list=%s", client.host, client.uri, addr, client.debugEndpointMap())
 
 		key := fmt.Sprintf("%s-%s-%s", addr, client.uri, client.method)
 		closer := fakeCloser{
@@ -297,7 +632,8 @@ func (client *endpointClient) createEndpointFactory(httpClient *http.Client, mid
 				defer client.mutext.Unlock()
 
 				delete(client.endpointMap, addr)
-				logger.Info("[EPFactory] delete endpoint %s%s(%s), list=%s", client.host, client.uri, addr, client.debugEndpointMap())
+				client.outlier.forget(addr)
+				logger.Infof("[EPFactory] delete endpoint %s%s(%s), list=%s", client.host, client.uri, addr, client.debugEndpointMap())
 			},
 		}
 
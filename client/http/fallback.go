@@ -0,0 +1,216 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/butters-mars/tiki/propagation"
+)
+
+const (
+	// FallbackStatic serves a static response
+	FallbackStatic = "static"
+	// FallbackStale serves the last good response of the same request
+	FallbackStale = "stale"
+
+	defaultStaleTTL        = 60000 // ms
+	defaultStaleMaxEntries = 1000
+)
+
+// errNoStale is returned by stale fallback if there's no good response cached
+var errNoStale = fmt.Errorf("no stale response")
+
+// staleIdentityHeaders identify the caller, stale responses are only served to the same one
+var staleIdentityHeaders = []string{"Authorization", "Cookie", propagation.KeyUID, propagation.KeyTenant}
+
+// Fallback provides response of a failed call, i.e. short-circuited, timed out, rejected
+// or of failure status. Returning an error means no fallback, and the original error is
+// returned to the caller.
+type Fallback interface {
+	Fallback(ctx context.Context, req *http.Request, err error) (*Response, error)
+}
+
+// FallbackFunc adapts a function to Fallback
+type FallbackFunc func(ctx context.Context, req *http.Request, err error) (*Response, error)
+
+// Fallback implements Fallback
+func (f FallbackFunc) Fallback(ctx context.Context, req *http.Request, err error) (*Response, error) {
+	return f(ctx, req, err)
+}
+
+// fallbackRecorder is implemented by fallbacks recording good responses
+type fallbackRecorder interface {
+	record(ctx context.Context, req *http.Request, resp *Response)
+}
+
+// FallbackSetting fallback of endpoint in yaml, fallback set by DefaultClient.SetFallback takes precedence
+type FallbackSetting struct {
+	Type       string            `yaml:"type"`        // static or stale
+	Status     int               `yaml:"status"`      // status of static response, default 200
+	Body       string            `yaml:"body"`        // body of static response
+	Headers    map[string]string `yaml:"headers"`     // headers of static response
+	TTL        int               `yaml:"ttl"`         // ms a good response is served as stale one, default 60000
+	MaxEntries int               `yaml:"max_entries"` // max requests cached for stale response, default 1000
+}
+
+func newFallback(setting *FallbackSetting) (Fallback, error) {
+	if setting == nil {
+		return nil, nil
+	}
+
+	switch setting.Type {
+	case FallbackStatic:
+		resp := &Response{
+			StatusCode: setting.Status,
+			Header:     make(http.Header),
+			Body:       []byte(setting.Body),
+		}
+		if resp.StatusCode == 0 {
+			resp.StatusCode = http.StatusOK
+		}
+		for key, value := range setting.Headers {
+			resp.Header.Set(key, value)
+		}
+		return StaticFallback(resp), nil
+	case FallbackStale:
+		return NewStaleFallback(time.Duration(setting.TTL)*time.Millisecond, setting.MaxEntries), nil
+	}
+
+	return nil, fmt.Errorf("unknown fallback type %s", setting.Type)
+}
+
+// StaticFallback returns a fallback serving the given response
+func StaticFallback(resp *Response) Fallback {
+	return FallbackFunc(func(ctx context.Context, req *http.Request, err error) (*Response, error) {
+		return copyResponse(resp), nil
+	})
+}
+
+type staleEntry struct {
+	resp *Response
+	at   time.Time
+}
+
+type staleFallback struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mutex   *sync.RWMutex
+	entries map[string]staleEntry
+}
+
+// NewStaleFallback returns a fallback serving the last good response of the same request
+// (method, uri with query, body and identity headers of the caller) within ttl
+func NewStaleFallback(ttl time.Duration, maxEntries int) Fallback {
+	if ttl <= 0 {
+		ttl = defaultStaleTTL * time.Millisecond
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultStaleMaxEntries
+	}
+
+	return &staleFallback{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		mutex:      &sync.RWMutex{},
+		entries:    make(map[string]staleEntry),
+	}
+}
+
+// staleKey returns digest of the request, its body and identity headers, including ones
+// propagated from the context, which are not injected yet if the call is short-circuited.
+// Requests with a body which can't be read again are not cached
+func staleKey(ctx context.Context, req *http.Request) (string, bool) {
+	header := make(http.Header)
+	for _, k := range staleIdentityHeaders {
+		if vals := req.Header[http.CanonicalHeaderKey(k)]; len(vals) > 0 {
+			header[http.CanonicalHeaderKey(k)] = vals
+		}
+	}
+	propagation.InjectHeader(ctx, header)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s", req.Method, req.URL.RequestURI())
+	for _, k := range staleIdentityHeaders {
+		fmt.Fprintf(h, "\n%s: %s", k, strings.Join(header[http.CanonicalHeaderKey(k)], ","))
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", false
+		}
+		defer body.Close()
+		h.Write([]byte("\n\n"))
+		if _, err = io.Copy(h, body); err != nil {
+			return "", false
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// copyResponse returns a copy of the response not sharing header and body
+func copyResponse(resp *Response) *Response {
+	r := *resp
+	r.Header = make(http.Header, len(resp.Header))
+	for k, vals := range resp.Header {
+		r.Header[k] = append([]string{}, vals...)
+	}
+	r.Body = append([]byte{}, resp.Body...)
+	return &r
+}
+
+// Fallback implements Fallback
+func (f *staleFallback) Fallback(ctx context.Context, req *http.Request, err error) (*Response, error) {
+	key, ok := staleKey(ctx, req)
+	if !ok {
+		return nil, errNoStale
+	}
+
+	f.mutex.RLock()
+	entry, ok := f.entries[key]
+	f.mutex.RUnlock()
+
+	if !ok || time.Since(entry.at) > f.ttl {
+		return nil, errNoStale
+	}
+	return copyResponse(entry.resp), nil
+}
+
+func (f *staleFallback) record(ctx context.Context, req *http.Request, resp *Response) {
+	key, ok := staleKey(ctx, req)
+	if !ok {
+		return
+	}
+	resp = copyResponse(resp)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.entries[key]; !ok && len(f.entries) >= f.maxEntries {
+		// evict expired entries, or an arbitrary one if none
+		now := time.Now()
+		for k, entry := range f.entries {
+			if now.Sub(entry.at) > f.ttl {
+				delete(f.entries, k)
+			}
+		}
+		for k := range f.entries {
+			if len(f.entries) < f.maxEntries {
+				break
+			}
+			delete(f.entries, k)
+		}
+	}
+	f.entries[key] = staleEntry{resp: resp, at: time.Now()}
+}
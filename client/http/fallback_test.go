@@ -0,0 +1,212 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/butters-mars/tiki/common"
+)
+
+func TestStaticFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	cl.SetEndpointSetting(&EndpointSetting{
+		URI:    "/static",
+		Method: "GET",
+		Fallback: &FallbackSetting{
+			Type:    FallbackStatic,
+			Body:    `{"items":[]}`,
+			Headers: map[string]string{"X-Fallback": "1"},
+		},
+	})
+
+	resp, err := cl.Request(context.TODO(), "/static", "GET", nil)
+	if err != nil {
+		t.Fatalf("should be served by fallback: %v", err)
+	}
+	if !resp.Fallback || resp.StatusCode != http.StatusOK || string(resp.Body) != `{"items":[]}` ||
+		resp.Header.Get("X-Fallback") != "1" {
+		t.Errorf("wrong fallback response: %+v", resp)
+	}
+}
+
+func TestStaleFallback(t *testing.T) {
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(req.URL.Query().Get("id")))
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	cl.SetEndpointSetting(&EndpointSetting{
+		URI:      "/stale",
+		Method:   "GET",
+		Fallback: &FallbackSetting{Type: FallbackStale},
+	})
+
+	if resp, err := cl.Request(context.TODO(), "/stale", "GET", nil, WithQuery("id", "1")); err != nil || resp.Fallback {
+		t.Fatalf("should succeed: %v %+v", err, resp)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	resp, err := cl.Request(context.TODO(), "/stale", "GET", nil, WithQuery("id", "1"))
+	if err != nil || !resp.Fallback || string(resp.Body) != "1" {
+		t.Errorf("should be served by stale response: %v %+v", err, resp)
+	}
+
+	_, err = cl.Request(context.TODO(), "/stale", "GET", nil, WithQuery("id", "2"))
+	if httpErr, ok := err.(*HTTPError); !ok || httpErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("original error should be returned without stale response: %v", err)
+	}
+}
+
+func TestFallbackFunc(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	cl.SetEndpointSetting(&EndpointSetting{
+		URI:      "/func",
+		Method:   "GET",
+		Fallback: &FallbackSetting{Type: FallbackStatic, Body: "static"},
+	})
+
+	var cause error
+	cl.SetFallback("/func", "GET", FallbackFunc(func(ctx context.Context, req *http.Request, err error) (*Response, error) {
+		cause = err
+		if req.URL.Query().Get("fail") != "" {
+			return nil, errors.New("no fallback")
+		}
+		return &Response{StatusCode: http.StatusAccepted, Body: []byte("func")}, nil
+	}))
+
+	resp, err := cl.Request(context.TODO(), "/func", "GET", nil)
+	if err != nil || string(resp.Body) != "func" || resp.StatusCode != http.StatusAccepted {
+		t.Errorf("fallback set by code should take precedence: %v %+v", err, resp)
+	}
+	if httpErr, ok := cause.(*HTTPError); !ok || httpErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("fallback should get the cause: %v", cause)
+	}
+
+	_, err = cl.Request(context.TODO(), "/func", "GET", nil, WithQuery("fail", "1"))
+	if httpErr, ok := err.(*HTTPError); !ok || httpErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("original error should be returned if fallback fails: %v", err)
+	}
+
+	cl.SetFallback("/func", "GET", nil)
+	if resp, err = cl.Request(context.TODO(), "/func", "GET", nil); err != nil || string(resp.Body) != "static" {
+		t.Errorf("should use fallback in setting: %v %+v", err, resp)
+	}
+}
+
+func TestStaleFallbackPerCaller(t *testing.T) {
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(req.Header.Get("Authorization") + "|" + req.Header.Get("X-Uid")))
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	defer cl.Close()
+	cl.SetEndpointSetting(&EndpointSetting{
+		URI:      "/me",
+		Method:   "GET",
+		Fallback: &FallbackSetting{Type: FallbackStale},
+	})
+
+	alice := context.WithValue(context.TODO(), common.KeyUID{}, "alice")
+	bob := context.WithValue(context.TODO(), common.KeyUID{}, "bob")
+	if _, err := cl.Request(alice, "/me", "GET", nil, WithBearerToken("t1")); err != nil {
+		t.Fatalf("should succeed: %v", err)
+	}
+	if _, err := cl.Request(bob, "/me", "GET", nil); err != nil {
+		t.Fatalf("should succeed: %v", err)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	resp, err := cl.Request(alice, "/me", "GET", nil, WithBearerToken("t1"))
+	if err != nil || !resp.Fallback || string(resp.Body) != "Bearer t1|alice" {
+		t.Fatalf("should be served by stale response of the caller: %v %+v", err, resp)
+	}
+	resp.Body[0] = 'X'
+	resp.Header.Set("X-Mutated", "1")
+	if resp, err = cl.Request(alice, "/me", "GET", nil, WithBearerToken("t1")); err != nil ||
+		string(resp.Body) != "Bearer t1|alice" || resp.Header.Get("X-Mutated") != "" {
+		t.Errorf("stale response should be copied: %v %+v", err, resp)
+	}
+
+	for _, c := range []struct {
+		ctx  context.Context
+		opts []RequestOption
+	}{
+		{alice, []RequestOption{WithBearerToken("t2")}},
+		{alice, nil},
+		{context.TODO(), []RequestOption{WithBearerToken("t1")}},
+	} {
+		if resp, err = cl.Request(c.ctx, "/me", "GET", nil, c.opts...); err == nil {
+			t.Errorf("stale response should not be served to other callers: %s", resp.Body)
+		}
+	}
+	if resp, err = cl.Request(bob, "/me", "GET", nil); err != nil || string(resp.Body) != "|bob" {
+		t.Errorf("should be served by stale response of bob: %v %+v", err, resp)
+	}
+}
+
+func TestStaleFallbackPerBody(t *testing.T) {
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	defer cl.Close()
+	cl.SetEndpointSetting(&EndpointSetting{
+		URI:      "/orders",
+		Method:   "POST",
+		Fallback: &FallbackSetting{Type: FallbackStale},
+	})
+
+	if _, err := cl.Request(context.TODO(), "/orders", "POST", map[string]string{"id": "1"}); err != nil {
+		t.Fatalf("should succeed: %v", err)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	resp, err := cl.Request(context.TODO(), "/orders", "POST", map[string]string{"id": "1"})
+	if err != nil || !resp.Fallback || string(resp.Body) != `{"id":"1"}` {
+		t.Errorf("should be served by stale response of the same body: %v %+v", err, resp)
+	}
+	if resp, err = cl.Request(context.TODO(), "/orders", "POST", map[string]string{"id": "2"}); err == nil {
+		t.Errorf("stale response should not be served to other body: %s", resp.Body)
+	}
+}
@@ -0,0 +1,20 @@
+package http
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/butters-mars/tiki/client/http/middleware"
+	"github.com/butters-mars/tiki/healthcheck"
+)
+
+// CircuitCheck returns a healthcheck which fails when all circuits to the given host are open
+func CircuitCheck(host string) healthcheck.CheckFunc {
+	return func(ctx context.Context) error {
+		open, total := middleware.CountCircuits(normal(host))
+		if total > 0 && open == total {
+			return fmt.Errorf("all %d circuits to %s are open", total, host)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package lb
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/butters-mars/tiki/common"
+)
+
+type hashKey struct{}
+
+// WithHashKey returns a context carrying key for consistent hash LB
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKey returns key for consistent hash LB, set by WithHashKey or user id of common.KeyUID
+func HashKey(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if key, ok := ctx.Value(hashKey{}).(string); ok && key != "" {
+		return key
+	}
+	if uid := ctx.Value(common.KeyUID{}); uid != nil {
+		return fmt.Sprint(uid)
+	}
+	return ""
+}
+
+type consistentHashLoadBalancer struct {
+	mutex     *sync.Mutex
+	signature string
+	ring      []uint32
+	owners    map[uint32]string
+	fallback  LoadBalancer
+}
+
+// NewConsistentHashLoadBalancer creates an LB selecting instance on a hash ring by HashKey,
+// each instance has virtual nodes as many as its weight, calls without key are balanced randomly
+func NewConsistentHashLoadBalancer() LoadBalancer {
+	return &consistentHashLoadBalancer{
+		mutex:    &sync.Mutex{},
+		fallback: NewRandomLoadBalancer(),
+	}
+}
+
+func (h *consistentHashLoadBalancer) Select(uri, method string, endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) (endpoint.Endpoint, string, error) {
+	return h.fallback.Select(uri, method, endpoints, tagMap)
+}
+
+// SelectContext implements ContextSelector
+func (h *consistentHashLoadBalancer) SelectContext(ctx context.Context, uri, method string, endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) (endpoint.Endpoint, string, error) {
+	key := HashKey(ctx)
+	if key == "" {
+		return h.Select(uri, method, endpoints, tagMap)
+	}
+
+	addrs := weightedAddrs(endpoints, tagMap)
+	if len(addrs) == 0 {
+		return nil, "", errNoEndpoint(uri, method)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.build(addrs, tagMap)
+	sum := hash(key)
+	idx := sort.Search(len(h.ring), func(i int) bool { return h.ring[i] >= sum })
+	if idx == len(h.ring) {
+		idx = 0
+	}
+
+	addr := h.owners[h.ring[idx]]
+	return endpoints[addr], addr, nil
+}
+
+// build rebuilds the ring if instances or weights change
+func (h *consistentHashLoadBalancer) build(addrs []string, tagMap map[string][]string) {
+	parts := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		parts = append(parts, fmt.Sprintf("%s/%d", addr, weightOf(tagMap[addr])))
+	}
+	signature := strings.Join(parts, ",")
+	if signature == h.signature {
+		return
+	}
+
+	ring := make([]uint32, 0)
+	owners := make(map[uint32]string)
+	for _, addr := range addrs {
+		for i := 0; i < weightOf(tagMap[addr]); i++ {
+			sum := hash(fmt.Sprintf("%s#%d", addr, i))
+			if _, ok := owners[sum]; ok {
+				continue
+			}
+			owners[sum] = addr
+			ring = append(ring, sum)
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i] < ring[j] })
+
+	h.signature, h.ring, h.owners = signature, ring, owners
+}
+
+// hash uses md5 for its even distribution over similar strings of virtual nodes
+func hash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -1,19 +1,80 @@
 package lb
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
 )
 
+const (
+	// TypeRandom weighted random
+	TypeRandom = "random"
+	// TypeRoundRobin smooth weighted round robin
+	TypeRoundRobin = "round_robin"
+	// TypeLeastOutstanding least outstanding requests
+	TypeLeastOutstanding = "least_outstanding"
+	// TypeP2CEWMA power of two choices by EWMA latency
+	TypeP2CEWMA = "p2c_ewma"
+	// TypeConsistentHash consistent hash on key from context, see WithHashKey
+	TypeConsistentHash = "consistent_hash"
+)
+
 // LoadBalancer defines how to select endpoint from a list of endpoints with tag labels
 type LoadBalancer interface {
 	Select(uri, method string, endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) (endpoint.Endpoint, string, error)
 }
 
+// ContextSelector is implemented by load balancers selecting with request context
+type ContextSelector interface {
+	SelectContext(ctx context.Context, uri, method string, endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) (endpoint.Endpoint, string, error)
+}
+
+// Tracker is implemented by load balancers selecting by stats of calls
+type Tracker interface {
+	// Begin is called before calling the instance
+	Begin(addr string)
+	// End is called after the call with its latency and error
+	End(addr string, latency time.Duration, err error)
+}
+
+// New creates a load balancer of the given type, empty type means random
+func New(lbType string) (LoadBalancer, error) {
+	switch lbType {
+	case "", TypeRandom:
+		return NewRandomLoadBalancer(), nil
+	case TypeRoundRobin:
+		return NewRoundRobinLoadBalancer(), nil
+	case TypeLeastOutstanding:
+		return NewLeastOutstandingLoadBalancer(), nil
+	case TypeP2CEWMA:
+		return NewP2CEWMALoadBalancer(), nil
+	case TypeConsistentHash:
+		return NewConsistentHashLoadBalancer(), nil
+	}
+
+	return nil, fmt.Errorf("unknown lb type %s", lbType)
+}
+
+func errNoEndpoint(uri, method string) error {
+	return fmt.Errorf("no endpoint for %s-%s, empty or all nodes dead", method, uri)
+}
+
+// sortedAddrs returns addresses of endpoints in order
+func sortedAddrs(endpoints map[string]endpoint.Endpoint) []string {
+	addrs := make([]string, 0, len(endpoints))
+	for addr := range endpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
 type randomLoadBalancer struct {
 }
 
@@ -26,6 +87,31 @@ func NewRandomLoadBalancer() LoadBalancer {
 var count = 0
 var stg = 0
 
+// TagWeight returns weight of an instance by its tags: 1 for "stg", N for "weight_N"
+// (capped to 0 - 100), and 100 by default
+func TagWeight(tags []string) int {
+	weight := 100
+	for _, tag := range tags {
+		if tag == "stg" {
+			weight = 1
+		} else if strings.Index(tag, "weight_") == 0 {
+			arr := strings.Split(tag, "_")
+			if len(arr) == 2 {
+				wStr := arr[1]
+				if w, err := strconv.Atoi(wStr); err == nil {
+					if w < 0 {
+						w = 0
+					} else if w > 100 {
+						w = 100
+					}
+					weight = w
+				}
+			}
+		}
+	}
+	return weight
+}
+
 func (r randomLoadBalancer) Select(uri, method string, endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) (endpoint.Endpoint, string, error) {
 	keys := make([]string, 0)
 	steps := make([]int, 0)
@@ -35,34 +121,13 @@ func (r randomLoadBalancer) Select(uri, method string, endpoints map[string]endp
 	for addr := range endpoints {
 		keys = append(keys, addr)
 
-		weight := 100
-		if tags, ok := tagMap[addr]; ok {
-			for _, tag := range tags {
-				if tag == "stg" {
-					weight = 1
-				} else if strings.Index(tag, "weight_") == 0 {
-					arr := strings.Split(tag, "_")
-					if len(arr) == 2 {
-						wStr := arr[1]
-						if w, err := strconv.Atoi(wStr); err == nil {
-							if w < 0 {
-								w = 0
-							} else if w > 100 {
-								w = 100
-							}
-							weight = w
-						}
-					}
-				}
-			}
-		}
+		weight := TagWeight(tagMap[addr])
 		totalWeight += weight
 		steps = append(steps, totalWeight)
 	}
 
 	if len(keys) == 0 {
-		err := fmt.Errorf("no endpoint for %s-%s, all nodes dead", method, uri)
-		return nil, "", err
+		return nil, "", errNoEndpoint(uri, method)
 	}
 
 	if len(keys) == 1 {
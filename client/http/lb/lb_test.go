@@ -2,10 +2,15 @@ package lb
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
+
+	"github.com/butters-mars/tiki/common"
 )
 
 func TestRandomSelect(t *testing.T) {
@@ -77,3 +82,194 @@ func TestRandomSelect(t *testing.T) {
 		t.Errorf("b should be selected 5 - 20 : %d", count)
 	}
 }
+
+func makeEndpoints(addrs ...string) map[string]endpoint.Endpoint {
+	m := make(map[string]endpoint.Endpoint)
+	for _, addr := range addrs {
+		m[addr] = func(context.Context, interface{}) (interface{}, error) { return nil, nil }
+	}
+	return m
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		lbType string
+		ok     bool
+	}{
+		{"", true},
+		{TypeRandom, true},
+		{TypeRoundRobin, true},
+		{TypeLeastOutstanding, true},
+		{TypeP2CEWMA, true},
+		{TypeConsistentHash, true},
+		{"unknown", false},
+	}
+
+	for _, c := range cases {
+		lb, err := New(c.lbType)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected err %v", c.lbType, err)
+			continue
+		}
+		if !c.ok {
+			continue
+		}
+
+		if _, _, err := lb.Select("/u", "GET", makeEndpoints(), nil); err == nil || !strings.Contains(err.Error(), "empty") {
+			t.Errorf("%s: should fail to select from empty: %v", c.lbType, err)
+		}
+		if _, addr, err := lb.Select("/u", "GET", makeEndpoints("a"), nil); err != nil || addr != "a" {
+			t.Errorf("%s: should select the only one: %s %v", c.lbType, addr, err)
+		}
+	}
+}
+
+func TestRoundRobinSelect(t *testing.T) {
+	cases := []struct {
+		name   string
+		addrs  []string
+		tags   map[string][]string
+		rounds int
+		expect map[string]int
+	}{
+		{"equal", []string{"a", "b", "c"}, nil, 30, map[string]int{"a": 10, "b": 10, "c": 10}},
+		{"weighted", []string{"a", "b"}, map[string][]string{"b": {"weight_50"}}, 30, map[string]int{"a": 20, "b": 10}},
+		{"canary", []string{"a", "b"}, map[string][]string{"b": {"stg"}}, 101, map[string]int{"a": 100, "b": 1}},
+		{"all zero", []string{"a", "b"}, map[string][]string{"a": {"weight_0"}, "b": {"weight_0"}}, 10, map[string]int{"a": 5, "b": 5}},
+	}
+
+	for _, c := range cases {
+		lb := NewRoundRobinLoadBalancer()
+		eps := makeEndpoints(c.addrs...)
+		counts := make(map[string]int)
+		last := ""
+		for i := 0; i < c.rounds; i++ {
+			_, addr, err := lb.Select("/u", "GET", eps, c.tags)
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if c.tags == nil && addr == last {
+				t.Errorf("%s: same addr selected in a row: %s", c.name, addr)
+			}
+			last = addr
+			counts[addr]++
+		}
+
+		for addr, n := range c.expect {
+			if counts[addr] != n {
+				t.Errorf("%s: %s should be selected %d times: %v", c.name, addr, n, counts)
+			}
+		}
+	}
+}
+
+func TestLeastOutstandingSelect(t *testing.T) {
+	cases := []struct {
+		name     string
+		inflight map[string]int
+		tags     map[string][]string
+		expect   string
+	}{
+		{"least", map[string]int{"a": 3, "b": 1, "c": 2}, nil, "b"},
+		{"weighted", map[string]int{"a": 3, "b": 1, "c": 4}, map[string][]string{"b": {"weight_10"}}, "a"},
+		{"idle", map[string]int{"a": 1, "b": 1}, nil, "c"},
+	}
+
+	for _, c := range cases {
+		lb := NewLeastOutstandingLoadBalancer()
+		for addr, n := range c.inflight {
+			for i := 0; i < n; i++ {
+				lb.(Tracker).Begin(addr)
+			}
+		}
+
+		_, addr, err := lb.Select("/u", "GET", makeEndpoints("a", "b", "c"), c.tags)
+		if err != nil || addr != c.expect {
+			t.Errorf("%s: should select %s: %s %v", c.name, c.expect, addr, err)
+		}
+	}
+}
+
+func TestP2CEWMASelect(t *testing.T) {
+	cases := []struct {
+		name    string
+		latency map[string]time.Duration
+		errs    map[string]bool
+		expect  string
+	}{
+		{"fast", map[string]time.Duration{"a": 100 * time.Millisecond, "b": 5 * time.Millisecond}, nil, "b"},
+		{"failing fast", map[string]time.Duration{"a": 20 * time.Millisecond, "b": time.Millisecond}, map[string]bool{"b": true}, "a"},
+	}
+
+	for _, c := range cases {
+		lb := NewP2CEWMALoadBalancer()
+		for addr, latency := range c.latency {
+			var err error
+			if c.errs[addr] {
+				err = errors.New("fail")
+			}
+			lb.(Tracker).Begin(addr)
+			lb.(Tracker).End(addr, latency, err)
+		}
+
+		// with 2 instances both are always picked
+		for i := 0; i < 10; i++ {
+			_, addr, err := lb.Select("/u", "GET", makeEndpoints("a", "b"), nil)
+			if err != nil || addr != c.expect {
+				t.Errorf("%s: should select %s: %s %v", c.name, c.expect, addr, err)
+				break
+			}
+		}
+	}
+}
+
+func TestConsistentHashSelect(t *testing.T) {
+	lb := NewConsistentHashLoadBalancer().(ContextSelector)
+	eps := makeEndpoints("a", "b", "c", "d")
+
+	selected := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		_, addr, err := lb.SelectContext(WithHashKey(context.TODO(), key), "/u", "GET", eps, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, again, _ := lb.SelectContext(WithHashKey(context.TODO(), key), "/u", "GET", eps, nil)
+		if again != addr {
+			t.Fatalf("%s should be sticky: %s %s", key, addr, again)
+		}
+		selected[key] = addr
+		counts[addr]++
+	}
+	for addr, n := range counts {
+		if n < 150 || n > 350 {
+			t.Errorf("%s should get about 1/4 keys: %v", addr, counts)
+		}
+	}
+
+	// removing an instance only moves its keys
+	delete(eps, "d")
+	for key, addr := range selected {
+		_, moved, _ := lb.SelectContext(WithHashKey(context.TODO(), key), "/u", "GET", eps, nil)
+		if addr != "d" && moved != addr {
+			t.Errorf("%s should stay on %s: %s", key, addr, moved)
+			break
+		}
+	}
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		key  string
+	}{
+		{"hash key", WithHashKey(context.TODO(), "k"), "k"},
+		{"uid", context.WithValue(context.TODO(), common.KeyUID{}, 42), "42"},
+		{"none", context.TODO(), ""},
+	}
+	for _, c := range cases {
+		if key := HashKey(c.ctx); key != c.key {
+			t.Errorf("%s: wrong key %s", c.name, key)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package lb
+
+import (
+	"math/rand"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type leastOutstandingLoadBalancer struct {
+	*statsTracker
+}
+
+// NewLeastOutstandingLoadBalancer creates an LB selecting the instance with least outstanding
+// requests relative to its weight, ties are broken randomly
+func NewLeastOutstandingLoadBalancer() LoadBalancer {
+	return &leastOutstandingLoadBalancer{
+		statsTracker: newStatsTracker(),
+	}
+}
+
+func (l *leastOutstandingLoadBalancer) Select(uri, method string, endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) (endpoint.Endpoint, string, error) {
+	addrs := weightedAddrs(endpoints, tagMap)
+	if len(addrs) == 0 {
+		return nil, "", errNoEndpoint(uri, method)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	best, ties, min := "", 0, 0.0
+	for _, addr := range addrs {
+		score := float64(l.get(addr).inflight+1) / float64(weightOf(tagMap[addr]))
+		switch {
+		case best == "" || score < min:
+			best, ties, min = addr, 1, score
+		case score == min:
+			// reservoir sampling among ties
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = addr
+			}
+		}
+	}
+	l.prune(toSet(addrs))
+
+	return endpoints[best], best, nil
+}
+
+// weightedAddrs returns sorted addresses with non-zero weight, or all if none has
+func weightedAddrs(endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) []string {
+	all := sortedAddrs(endpoints)
+	addrs := make([]string, 0, len(all))
+	for _, addr := range all {
+		if TagWeight(tagMap[addr]) > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return all
+	}
+	return addrs
+}
+
+// weightOf returns weight by tags, at least 1
+func weightOf(tags []string) int {
+	if w := TagWeight(tags); w > 0 {
+		return w
+	}
+	return 1
+}
+
+func toSet(addrs []string) map[string]bool {
+	set := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		set[addr] = true
+	}
+	return set
+}
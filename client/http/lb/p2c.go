@@ -0,0 +1,53 @@
+package lb
+
+import (
+	"math/rand"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type p2cEWMALoadBalancer struct {
+	*statsTracker
+}
+
+// NewP2CEWMALoadBalancer creates an LB picking two random instances and selecting the one with
+// lower cost, i.e. EWMA latency * (outstanding requests + 1) / weight
+func NewP2CEWMALoadBalancer() LoadBalancer {
+	return &p2cEWMALoadBalancer{
+		statsTracker: newStatsTracker(),
+	}
+}
+
+func (p *p2cEWMALoadBalancer) Select(uri, method string, endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) (endpoint.Endpoint, string, error) {
+	addrs := weightedAddrs(endpoints, tagMap)
+	if len(addrs) == 0 {
+		return nil, "", errNoEndpoint(uri, method)
+	}
+	if len(addrs) == 1 {
+		return endpoints[addrs[0]], addrs[0], nil
+	}
+
+	i := rand.Intn(len(addrs))
+	j := rand.Intn(len(addrs) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := addrs[i], addrs[j]
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	best := a
+	if p.cost(b, tagMap) < p.cost(a, tagMap) {
+		best = b
+	}
+	p.prune(toSet(addrs))
+
+	return endpoints[best], best, nil
+}
+
+func (p *p2cEWMALoadBalancer) cost(addr string, tagMap map[string][]string) float64 {
+	s := p.get(addr)
+	// +1 so that instances without latency yet are still ordered by outstanding requests
+	return (s.ewma + 1) * float64(s.inflight+1) / float64(weightOf(tagMap[addr]))
+}
@@ -0,0 +1,65 @@
+package lb
+
+import (
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type roundRobinLoadBalancer struct {
+	mutex   *sync.Mutex
+	current map[string]int
+}
+
+// NewRoundRobinLoadBalancer creates a smooth weighted round robin LB, weights are taken from tags
+func NewRoundRobinLoadBalancer() LoadBalancer {
+	return &roundRobinLoadBalancer{
+		mutex:   &sync.Mutex{},
+		current: make(map[string]int),
+	}
+}
+
+func (r *roundRobinLoadBalancer) Select(uri, method string, endpoints map[string]endpoint.Endpoint, tagMap map[string][]string) (endpoint.Endpoint, string, error) {
+	addrs := sortedAddrs(endpoints)
+	if len(addrs) == 0 {
+		return nil, "", errNoEndpoint(uri, method)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// drop state of instances gone
+	for addr := range r.current {
+		if _, ok := endpoints[addr]; !ok {
+			delete(r.current, addr)
+		}
+	}
+
+	best, total := "", 0
+	for _, addr := range addrs {
+		weight := TagWeight(tagMap[addr])
+		if weight == 0 {
+			continue
+		}
+		r.current[addr] += weight
+		total += weight
+		if best == "" || r.current[addr] > r.current[best] {
+			best = addr
+		}
+	}
+
+	// all weights are 0, ignore weights
+	if best == "" {
+		best = addrs[0]
+		for _, addr := range addrs {
+			r.current[addr]++
+			if r.current[addr] > r.current[best] {
+				best = addr
+			}
+		}
+		total = len(addrs)
+	}
+
+	r.current[best] -= total
+	return endpoints[best], best, nil
+}
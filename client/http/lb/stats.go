@@ -0,0 +1,86 @@
+package lb
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	ewmaDecay    = 10 * time.Second // time constant of latency EWMA
+	errorPenalty = time.Second      // latency recorded for failed calls
+)
+
+type callStats struct {
+	inflight int
+	ewma     float64 // ms
+	updated  time.Time
+}
+
+// statsTracker implements Tracker, keeps outstanding requests and peak EWMA latency
+type statsTracker struct {
+	mutex *sync.Mutex
+	stats map[string]*callStats
+}
+
+func newStatsTracker() *statsTracker {
+	return &statsTracker{
+		mutex: &sync.Mutex{},
+		stats: make(map[string]*callStats),
+	}
+}
+
+func (t *statsTracker) get(addr string) *callStats {
+	s, ok := t.stats[addr]
+	if !ok {
+		s = &callStats{}
+		t.stats[addr] = s
+	}
+	return s
+}
+
+// Begin implements Tracker
+func (t *statsTracker) Begin(addr string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.get(addr).inflight++
+}
+
+// End implements Tracker
+func (t *statsTracker) End(addr string, latency time.Duration, err error) {
+	if err != nil && latency < errorPenalty {
+		latency = errorPenalty
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s := t.get(addr)
+	if s.inflight > 0 {
+		s.inflight--
+	}
+
+	// peak EWMA: slower calls are taken at once, faster ones are decayed in
+	now := time.Now()
+	if s.updated.IsZero() || ms > s.ewma {
+		s.ewma = ms
+	} else {
+		w := math.Exp(-float64(now.Sub(s.updated)) / float64(ewmaDecay))
+		s.ewma = s.ewma*w + ms*(1-w)
+	}
+	s.updated = now
+}
+
+// prune drops stats of instances not in addrs and not in flight
+func (t *statsTracker) prune(addrs map[string]bool) {
+	if len(t.stats) <= 2*len(addrs) {
+		return
+	}
+	for addr, s := range t.stats {
+		if !addrs[addr] && s.inflight == 0 {
+			delete(t.stats, addr)
+		}
+	}
+}
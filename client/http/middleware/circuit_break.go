@@ -38,12 +38,23 @@ func initHystrixMetrics() {
 var (
 	configed = make(map[string]string)
 	cmdMap   = make(map[string]string)
-	mutex    = sync.RWMutex{}
+	// latest config by command name (without addr)
+	cmdConfigs = make(map[string]hystrix.CommandConfig)
+	// generation by command name, bumped when circuits must be rebuilt
+	generations = make(map[string]int)
+	mutex       = sync.RWMutex{}
 )
 
-// CircuitBreaker provides hystrix circuitbreaker for HTTP calls.
-func CircuitBreaker(commandName string, commandCfg hystrix.CommandConfig) endpoint.Middleware {
+// Fallback returns response of a failed request, err is the cause of failure
+type Fallback func(ctx context.Context, request interface{}, err error) (response interface{}, ferr error)
+
+// CircuitBreaker provides hystrix circuitbreaker for HTTP calls, fallback returns current fallback
+// of the command which could be nil, it's called on each request so that fallback could be changed.
+func CircuitBreaker(commandName string, commandCfg hystrix.CommandConfig, fallback func() Fallback) endpoint.Middleware {
 	//hystrix.ConfigureCommand(commandName, commandCfg)
+	mutex.Lock()
+	cmdConfigs[commandName] = commandCfg
+	mutex.Unlock()
 
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
@@ -52,26 +63,62 @@ func CircuitBreaker(commandName string, commandCfg hystrix.CommandConfig) endpoi
 			if req, ok := request.(*http.Request); ok {
 				addr = req.Host
 			}
-			cmd := fmt.Sprintf("%s-%s", commandName, addr)
+			cmd := endpointCmd(commandName, addr)
 			segs := strings.Split(commandName, "-")
 			uri := segs[2]
 			method := segs[3]
 			key := fmt.Sprintf("%s-%s-%s", addr, uri, method)
-			configCmd(cmd, key, commandCfg)
+			configCmd(cmd, key, commandName)
+
+			// hystrix records fallback metrics only if fallback is given
+			var fallbackFn func(error) error
+			var fallbackResp interface{}
+			var cause error
+			fellBack := false
+			if fallback != nil {
+				if fb := fallback(); fb != nil {
+					fallbackFn = func(runErr error) (err error) {
+						cause = runErr
+						fallbackResp, err = fb(ctx, request, runErr)
+						fellBack = err == nil
+						return
+					}
+				}
+			}
 
 			var resp interface{}
 			if err := hystrix.Do(cmd, func() (err error) {
 				resp, err = next(ctx, request)
 				return err
-			}, nil); err != nil {
+			}, fallbackFn); err != nil {
+				// keep the original error if fallback fails
+				if cause != nil {
+					return nil, cause
+				}
 				return nil, err
 			}
+			if fellBack {
+				return fallbackResp, nil
+			}
 			return resp, nil
 		}
 	}
 }
 
-func configCmd(cmd string, key string, cfg hystrix.CommandConfig) {
+// endpointCmd returns endpoint level command of the command name, which is suffixed by
+// generation of the command name if any
+func endpointCmd(commandName, addr string) string {
+	mutex.RLock()
+	gen := generations[commandName]
+	mutex.RUnlock()
+
+	if gen == 0 {
+		return fmt.Sprintf("%s-%s", commandName, addr)
+	}
+	return fmt.Sprintf("%s-%s#%d", commandName, addr, gen)
+}
+
+func configCmd(cmd string, key string, commandName string) {
 	mutex.RLock()
 	if _, ok := configed[cmd]; ok {
 		mutex.RUnlock()
@@ -85,12 +132,47 @@ func configCmd(cmd string, key string, cfg hystrix.CommandConfig) {
 	if _, ok := configed[cmd]; ok {
 		return
 	}
-	hystrix.ConfigureCommand(cmd, cfg)
+	hystrix.ConfigureCommand(cmd, cmdConfigs[commandName])
 	configed[cmd] = key
 	cmdMap[key] = cmd
 	logger.Infof("[CB] endpoint %s -> %s configured", key, cmd)
 }
 
+// UpdateCommandConfig reconfigures all endpoint level commands of the given command name.
+// Since hystrix keeps the pool of a circuit once created, changes of max concurrent requests
+// move the endpoints to commands of a new generation, circuits of other commands are kept.
+func UpdateCommandConfig(commandName string, cfg hystrix.CommandConfig) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	old := cmdConfigs[commandName]
+	cmdConfigs[commandName] = cfg
+
+	rebuild := old.MaxConcurrentRequests != cfg.MaxConcurrentRequests
+	if rebuild {
+		generations[commandName]++
+		logger.Warnf("[CB] max concurrent requests of %s changed %d -> %d, move to generation %d",
+			commandName, old.MaxConcurrentRequests, cfg.MaxConcurrentRequests, generations[commandName])
+	}
+
+	prefix := commandName + "-"
+	for cmd, key := range configed {
+		if !strings.HasPrefix(cmd, prefix) {
+			continue
+		}
+		if rebuild {
+			// configured with the new config on next request
+			delete(configed, cmd)
+			if cmdMap[key] == cmd {
+				delete(cmdMap, key)
+			}
+			continue
+		}
+		hystrix.ConfigureCommand(cmd, cfg)
+		logger.Infof("[CB] command %s reconfigured: %+v", cmd, cfg)
+	}
+}
+
 // CleanupEndpoint cleans up endpoint info by given key(format: <addr>-<uri>-<method>)
 func CleanupEndpoint(key string) {
 	mutex.Lock()
@@ -105,6 +187,32 @@ func CleanupEndpoint(key string) {
 	delete(cmdMap, key)
 }
 
+// CountCircuits returns number of open and all circuits of given target host
+func CountCircuits(host string) (open, total int) {
+	mutex.RLock()
+	cmds := make([]string, 0)
+	for cmd := range configed {
+		// cmd format: <src>-<host>-<uri>-<method>-<addr>
+		if segs := strings.Split(cmd, "-"); len(segs) > 1 && segs[1] == host {
+			cmds = append(cmds, cmd)
+		}
+	}
+	mutex.RUnlock()
+
+	for _, cmd := range cmds {
+		c, _, err := hystrix.GetCircuit(cmd)
+		if err != nil {
+			continue
+		}
+		total++
+		if c.IsOpen() {
+			open++
+		}
+	}
+
+	return
+}
+
 // IsCircuitOpen return whether circuit of given key(format: <addr>-<uri>-<method>) is open
 func IsCircuitOpen(key string) (open, ok bool) {
 	mutex.RLock()
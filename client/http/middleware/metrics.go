@@ -20,10 +20,12 @@ const (
 	labelCode   = "status"
 	labelSrcIP  = "src_ip"
 	labelTgtIP  = "tgt_ip"
+	labelReason = "reason"
 )
 
 var allLabels = []string{labelSrc, labelTgt, labelURI, labelMethod}
 var allLabelsWithCode = []string{labelSrc, labelTgt, labelURI, labelMethod, labelCode}
+var allLabelsWithReason = []string{labelSrc, labelTgt, labelURI, labelMethod, labelReason}
 
 var qps metrics.Counter = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
 	Namespace: "service",
@@ -46,11 +48,56 @@ var lantency metrics.Histogram = prometheus.NewSummaryFrom(stdprometheus.Summary
 	Help:      "Lantency of api call.",
 }, allLabels)
 
+var retry metrics.Counter = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Namespace: "service",
+	Subsystem: "api",
+	Name:      "retry",
+	Help:      "Retries of api call.",
+}, allLabelsWithReason)
+
+var allLabelsWithTgtIP = []string{labelSrc, labelTgt, labelURI, labelMethod, labelTgtIP}
+var allLabelsWithTgtIPReason = []string{labelSrc, labelTgt, labelURI, labelMethod, labelTgtIP, labelReason}
+
+var ejection metrics.Counter = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Namespace: "service",
+	Subsystem: "api",
+	Name:      "ejection",
+	Help:      "Ejections of upstream instances by outlier detection.",
+}, allLabelsWithTgtIPReason)
+
+var ejected metrics.Gauge = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+	Namespace: "service",
+	Subsystem: "api",
+	Name:      "ejected",
+	Help:      "Whether the upstream instance is ejected (1) or not (0).",
+}, allLabelsWithTgtIP)
+
+// RecordEjection counts an ejection of upstream instance with the reason
+func RecordEjection(src, tgt, uri, method, addr, reason string) {
+	ejection.With(labelSrc, src, labelTgt, tgt, labelURI, uri, labelMethod, method, labelTgtIP, addr, labelReason, reason).Add(1)
+	ejected.With(labelSrc, src, labelTgt, tgt, labelURI, uri, labelMethod, method, labelTgtIP, addr).Set(1)
+}
+
+// RecordRecovery marks the upstream instance not ejected
+func RecordRecovery(src, tgt, uri, method, addr string) {
+	ejected.With(labelSrc, src, labelTgt, tgt, labelURI, uri, labelMethod, method, labelTgtIP, addr).Set(0)
+}
+
+// RecordRetry counts a retry of api call with the reason (error class or status)
+func RecordRetry(src, tgt, uri, method, reason string) {
+	retry.With(labelSrc, src, labelTgt, tgt, labelURI, uri, labelMethod, method, labelReason, reason).Add(1)
+}
+
 // InitMetrics inits prometheus setting and starts server on given port
 func InitMetrics() {
 	initHystrixMetrics()
 }
 
+// statusError is an error of a response with failure status
+type statusError interface {
+	HTTPStatus() int
+}
+
 // Metrics returns a middleware to export metrics to prometheus
 func Metrics(src, tgt, uri, method string) endpoint.Middleware {
 	labels := []string{labelSrc, src, labelTgt, tgt, labelURI, uri, labelMethod, method}
@@ -65,16 +112,20 @@ func Metrics(src, tgt, uri, method string) endpoint.Middleware {
 
 			resp, err := next(ctx, request)
 
+			code := 0
 			if arr, ok := resp.([]interface{}); ok {
 				if err != nil {
 					logger.Infof("[Metrics] resp: %v, err: %v", arr[1], err)
 				}
-				if code, ok := arr[1].(int); ok && code >= 400 {
-					errLabels := []string{}
-					errLabels = append(errLabels, labels...)
-					errLabels = append(errLabels, labelCode, fmt.Sprintf("%d", code))
-					errCode.With(errLabels...).Add(1)
-				}
+				code, _ = arr[1].(int)
+			} else if statusErr, ok := err.(statusError); ok {
+				code = statusErr.HTTPStatus()
+			}
+			if code >= 400 {
+				errLabels := []string{}
+				errLabels = append(errLabels, labels...)
+				errLabels = append(errLabels, labelCode, fmt.Sprintf("%d", code))
+				errCode.With(errLabels...).Add(1)
 			}
 
 			return resp, err
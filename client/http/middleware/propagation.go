@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/butters-mars/tiki/propagation"
+)
+
+// Propagation injects baggage of the context into headers of outgoing calls
+func Propagation() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if req, ok := request.(*http.Request); ok && req != nil {
+				propagation.InjectHeader(ctx, req.Header)
+			}
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/butters-mars/tiki/client/http/middleware"
+)
+
+const (
+	defaultConsecutiveFailures = 5
+	defaultMinRequests         = 20
+	defaultDetectionInterval   = 10000  // ms
+	defaultBaseEjection        = 30000  // ms
+	defaultMaxEjection         = 300000 // ms
+	defaultMaxEjectionPercent  = 50
+
+	ejectConsecutiveFailures = "consecutive_failures"
+	ejectErrorRate           = "error_rate"
+	ejectProbeFailed         = "probe_failed"
+)
+
+// OutlierDetection ejects instances passively by results of calls. An ejected instance gets
+// no request until its ejection time passes, then a single probe decides whether it recovers
+// or is ejected again with doubled ejection time.
+type OutlierDetection struct {
+	Disabled            bool `yaml:"disabled"`
+	ConsecutiveFailures int  `yaml:"consecutive_failures"` // default 5
+	ErrorRate           int  `yaml:"error_rate"`           // percent of failures in interval, 0 disables it
+	MinRequests         int  `yaml:"min_requests"`         // requests in interval to check error rate, default 20
+	Interval            int  `yaml:"interval"`             // ms, default 10000
+	BaseEjection        int  `yaml:"base_ejection"`        // ms, default 30000
+	MaxEjection         int  `yaml:"max_ejection"`         // ms, default 300000
+	MaxEjectionPercent  int  `yaml:"max_ejection_percent"` // default 50
+}
+
+// Ejection describes an ejected instance
+type Ejection struct {
+	Target    string    `json:"target"`
+	URI       string    `json:"uri"`
+	Method    string    `json:"method"`
+	Addr      string    `json:"addr"`
+	Reason    string    `json:"reason"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	Ejections int       `json:"ejections"`
+	Probing   bool      `json:"probing"`
+}
+
+type hostState struct {
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+
+	ejected     bool
+	probing     bool
+	reason      string
+	ejectedAt   time.Time
+	until       time.Time
+	ejections   int // ejections in a row, for exponential ejection time
+	recoveredAt time.Time
+}
+
+// outlierDetector detects outliers among instances of an endpoint client
+type outlierDetector struct {
+	host   string
+	uri    string
+	method string
+
+	mutex  *sync.Mutex
+	policy OutlierDetection
+	hosts  map[string]*hostState
+}
+
+func newOutlierDetector(policy *OutlierDetection, host, uri, method string) *outlierDetector {
+	d := &outlierDetector{
+		host:   host,
+		uri:    uri,
+		method: method,
+		mutex:  &sync.Mutex{},
+		hosts:  make(map[string]*hostState),
+	}
+	d.setPolicy(policy)
+	return d
+}
+
+// setPolicy changes policy and keeps current state
+func (d *outlierDetector) setPolicy(policy *OutlierDetection) {
+	p := OutlierDetection{}
+	if policy != nil {
+		p = *policy
+	}
+	if p.ConsecutiveFailures <= 0 {
+		p.ConsecutiveFailures = defaultConsecutiveFailures
+	}
+	if p.MinRequests <= 0 {
+		p.MinRequests = defaultMinRequests
+	}
+	if p.Interval <= 0 {
+		p.Interval = defaultDetectionInterval
+	}
+	if p.BaseEjection <= 0 {
+		p.BaseEjection = defaultBaseEjection
+	}
+	if p.MaxEjection <= 0 {
+		p.MaxEjection = defaultMaxEjection
+	}
+	if p.MaxEjectionPercent <= 0 {
+		p.MaxEjectionPercent = defaultMaxEjectionPercent
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.policy = p
+	if p.Disabled {
+		for addr, s := range d.hosts {
+			if s.ejected {
+				d.recover(addr, s)
+			}
+		}
+	}
+}
+
+// filter removes ejected instances, except those whose ejection time passed and no probe is in flight
+func (d *outlierDetector) filter(endpoints map[string]endpoint.Endpoint) map[string]endpoint.Endpoint {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	filtered := make(map[string]endpoint.Endpoint)
+	for addr, ep := range endpoints {
+		if s, ok := d.hosts[addr]; ok && !d.policy.Disabled && s.ejected && (s.probing || now.Before(s.until)) {
+			continue
+		}
+		filtered[addr] = ep
+	}
+
+	return filtered
+}
+
+// begin is called with the selected instance, returns false if it's an ejected one being probed
+func (d *outlierDetector) begin(addr string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	s, ok := d.hosts[addr]
+	if !ok || !s.ejected || d.policy.Disabled {
+		return true
+	}
+	if s.probing || time.Now().Before(s.until) {
+		return false
+	}
+
+	s.probing = true
+	logger.Infof("[Outlier] probe %s of %s%s-%s", addr, d.host, d.uri, d.method)
+	return true
+}
+
+// end records result of a call to the instance, total is the number of instances
+func (d *outlierDetector) end(addr string, failed bool, total int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.policy.Disabled {
+		return
+	}
+
+	s, ok := d.hosts[addr]
+	if !ok {
+		s = &hostState{windowStart: time.Now()}
+		d.hosts[addr] = s
+	}
+
+	if s.ejected {
+		if !s.probing {
+			return
+		}
+		s.probing = false
+		if failed {
+			d.eject(addr, s, ejectProbeFailed)
+		} else {
+			d.recover(addr, s)
+		}
+		return
+	}
+
+	if time.Since(s.windowStart) > time.Duration(d.policy.Interval)*time.Millisecond {
+		s.windowStart = time.Now()
+		s.requests, s.failures = 0, 0
+	}
+	s.requests++
+	if !failed {
+		s.consecutive = 0
+		return
+	}
+	s.failures++
+	s.consecutive++
+
+	reason := ""
+	if s.consecutive >= d.policy.ConsecutiveFailures {
+		reason = ejectConsecutiveFailures
+	} else if d.policy.ErrorRate > 0 && s.requests >= d.policy.MinRequests &&
+		s.failures*100 >= d.policy.ErrorRate*s.requests {
+		reason = ejectErrorRate
+	}
+	if reason == "" {
+		return
+	}
+
+	ejected := 0
+	for _, h := range d.hosts {
+		if h.ejected {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > d.policy.MaxEjectionPercent*total {
+		logger.Warnf("[Outlier] %s of %s%s-%s is an outlier (%s), but %d/%d instances ejected already",
+			addr, d.host, d.uri, d.method, reason, ejected, total)
+		return
+	}
+
+	d.eject(addr, s, reason)
+}
+
+func (d *outlierDetector) eject(addr string, s *hostState, reason string) {
+	now := time.Now()
+	maxEjection := time.Duration(d.policy.MaxEjection) * time.Millisecond
+
+	// healthy for long enough, restart from base ejection time
+	if reason != ejectProbeFailed && now.Sub(s.recoveredAt) > maxEjection {
+		s.ejections = 0
+	}
+	s.ejections++
+
+	ejection := time.Duration(d.policy.BaseEjection) * time.Millisecond
+	for i := 1; i < s.ejections && ejection < maxEjection; i++ {
+		ejection *= 2
+	}
+	if ejection > maxEjection {
+		ejection = maxEjection
+	}
+
+	if !s.ejected {
+		s.ejectedAt = now
+	}
+	s.ejected = true
+	s.reason = reason
+	s.until = now.Add(ejection)
+	s.consecutive = 0
+	s.requests, s.failures = 0, 0
+
+	logger.Warnf("[Outlier] eject %s of %s%s-%s for %v (%s, %d in a row)", addr, d.host, d.uri, d.method, ejection, reason, s.ejections)
+	middleware.RecordEjection(normal(source), normal(d.host), normal(d.uri), d.method, addr, reason)
+}
+
+func (d *outlierDetector) recover(addr string, s *hostState) {
+	s.ejected = false
+	s.probing = false
+	s.recoveredAt = time.Now()
+	s.windowStart = time.Now()
+
+	logger.Infof("[Outlier] %s of %s%s-%s recovered", addr, d.host, d.uri, d.method)
+	middleware.RecordRecovery(normal(source), normal(d.host), normal(d.uri), d.method, addr)
+}
+
+// forget drops state of the instance which is gone
+func (d *outlierDetector) forget(addr string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if s, ok := d.hosts[addr]; ok && s.ejected {
+		middleware.RecordRecovery(normal(source), normal(d.host), normal(d.uri), d.method, addr)
+	}
+	delete(d.hosts, addr)
+}
+
+func (d *outlierDetector) ejectionList() []Ejection {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	list := make([]Ejection, 0)
+	for addr, s := range d.hosts {
+		if !s.ejected {
+			continue
+		}
+		list = append(list, Ejection{
+			Target:    d.host,
+			URI:       d.uri,
+			Method:    d.method,
+			Addr:      addr,
+			Reason:    s.reason,
+			Since:     s.ejectedAt,
+			Until:     s.until,
+			Ejections: s.ejections,
+			Probing:   s.probing,
+		})
+	}
+
+	return list
+}
+
+// isHostFailure returns whether the error is caused by the instance, errors of http.Client
+// are wrapped by *url.Error
+func isHostFailure(err error, status *statusPolicy) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, hystrix.ErrMaxConcurrency) {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return status.failure.match(httpErr.StatusCode)
+	}
+	return true
+}
+
+// Ejections returns ejected instances of all live clients
+func Ejections() []Ejection {
+	liveClientsMutex.RLock()
+	clients := make([]DefaultClient, 0)
+	for _, byID := range liveClients {
+		for _, c := range byID {
+			clients = append(clients, c)
+		}
+	}
+	liveClientsMutex.RUnlock()
+
+	list := make([]Ejection, 0)
+	for _, c := range clients {
+		c.mutex.RLock()
+		for _, ec := range c.endpoints {
+			list = append(list, ec.outlier.ejectionList()...)
+		}
+		c.mutex.RUnlock()
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.URI != b.URI {
+			return a.URI < b.URI
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Addr < b.Addr
+	})
+	return list
+}
+
+// OutlierHandler serves ejected instances as json
+func OutlierHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(headerContentType, contentTypeJSON)
+		json.NewEncoder(w).Encode(Ejections())
+	})
+}
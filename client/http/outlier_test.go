@@ -0,0 +1,155 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/go-kit/kit/endpoint"
+)
+
+func fourEndpoints() map[string]endpoint.Endpoint {
+	ep := func(context.Context, interface{}) (interface{}, error) { return nil, nil }
+	return map[string]endpoint.Endpoint{"a": ep, "b": ep, "c": ep, "d": ep}
+}
+
+func TestOutlierEjection(t *testing.T) {
+	d := newOutlierDetector(&OutlierDetection{ConsecutiveFailures: 3, BaseEjection: 30}, "tgt", "/u", "GET")
+
+	for i := 0; i < 2; i++ {
+		d.end("a", true, 4)
+	}
+	d.end("a", false, 4)
+	if len(d.filter(fourEndpoints())) != 4 {
+		t.Fatal("success should reset consecutive failures")
+	}
+
+	for i := 0; i < 3; i++ {
+		d.end("a", true, 4)
+	}
+	if _, ok := d.filter(fourEndpoints())["a"]; ok {
+		t.Fatal("a should be ejected")
+	}
+	if d.begin("a") {
+		t.Fatal("a should not be called before ejection time passes")
+	}
+	if list := d.ejectionList(); len(list) != 1 || list[0].Reason != ejectConsecutiveFailures {
+		t.Errorf("wrong ejections: %v", list)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := d.filter(fourEndpoints())["a"]; !ok || !d.begin("a") {
+		t.Fatal("a should be probed after ejection time")
+	}
+	if _, ok := d.filter(fourEndpoints())["a"]; ok || d.begin("a") {
+		t.Fatal("only one probe at a time")
+	}
+
+	// probe failed, ejected again for doubled time
+	d.end("a", true, 4)
+	until := d.hosts["a"].until
+	if ejection := time.Until(until); ejection < 40*time.Millisecond || ejection > 60*time.Millisecond {
+		t.Errorf("ejection time should be doubled: %v", ejection)
+	}
+
+	time.Sleep(70 * time.Millisecond)
+	d.begin("a")
+	d.end("a", false, 4)
+	if len(d.filter(fourEndpoints())) != 4 || len(d.ejectionList()) != 0 {
+		t.Error("a should recover after successful probe")
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  *OutlierDetection
+		fail    []string
+		total   int
+		ejected int
+	}{
+		{"single instance", nil, []string{"a"}, 1, 0},
+		{"half", nil, []string{"a", "b", "c"}, 4, 2},
+		{"all allowed", &OutlierDetection{MaxEjectionPercent: 100}, []string{"a", "b", "c", "d"}, 4, 4},
+		{"disabled", &OutlierDetection{Disabled: true}, []string{"a"}, 4, 0},
+	}
+
+	for _, c := range cases {
+		d := newOutlierDetector(c.policy, "tgt", "/u", "GET")
+		for _, addr := range c.fail {
+			for i := 0; i < defaultConsecutiveFailures; i++ {
+				d.end(addr, true, c.total)
+			}
+		}
+		if n := len(d.ejectionList()); n != c.ejected {
+			t.Errorf("%s: %d should be ejected, got %d", c.name, c.ejected, n)
+		}
+	}
+}
+
+func TestOutlierErrorRate(t *testing.T) {
+	d := newOutlierDetector(&OutlierDetection{ConsecutiveFailures: 100, ErrorRate: 50, MinRequests: 10}, "tgt", "/u", "GET")
+	for i := 0; i < 10; i++ {
+		d.end("a", i%2 == 1, 4)
+	}
+	if list := d.ejectionList(); len(list) != 1 || list[0].Reason != ejectErrorRate {
+		t.Errorf("a should be ejected by error rate: %v", list)
+	}
+}
+
+func TestIsHostFailure(t *testing.T) {
+	status := newStatusPolicy(nil)
+	cases := []struct {
+		err    error
+		expect bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{&url.Error{Op: "Get", URL: "http://a/u", Err: context.Canceled}, false},
+		{hystrix.ErrMaxConcurrency, false},
+		{hystrix.ErrTimeout, true},
+		{errors.New("connection refused"), true},
+		{&HTTPError{StatusCode: 404}, false},
+		{&HTTPError{StatusCode: 503}, true},
+		{fmt.Errorf("call: %w", &HTTPError{StatusCode: 404}), false},
+	}
+
+	for _, c := range cases {
+		if isHostFailure(c.err, status) != c.expect {
+			t.Errorf("isHostFailure(%v) should be %v", c.err, c.expect)
+		}
+	}
+}
+
+func TestOutlierIgnoresCanceledCalls(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	defer cl.Close()
+
+	for i := 0; i < defaultConsecutiveFailures+1; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if _, _, err := cl.DoRaw(ctx, "/block", "GET", nil); err == nil {
+			t.Fatal("should fail canceled call")
+		}
+	}
+
+	ec, _ := cl.getEndpointClient("/block", "GET")
+	ec.outlier.mutex.Lock()
+	defer ec.outlier.mutex.Unlock()
+	if s, ok := ec.outlier.hosts[host]; ok && s.consecutive > 0 {
+		t.Errorf("canceled calls should not count as host failures: %d", s.consecutive)
+	}
+}
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	headerContentType    = "Content-Type"
+	headerAuthorization  = "Authorization"
+	headerIdempotencyKey = "Idempotency-Key"
+
+	contentTypeJSON = "application/json"
+)
+
+// RequestOption customizes a single call
+type RequestOption func(*requestOptions)
+
+type requestOptions struct {
+	header      http.Header
+	query       url.Values
+	contentType string
+	timeout     time.Duration
+}
+
+func newRequestOptions(opts []RequestOption) *requestOptions {
+	o := &requestOptions{
+		header: make(http.Header),
+		query:  make(url.Values),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHeader adds a request header
+func WithHeader(key, value string) RequestOption {
+	return func(o *requestOptions) {
+		o.header.Add(key, value)
+	}
+}
+
+// WithHeaders adds request headers
+func WithHeaders(header http.Header) RequestOption {
+	return func(o *requestOptions) {
+		for key, values := range header {
+			for _, value := range values {
+				o.header.Add(key, value)
+			}
+		}
+	}
+}
+
+// WithQuery adds a query param
+func WithQuery(key, value string) RequestOption {
+	return func(o *requestOptions) {
+		o.query.Add(key, value)
+	}
+}
+
+// WithQueryValues adds query params
+func WithQueryValues(values url.Values) RequestOption {
+	return func(o *requestOptions) {
+		for key, vs := range values {
+			for _, value := range vs {
+				o.query.Add(key, value)
+			}
+		}
+	}
+}
+
+// WithContentType sets content type of the body, default is application/json
+// for json-marshalled params and none for []byte params
+func WithContentType(contentType string) RequestOption {
+	return func(o *requestOptions) {
+		o.contentType = contentType
+	}
+}
+
+// WithTimeout overrides timeout of the call including retries, it can only
+// shorten the call since each try is still bounded by the hystrix timeout
+func WithTimeout(timeout time.Duration) RequestOption {
+	return func(o *requestOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithBasicAuth sets basic authorization
+func WithBasicAuth(username, password string) RequestOption {
+	return func(o *requestOptions) {
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		o.header.Set(headerAuthorization, "Basic "+auth)
+	}
+}
+
+// WithBearerToken sets bearer authorization
+func WithBearerToken(token string) RequestOption {
+	return func(o *requestOptions) {
+		o.header.Set(headerAuthorization, "Bearer "+token)
+	}
+}
+
+// WithIdempotencyKey sets Idempotency-Key header, which is kept the same
+// across retries so that upstream can dedup them
+func WithIdempotencyKey(key string) RequestOption {
+	return func(o *requestOptions) {
+		o.header.Set(headerIdempotencyKey, key)
+	}
+}
+
+// buildURL appends query params to uri
+func (o *requestOptions) buildURL(scheme, addr, uri string) string {
+	u := scheme + "://" + addr + uri
+	if len(o.query) == 0 {
+		return u
+	}
+
+	sep := "?"
+	if strings.Contains(uri, "?") {
+		sep = "&"
+	}
+	return u + sep + o.query.Encode()
+}
+
+// apply sets headers to the request
+func (o *requestOptions) apply(req *http.Request, jsonBody bool) {
+	for key, values := range o.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+
+	if o.contentType != "" {
+		req.Header.Set(headerContentType, o.contentType)
+	} else if jsonBody && req.Header.Get(headerContentType) == "" {
+		req.Header.Set(headerContentType, contentTypeJSON)
+	}
+}
+
+// Response is the response of a call
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Addr       string // address of the upstream instance
+	Fallback   bool   // served by fallback
+}
+
+// JSON unmarshals body into v
+func (r *Response) JSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
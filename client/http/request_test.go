@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		user, pass, _ := req.BasicAuth()
+		if req.URL.Query().Get("a") != "1" || req.URL.Query().Get("b") != "2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if user != "u" || pass != "p" || req.Header.Get("X-Foo") != "bar" ||
+			req.Header.Get("Idempotency-Key") != "k1" || req.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("X-Echo", string(body))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false)
+	resp, err := cl.Request(context.TODO(), "/opts?a=1", "POST", map[string]int{"x": 1},
+		WithQuery("b", "2"),
+		WithHeader("X-Foo", "bar"),
+		WithBasicAuth("u", "p"),
+		WithIdempotencyKey("k1"))
+	if err != nil {
+		t.Fatalf("fail to call: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("wrong status: %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-Echo") != `{"x":1}` {
+		t.Errorf("wrong header: %v", resp.Header)
+	}
+	if resp.Addr != host {
+		t.Errorf("wrong addr: %s", resp.Addr)
+	}
+	result := make(map[string]bool)
+	if err = resp.JSON(&result); err != nil || !result["ok"] {
+		t.Errorf("wrong body: %s", resp.Body)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false)
+	_, code, err := cl.DoRaw(context.TODO(), "/slow", "GET", nil, WithTimeout(5*time.Millisecond))
+	if err == nil {
+		t.Errorf("should time out, code: %d", code)
+	}
+
+	_, code, err = cl.DoRaw(context.TODO(), "/slow", "GET", nil, WithBearerToken("t"))
+	if err != nil || code != http.StatusOK {
+		t.Errorf("should succeed without timeout override: %d %v", code, err)
+	}
+}
@@ -0,0 +1,253 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+)
+
+const (
+	// ErrClassConnect connection could not be established
+	ErrClassConnect = "connect"
+	// ErrClassReset connection reset or closed by peer
+	ErrClassReset = "reset"
+	// ErrClassTimeout request timed out
+	ErrClassTimeout = "timeout"
+	// ErrClassCircuitOpen circuit of the instance is open
+	ErrClassCircuitOpen = "circuit_open"
+	// ErrClassRejected rejected by max concurrency
+	ErrClassRejected = "rejected"
+
+	defaultBackoffBase      = 25  // ms
+	defaultBackoffMax       = 250 // ms
+	defaultBudgetRatio      = 0.2 // 20% of requests
+	defaultBudgetMinRetries = 10  // per window
+	budgetWindow            = 10 * time.Second
+)
+
+var defaultRetryOnErrors = []string{ErrClassConnect, ErrClassReset, ErrClassCircuitOpen}
+
+// notSentErrors are error classes the request was not sent to the instance
+var notSentErrors = map[string]bool{ErrClassConnect: true, ErrClassCircuitOpen: true, ErrClassRejected: true}
+
+// Retry retry policies
+type Retry struct {
+	MaxAttempts      int      `yaml:"max_attempts"`       // including the first try, <= 1 disables retry
+	RetryOnStatus    []int    `yaml:"retry_on_status"`    // e.g. 502, 503
+	RetryOnErrors    []string `yaml:"retry_on_errors"`    // error classes, default is connect, reset, circuit_open
+	BackoffBase      int      `yaml:"backoff_base"`       // ms, default 25
+	BackoffMax       int      `yaml:"backoff_max"`        // ms, default 250
+	PerTryTimeout    int      `yaml:"per_try_timeout"`    // ms, 0 means no per try timeout
+	BudgetRatio      float64  `yaml:"budget_ratio"`       // max retries / requests, default 0.2
+	BudgetMinRetries int      `yaml:"budget_min_retries"` // retries always allowed per 10s, default 10
+	RetryOnCodes     []string `yaml:"retry_on_codes"`     // grpc status codes, e.g. UNAVAILABLE, default is UNAVAILABLE
+
+	// retry non-idempotent methods (POST, PATCH) on status or errors after the request may be
+	// sent, by default they're retried only if the request was not sent, e.g. connect error
+	RetryNonIdempotent bool `yaml:"retry_non_idempotent"`
+}
+
+// Hedging hedging policy of grpc methods, another request is sent if no response within delay,
+// the first response of success or fatal code is taken and the others are canceled
+type Hedging struct {
+	MaxAttempts   int      `yaml:"max_attempts"`    // including the first request, <= 1 disables hedging
+	Delay         int      `yaml:"delay"`           // ms between requests, 0 sends all at once
+	NonFatalCodes []string `yaml:"non_fatal_codes"` // grpc status codes to wait for other requests, default is UNAVAILABLE
+}
+
+// retrier applies retry policy of an endpoint
+type retrier struct {
+	policy   Retry
+	statuses map[int]bool
+	errs     map[string]bool
+	budget   *retryBudget
+}
+
+func newRetrier(policy *Retry) *retrier {
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return nil
+	}
+
+	r := &retrier{
+		policy:   *policy,
+		statuses: make(map[int]bool),
+		errs:     make(map[string]bool),
+	}
+	if r.policy.BackoffBase <= 0 {
+		r.policy.BackoffBase = defaultBackoffBase
+	}
+	if r.policy.BackoffMax <= 0 {
+		r.policy.BackoffMax = defaultBackoffMax
+	}
+	if r.policy.BudgetRatio <= 0 {
+		r.policy.BudgetRatio = defaultBudgetRatio
+	}
+	if r.policy.BudgetMinRetries <= 0 {
+		r.policy.BudgetMinRetries = defaultBudgetMinRetries
+	}
+
+	for _, code := range r.policy.RetryOnStatus {
+		r.statuses[code] = true
+	}
+	errs := r.policy.RetryOnErrors
+	if len(errs) == 0 {
+		errs = defaultRetryOnErrors
+	}
+	for _, e := range errs {
+		r.errs[e] = true
+	}
+
+	r.budget = &retryBudget{
+		ratio:      r.policy.BudgetRatio,
+		minRetries: r.policy.BudgetMinRetries,
+		start:      time.Now(),
+	}
+
+	return r
+}
+
+// idempotent returns whether the http method is idempotent, so it's safe to send it twice
+func idempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodPost, http.MethodPatch, http.MethodConnect:
+		return false
+	}
+	return true
+}
+
+// retryable returns reason of retry, or empty string if the result of the method should not be retried
+func (r *retrier) retryable(method string, code int, err error) string {
+	if httpErr, ok := err.(*HTTPError); ok {
+		code, err = httpErr.StatusCode, nil
+	}
+	safe := r.policy.RetryNonIdempotent || idempotent(method)
+
+	if err != nil {
+		class := errClass(err)
+		if class != "" && r.errs[class] && (safe || notSentErrors[class]) {
+			return class
+		}
+		return ""
+	}
+
+	if safe && r.statuses[code] {
+		return "status"
+	}
+	return ""
+}
+
+// backoff waits before the given attempt (starts from 1 for the first retry) with exponential
+// backoff and full jitter, returns false if ctx is done or its deadline is before the end of waiting
+func (r *retrier) backoff(ctx context.Context, attempt int) bool {
+	d := time.Duration(r.policy.BackoffBase) * time.Millisecond
+	max := time.Duration(r.policy.BackoffMax) * time.Millisecond
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	d = time.Duration(rand.Int63n(int64(d) + 1))
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
+		return false
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// tryContext returns context for a single try with per try timeout
+func (r *retrier) tryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r == nil || r.policy.PerTryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(r.policy.PerTryTimeout)*time.Millisecond)
+}
+
+func errClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	switch err {
+	case hystrix.ErrCircuitOpen:
+		return ErrClassCircuitOpen
+	case hystrix.ErrMaxConcurrency:
+		return ErrClassRejected
+	case hystrix.ErrTimeout, context.DeadlineExceeded:
+		return ErrClassTimeout
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrClassTimeout
+	}
+
+	msg := err.Error()
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ErrClassConnect
+	}
+	if strings.Contains(msg, "connection refused") || strings.Contains(msg, "no such host") {
+		return ErrClassConnect
+	}
+	if strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe") || strings.Contains(msg, "EOF") {
+		return ErrClassReset
+	}
+
+	return ""
+}
+
+// retryBudget caps retries to a ratio of requests in a fixed window
+type retryBudget struct {
+	ratio      float64
+	minRetries int
+
+	mutex    sync.Mutex
+	start    time.Time
+	requests int
+	retries  int
+}
+
+func (b *retryBudget) roll() {
+	if time.Since(b.start) > budgetWindow {
+		b.start = time.Now()
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+// request records a request
+func (b *retryBudget) request() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.roll()
+	b.requests++
+}
+
+// acquire returns whether a retry is allowed, and records it if so
+func (b *retryBudget) acquire() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.roll()
+	if b.retries >= b.minRetries && float64(b.retries) >= b.ratio*float64(b.requests) {
+		return false
+	}
+	b.retries++
+	return true
+}
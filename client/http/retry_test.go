@@ -0,0 +1,97 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	ec, err := cl.createEndpointClient(&EndpointSetting{
+		URI:    "/retry",
+		Method: "GET",
+		Retry: &Retry{
+			MaxAttempts:   3,
+			RetryOnStatus: []int{http.StatusServiceUnavailable},
+			BackoffBase:   1,
+		},
+	})
+	if err != nil {
+		t.Errorf("fail to create endpoint client: %v", err)
+		return
+	}
+
+	resp := make(map[string]interface{})
+	err = ec.Do(context.TODO(), "/retry", "GET", nil, &resp)
+	if err != nil {
+		t.Errorf("should succeed after retry: %v", err)
+		return
+	}
+	if calls != 2 || resp["ok"] != true {
+		t.Errorf("should be called twice: %d, %v", calls, resp)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	r := newRetrier(&Retry{MaxAttempts: 2, BudgetRatio: 0.5, BudgetMinRetries: 1})
+
+	r.budget.request()
+	if !r.budget.acquire() {
+		t.Error("min retries should be allowed")
+		return
+	}
+	if r.budget.acquire() {
+		t.Error("budget should be exhausted")
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		r.budget.request()
+	}
+	if !r.budget.acquire() {
+		t.Error("retry should be allowed within ratio")
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	policy := &Retry{MaxAttempts: 2, RetryOnStatus: []int{http.StatusBadGateway}, RetryOnErrors: []string{ErrClassConnect, ErrClassTimeout}}
+	r := newRetrier(policy)
+	optIn := *policy
+	optIn.RetryNonIdempotent = true
+
+	cases := []struct {
+		r      *retrier
+		method string
+		code   int
+		err    error
+		reason string
+	}{
+		{r, "GET", http.StatusBadGateway, nil, "status"},
+		{r, "PUT", 0, context.DeadlineExceeded, ErrClassTimeout},
+		{r, "POST", http.StatusBadGateway, nil, ""},
+		{r, "PATCH", 0, context.DeadlineExceeded, ""},
+		{r, "POST", 0, errors.New("dial tcp: connection refused"), ErrClassConnect},
+		{newRetrier(&optIn), "POST", http.StatusBadGateway, nil, "status"},
+	}
+	for _, c := range cases {
+		if reason := c.r.retryable(c.method, c.code, c.err); reason != c.reason {
+			t.Errorf("%s %d %v should be retried by [%s]: [%s]", c.method, c.code, c.err, c.reason, reason)
+		}
+	}
+}
@@ -3,35 +3,65 @@ package http
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 // SettingProvider provides endpoint settings for a given uri target.
+// Handler set by SetHandler is called with target and the changed setting
+// whenever the provider sees a change.
 type SettingProvider interface {
 	GetSettings(target string) (map[string]EndpointSetting, error)
-	SetHandler(handler func(setting EndpointSetting) error)
+	SetHandler(handler func(target string, setting EndpointSetting) error)
 }
 
+const defaultWatchInterval = 5 * time.Second
+
 type settingHolder struct {
 	Settings map[string][]EndpointSetting
 }
 
-// FileSettingProvider implements a file-based provider.
+// FileSettingProvider implements a file-based provider, the file is watched
+// for changes once Watch is called.
 type FileSettingProvider struct {
 	path       string
 	settingMap map[string]map[string]EndpointSetting
+	handler    func(target string, setting EndpointSetting) error
+	modTime    time.Time
+	size       int64
+	mutex      *sync.RWMutex
+	stop       chan struct{}
 }
 
 // NewFileSettingProvider creates a new setting provider with given yaml file.
 func NewFileSettingProvider(path string) (*FileSettingProvider, error) {
 	p := &FileSettingProvider{
-		path: path,
+		path:  path,
+		mutex: &sync.RWMutex{},
+	}
+
+	settingMap, err := p.load()
+	if err != nil {
+		logger.Errorf("fail to load setting from %s", path)
+		return nil, err
+	}
+	p.settingMap = settingMap
+
+	return p, nil
+}
+
+func (p *FileSettingProvider) load() (map[string]map[string]EndpointSetting, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
 	}
 
-	data, err := ioutil.ReadFile(path)
+	data, err := ioutil.ReadFile(p.path)
 	if err != nil {
-		logger.Error("fail to load setting from %s", path)
 		return nil, err
 	}
 
@@ -43,32 +73,135 @@ func NewFileSettingProvider(path string) (*FileSettingProvider, error) {
 
 	settingMap := make(map[string]map[string]EndpointSetting)
 	for target, settingsByTarget := range holder.Settings {
-		epMap := make(map[string]EndpointSetting)
-		for _, setting := range settingsByTarget {
-			key := fmt.Sprintf("%s-%s", setting.Method, setting.URI)
-			epMap[key] = setting
-		}
-
-		settingMap[target] = epMap
+		settingMap[target] = settingsByKey(settingsByTarget)
 	}
 
-	p.settingMap = settingMap
-
-	return p, nil
+	p.modTime = info.ModTime()
+	p.size = info.Size()
 
+	return settingMap, nil
 }
 
 // GetSettings implements SettingProvider.GetSettings
-func (p FileSettingProvider) GetSettings(target string) (map[string]EndpointSetting, error) {
-	epMap, ok := p.settingMap[target]
-	if !ok {
-		epMap = make(map[string]EndpointSetting)
+func (p *FileSettingProvider) GetSettings(target string) (map[string]EndpointSetting, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	epMap := make(map[string]EndpointSetting)
+	for key, setting := range p.settingMap[target] {
+		epMap[key] = setting
 	}
 
 	return epMap, nil
 }
 
 // SetHandler implements SettingProvider.SetHandler
-func (p FileSettingProvider) SetHandler(handler func(setting EndpointSetting) error) {
+func (p *FileSettingProvider) SetHandler(handler func(target string, setting EndpointSetting) error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.handler = handler
+}
+
+// Watch starts polling the file with given interval, changed or added
+// settings are passed to the handler
+func (p *FileSettingProvider) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	p.mutex.Lock()
+	if p.stop != nil {
+		p.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	p.stop = stop
+	p.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.Reload()
+			}
+		}
+	}()
+}
 
+// StopWatch stops watching the file
+func (p *FileSettingProvider) StopWatch() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// Reload reloads the file if it's changed, and notifies the handler of
+// changed or added settings. Removed settings are kept by live clients.
+func (p *FileSettingProvider) Reload() {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		logger.Errorf("[Setting] fail to stat %s: %v", p.path, err)
+		return
+	}
+
+	p.mutex.RLock()
+	unchanged := info.ModTime().Equal(p.modTime) && info.Size() == p.size
+	p.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	p.mutex.Lock()
+	settingMap, err := p.load()
+	if err != nil {
+		p.mutex.Unlock()
+		logger.Errorf("[Setting] fail to reload %s, keep current settings: %v", p.path, err)
+		return
+	}
+	old := p.settingMap
+	p.settingMap = settingMap
+	handler := p.handler
+	p.mutex.Unlock()
+
+	logger.Infof("[Setting] reloaded from %s", p.path)
+	notifyChanges(handler, old, settingMap)
+}
+
+func settingsByKey(settings []EndpointSetting) map[string]EndpointSetting {
+	epMap := make(map[string]EndpointSetting)
+	for _, setting := range settings {
+		key := fmt.Sprintf("%s-%s", setting.Method, setting.URI)
+		epMap[key] = setting
+	}
+	return epMap
+}
+
+// notifyChanges calls handler with settings changed or added in current
+func notifyChanges(handler func(string, EndpointSetting) error, old, current map[string]map[string]EndpointSetting) {
+	if handler == nil {
+		return
+	}
+
+	for target, epMap := range current {
+		for key, setting := range epMap {
+			if prev, ok := old[target][key]; ok && reflect.DeepEqual(prev, setting) {
+				continue
+			}
+
+			logger.Infof("[Setting] %s %s changed", target, key)
+			if err := handler(target, setting); err != nil {
+				logger.Errorf("[Setting] fail to apply %s %s: %v", target, key, err)
+			}
+		}
+	}
 }
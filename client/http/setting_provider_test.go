@@ -1,12 +1,18 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/afex/hystrix-go/hystrix"
+
+	"github.com/butters-mars/tiki/client/http/middleware"
 )
 
 type ES struct {
@@ -68,3 +74,161 @@ settings:
 // RequestVolumeThreshold int `json:"request_volume_threshold"`
 // SleepWindow            int `json:"sleep_window"`
 // ErrorPercentThreshold  int `json:"error_percent_threshold"`
+
+func TestSettingReload(t *testing.T) {
+	fn := fmt.Sprintf("/tmp/reload-%d", time.Now().UnixNano())
+	write := func(timeout int) {
+		str := fmt.Sprintf(`
+settings:
+ test.srv.ns:
+  - uri: /a
+    method: GET
+    hystrix:
+     timeout: %d
+  - uri: /b
+    method: GET
+    hystrix:
+     timeout: 100
+`, timeout)
+		if err := ioutil.WriteFile(fn, []byte(str), 0644); err != nil {
+			t.Fatalf("fail to write file: %v", err)
+		}
+	}
+
+	write(100)
+	p, err := NewFileSettingProvider(fn)
+	if err != nil {
+		t.Fatalf("fail to create provider %v", err)
+	}
+
+	changed := make(map[string]EndpointSetting)
+	p.SetHandler(func(target string, setting EndpointSetting) error {
+		changed[target+setting.URI] = setting
+		return nil
+	})
+
+	p.Reload()
+	if len(changed) != 0 {
+		t.Fatalf("handler called without change: %v", changed)
+	}
+
+	write(2000)
+	p.Reload()
+	if len(changed) != 1 || changed["test.srv.ns/a"].CBConfig.Timeout != 2000 {
+		t.Fatalf("expect only /a changed, got %v", changed)
+	}
+
+	s, _ := p.GetSettings("test.srv.ns")
+	if s["GET-/a"].CBConfig.Timeout != 2000 {
+		t.Errorf("setting not reloaded: %v", s)
+	}
+}
+
+func TestSetEndpointSetting(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	err := cl.SetEndpointSetting(&EndpointSetting{
+		URI:      "/slow",
+		Method:   "GET",
+		CBConfig: hystrix.CommandConfig{Timeout: 5},
+	})
+	if err != nil {
+		t.Fatalf("fail to set setting: %v", err)
+	}
+
+	resp := make(map[string]interface{})
+	if err = cl.Do(context.TODO(), "/slow", "GET", nil, &resp); err == nil {
+		t.Fatal("should time out with 5ms timeout")
+	}
+
+	ec := cl.endpoints["GET-/slow"]
+	err = cl.SetEndpointSettings([]*EndpointSetting{{
+		URI:      "/slow",
+		Method:   "GET",
+		CBConfig: hystrix.CommandConfig{Timeout: 1000},
+	}})
+	if err != nil {
+		t.Fatalf("fail to set settings: %v", err)
+	}
+	if cl.endpoints["GET-/slow"] != ec {
+		t.Error("endpoint client should be updated in place")
+	}
+
+	if err = cl.Do(context.TODO(), "/slow", "GET", nil, &resp); err != nil {
+		t.Errorf("should succeed after timeout raised: %v", err)
+	}
+}
+
+func TestApplySetting(t *testing.T) {
+	host := fmt.Sprintf("apply-%d", time.Now().UnixNano())
+	c1 := NewClientWithSD(host, false)
+	c2 := NewClientWithSD(host, false)
+	defer c1.Close()
+
+	err := applySetting(host, EndpointSetting{URI: "/a", Method: "GET", LBType: "bad"})
+	if err == nil || !strings.Contains(err.Error(), "2 of 2 clients") {
+		t.Errorf("should try all clients and return errors together: %v", err)
+	}
+
+	c2.Close()
+	liveClientsMutex.RLock()
+	_, found := liveClients[host][c2.(DefaultClient).id]
+	liveClientsMutex.RUnlock()
+	if found {
+		t.Errorf("closed client should be unregistered")
+	}
+	if err = applySetting(host, EndpointSetting{URI: "/a", Method: "GET", LBType: "bad"}); err == nil ||
+		!strings.Contains(err.Error(), "1 of 1 clients") {
+		t.Errorf("closed client should not get settings: %v", err)
+	}
+}
+
+func TestBulkheadChangeKeepsOtherCircuits(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer failing.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer ok.Close()
+
+	failingHost := strings.TrimPrefix(failing.URL, "http://")
+	cl := NewClientWithSD(failingHost, false)
+	defer cl.Close()
+	cl.(DefaultClient).SetEndpointSetting(&EndpointSetting{
+		URI:      "/fail",
+		Method:   "GET",
+		CBConfig: hystrix.CommandConfig{Timeout: 5, RequestVolumeThreshold: 2},
+	})
+	resp := make(map[string]interface{})
+	for i := 0; i < 5; i++ {
+		cl.Do(context.TODO(), "/fail", "GET", nil, &resp)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if open, _ := middleware.CountCircuits(failingHost); open != 1 {
+		t.Fatalf("circuit should be open: %d", open)
+	}
+
+	okHost := strings.TrimPrefix(ok.URL, "http://")
+	other := NewClientWithSD(okHost, false).(DefaultClient)
+	defer other.Close()
+	other.SetEndpointSetting(&EndpointSetting{URI: "/ok", Method: "GET", CBConfig: hystrix.CommandConfig{MaxConcurrentRequests: 10}})
+	if err := other.Do(context.TODO(), "/ok", "GET", nil, &resp); err != nil {
+		t.Fatalf("fail to call: %v", err)
+	}
+	other.SetEndpointSetting(&EndpointSetting{URI: "/ok", Method: "GET", CBConfig: hystrix.CommandConfig{MaxConcurrentRequests: 20}})
+	if err := other.Do(context.TODO(), "/ok", "GET", nil, &resp); err != nil {
+		t.Errorf("should call with new bulkhead: %v", err)
+	}
+
+	if open, _ := middleware.CountCircuits(failingHost); open != 1 {
+		t.Errorf("circuits of other upstreams should be kept: %d", open)
+	}
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const bodySnippetSize = 512
+
+var (
+	defaultSuccessStatus = []string{"2xx"}
+	defaultFailureStatus = []string{"5xx"}
+)
+
+// StatusPolicy decides how response status codes are treated, a status is
+// either a code like "404" or a class like "5xx". Retryable statuses are
+// set by Retry.RetryOnStatus.
+type StatusPolicy struct {
+	Success []string `yaml:"success"` // returned as success, default 2xx, others are returned as *HTTPError
+	Failure []string `yaml:"failure"` // counted as failures by hystrix, default 5xx
+}
+
+// HTTPError is returned for responses whose status is not a success
+type HTTPError struct {
+	StatusCode int
+	Body       string // leading bytes of the body
+	Addr       string // address of the upstream instance
+	URI        string
+	Method     string
+
+	resp *Response
+}
+
+func newHTTPError(resp *Response, uri, method string) *HTTPError {
+	body := resp.Body
+	if len(body) > bodySnippetSize {
+		body = body[:bodySnippetSize]
+	}
+
+	return &HTTPError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		Addr:       resp.Addr,
+		URI:        uri,
+		Method:     method,
+		resp:       resp,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http status %d from %s %s %s: %s", e.StatusCode, e.Addr, e.Method, e.URI, e.Body)
+}
+
+// HTTPStatus returns the status code
+func (e *HTTPError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// Response returns the whole response
+func (e *HTTPError) Response() *Response {
+	return e.resp
+}
+
+// statusMatcher matches status codes by codes and classes
+type statusMatcher struct {
+	codes   map[int]bool
+	classes map[int]bool
+}
+
+func newStatusMatcher(patterns []string) *statusMatcher {
+	m := &statusMatcher{
+		codes:   make(map[int]bool),
+		classes: make(map[int]bool),
+	}
+
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if len(p) == 3 && strings.HasSuffix(p, "xx") {
+			if class, err := strconv.Atoi(p[:1]); err == nil {
+				m.classes[class] = true
+				continue
+			}
+		}
+		if code, err := strconv.Atoi(p); err == nil {
+			m.codes[code] = true
+			continue
+		}
+		logger.Warnf("[Status] bad status %s, ignored", p)
+	}
+
+	return m
+}
+
+func (m *statusMatcher) match(code int) bool {
+	return m.codes[code] || m.classes[code/100]
+}
+
+// statusPolicy applies StatusPolicy of an endpoint
+type statusPolicy struct {
+	success *statusMatcher
+	failure *statusMatcher
+}
+
+func newStatusPolicy(policy *StatusPolicy) *statusPolicy {
+	success, failure := defaultSuccessStatus, defaultFailureStatus
+	if policy != nil {
+		if len(policy.Success) > 0 {
+			success = policy.Success
+		}
+		if len(policy.Failure) > 0 {
+			failure = policy.Failure
+		}
+	}
+
+	return &statusPolicy{
+		success: newStatusMatcher(success),
+		failure: newStatusMatcher(failure),
+	}
+}
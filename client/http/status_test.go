@@ -0,0 +1,94 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+
+	"github.com/butters-mars/tiki/client/http/middleware"
+)
+
+func TestStatusMatcher(t *testing.T) {
+	m := newStatusMatcher([]string{"2xx", "404", "bad"})
+	for code, expect := range map[int]bool{200: true, 204: true, 404: true, 400: false, 500: false} {
+		if m.match(code) != expect {
+			t.Errorf("match(%d) should be %v", code, expect)
+		}
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/html":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("<html>" + strings.Repeat("x", 1024) + "</html>"))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"ok":false}`))
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+
+	resp := make(map[string]interface{})
+	err := cl.Do(context.TODO(), "/html", "GET", nil, &resp)
+	httpErr, ok := err.(*HTTPError)
+	if !ok {
+		t.Fatalf("should be *HTTPError: %v", err)
+	}
+	if httpErr.StatusCode != 500 || httpErr.Addr != host || httpErr.URI != "/html" ||
+		len(httpErr.Body) != bodySnippetSize || len(httpErr.Response().Body) != 1024+13 {
+		t.Errorf("wrong error: %+v", httpErr)
+	}
+
+	_, code, err := cl.DoRaw(context.TODO(), "/missing", "GET", nil)
+	if _, ok := err.(*HTTPError); !ok || code != 404 {
+		t.Errorf("404 should be an error by default: %d %v", code, err)
+	}
+
+	cl.SetEndpointSetting(&EndpointSetting{
+		URI:    "/missing",
+		Method: "GET",
+		Status: &StatusPolicy{Success: []string{"2xx", "404"}},
+	})
+	if err = cl.Do(context.TODO(), "/missing", "GET", nil, &resp); err != nil || resp["ok"] != false {
+		t.Errorf("404 should be a success: %v %v", err, resp)
+	}
+}
+
+func TestFailureStatusTripsCircuit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+	cl.SetEndpointSetting(&EndpointSetting{
+		URI:    "/unavailable",
+		Method: "GET",
+		CBConfig: hystrix.CommandConfig{
+			RequestVolumeThreshold: 3,
+			ErrorPercentThreshold:  50,
+		},
+	})
+
+	for i := 0; i < 5; i++ {
+		cl.DoRaw(context.TODO(), "/unavailable", "GET", nil)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	key := fmt.Sprintf("%s-%s-%s", host, "/unavailable", "GET")
+	if open, ok := middleware.IsCircuitOpen(key); !ok || !open {
+		t.Errorf("circuit should be open after 503s: %v %v", open, ok)
+	}
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/butters-mars/tiki/utils"
+)
+
+const (
+	// TagHTTPS is the consul tag of instances serving https
+	TagHTTPS = "https"
+
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+)
+
+// TLSSetting tls settings of upstream, certificates are reloaded when files change
+type TLSSetting struct {
+	CAFile             string `yaml:"ca_file"`              // CA bundle, default system roots
+	CertFile           string `yaml:"cert_file"`            // client certificate for mTLS
+	KeyFile            string `yaml:"key_file"`             // client key for mTLS
+	ServerName         string `yaml:"server_name"`          // SNI and name to verify, default target host
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // do not verify server certificate
+	ReloadInterval     int    `yaml:"reload_interval"`      // seconds between checks of file changes, default 30
+}
+
+// newTLSDialer returns a tls dial function using current certificates for each connection
+func newTLSDialer(dialer *net.Dialer, host string, setting *TLSSetting) (func(network, addr string) (net.Conn, error), error) {
+	if setting == nil {
+		setting = &TLSSetting{}
+	}
+
+	reloader, err := utils.NewCertReloader(setting.CAFile, setting.CertFile, setting.KeyFile,
+		time.Duration(setting.ReloadInterval)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	serverName := setting.ServerName
+	if serverName == "" {
+		serverName = host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			serverName = h
+		}
+	}
+	if setting.InsecureSkipVerify {
+		logger.Warnf("[TLS] certificate verification of %s is disabled", host)
+	}
+
+	return func(network, addr string) (net.Conn, error) {
+		cfg := reloader.ClientConfig(serverName, setting.InsecureSkipVerify)
+		return tls.DialWithDialer(dialer, network, addr, cfg)
+	}, nil
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/butters-mars/tiki/internal/testcerts"
+)
+
+func TestTLSUpstream(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+
+	ca := testcerts.NewCA(t, dir)
+	serverCert, serverKey := ca.Issue(t, "server", "tiki.test")
+	clientCert, clientKey := ca.Issue(t, "client")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	serverPair, _ := tls.LoadX509KeyPair(serverCert, serverKey)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	cl := NewClientWithSD(host, false).(DefaultClient)
+
+	cases := []struct {
+		name    string
+		tls     *TLSSetting
+		success bool
+	}{
+		{"system roots", nil, false},
+		{"no client cert", &TLSSetting{CAFile: ca.CertFile, ServerName: "tiki.test"}, false},
+		{"wrong server name", &TLSSetting{CAFile: ca.CertFile, CertFile: clientCert, KeyFile: clientKey}, false},
+		{"mtls", &TLSSetting{CAFile: ca.CertFile, CertFile: clientCert, KeyFile: clientKey, ServerName: "tiki.test"}, true},
+	}
+
+	for _, c := range cases {
+		err := cl.SetEndpointSetting(&EndpointSetting{URI: "/" + strings.Replace(c.name, " ", "_", -1), Method: "GET", Scheme: "https", TLS: c.tls})
+		if err != nil {
+			t.Errorf("%s: fail to set setting: %v", c.name, err)
+			continue
+		}
+
+		resp, err := cl.Request(context.TODO(), "/"+strings.Replace(c.name, " ", "_", -1), "GET", nil)
+		if c.success && (err != nil || string(resp.Body) != "client") {
+			t.Errorf("%s: should succeed: %v", c.name, err)
+		}
+		if !c.success && err == nil {
+			t.Errorf("%s: should fail", c.name)
+		}
+	}
+
+	if err := cl.SetEndpointSetting(&EndpointSetting{URI: "/bad", Method: "GET", TLS: &TLSSetting{CertFile: clientCert}}); err == nil {
+		t.Error("cert without key should be rejected")
+	}
+}
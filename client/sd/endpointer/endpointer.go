@@ -2,10 +2,14 @@ package endpointer
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"sync"
 
 	consul "github.com/hashicorp/consul/api"
 	"github.com/butters-mars/tiki/client/sd/instancer"
 	"github.com/butters-mars/tiki/logging"
+	tikisd "github.com/butters-mars/tiki/sd"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/sd"
@@ -20,6 +24,8 @@ type SDType string
 const (
 	// SDTypeConsul consul
 	SDTypeConsul SDType = "consul"
+	// SDTypeFile registry file of sd.FileRegisteror
+	SDTypeFile SDType = "file"
 	// SDTypeNone no service discovery
 	SDTypeNone SDType = "none"
 )
@@ -28,20 +34,22 @@ const (
 type WithTag interface {
 	sd.Endpointer
 	GetTagMap() map[string][]string
+	// Stop stops watching service discovery
+	Stop()
 }
 
 type consulEndpointer struct {
 	consulClient *consul.Client
 	sdClient     csd.Client
 	instancer    *instancer.Instancer
-	endpointer   sd.Endpointer
+	endpointer   *sd.DefaultEndpointer
 }
 
 type sdLogger struct {
 }
 
 func (l sdLogger) Log(keyvals ...interface{}) error {
-	logger.Info("[Endpointer] %v", keyvals)
+	logger.Infof("[Endpointer] %v", keyvals)
 	return nil
 }
 
@@ -93,12 +101,18 @@ func (r consulEndpointer) GetTagMap() (tagMap map[string][]string) {
 	return r.instancer.GetTagMap()
 }
 
+func (r consulEndpointer) Stop() {
+	r.endpointer.Close()
+	r.instancer.Stop()
+}
+
 type fixedEndpointer struct {
 	instancer  sd.Instancer
-	endpointer sd.Endpointer
+	endpointer *sd.DefaultEndpointer
 }
 
 func (f fixedEndpointer) GetTagMap() (tagMap map[string][]string) { return }
+func (f fixedEndpointer) Stop()                                    { f.endpointer.Close() }
 func (f fixedEndpointer) Endpoints() (eps []endpoint.Endpoint, err error) {
 	eps, err = f.endpointer.Endpoints()
 	return
@@ -116,3 +130,67 @@ func NewDirectEndpointer(host string, sdFactory sd.Factory) (endpointer WithTag,
 
 	return
 }
+
+type fileEndpointer struct {
+	watcher    *tikisd.FileWatcher
+	cache      *instancer.Cache
+	endpointer *sd.DefaultEndpointer
+
+	mutex  *sync.RWMutex
+	tagMap map[string][]string
+}
+
+// NewFileEndpointer creates an endpointer of the service by watching the registry file
+func NewFileEndpointer(path string, sdFactory sd.Factory, service string) (epr WithTag, err error) {
+	if path == "" {
+		path = tikisd.DefaultFilePath
+	}
+
+	f := &fileEndpointer{
+		cache:  instancer.NewCache(),
+		mutex:  &sync.RWMutex{},
+		tagMap: make(map[string][]string),
+	}
+	f.watcher = tikisd.WatchFile(path, service, 0, f.update)
+	f.endpointer = sd.NewEndpointer(f.cache, sdFactory, sdLogger{}, options...)
+
+	epr = f
+	return
+}
+
+func (f *fileEndpointer) update(entries []tikisd.FileEntry, err error) {
+	if err != nil {
+		f.cache.Update(sd.Event{Err: err})
+		return
+	}
+
+	instances := make([]string, 0, len(entries))
+	tagMap := make(map[string][]string)
+	for _, e := range entries {
+		addr := net.JoinHostPort(e.Address, strconv.Itoa(e.Port))
+		instances = append(instances, addr)
+		tagMap[addr] = e.Tags
+	}
+
+	f.mutex.Lock()
+	f.tagMap = tagMap
+	f.mutex.Unlock()
+	f.cache.Update(sd.Event{Instances: instances})
+}
+
+func (f *fileEndpointer) Endpoints() (eps []endpoint.Endpoint, err error) {
+	eps, err = f.endpointer.Endpoints()
+	return
+}
+
+func (f *fileEndpointer) GetTagMap() (tagMap map[string][]string) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return f.tagMap
+}
+
+func (f *fileEndpointer) Stop() {
+	f.endpointer.Close()
+	f.watcher.Stop()
+}
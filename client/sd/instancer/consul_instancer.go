@@ -33,10 +33,12 @@ type Instancer struct {
 	// NOTE
 	// add these to support tagging instances with "prod", "stg", etc.
 	tagMap map[string][]string
+	// service meta of instances, e.g. version, zone
+	metaMap map[string]map[string]string
 }
 
 // Listener handles update events
-type Listener func(instances []string, tags map[string][]string, err error)
+type Listener func(instances []string, tags map[string][]string, meta map[string]map[string]string, err error)
 
 // NewInstancer returns a Consul instancer that publishes instances for the
 // requested service. It only returns instances for which all of the passed tags
@@ -51,6 +53,7 @@ func NewInstancer(client csd.Client, logger log.Logger, service string, tags []s
 		passingOnly: passingOnly,
 		quitc:       make(chan struct{}),
 		tagMap:      make(map[string][]string),
+		metaMap:     make(map[string]map[string]string),
 		listener:    listener,
 	}
 
@@ -63,7 +66,7 @@ func NewInstancer(client csd.Client, logger log.Logger, service string, tags []s
 
 	s.cache.Update(sd.Event{Instances: instances, Err: err})
 	if s.listener != nil {
-		s.listener(instances, s.tagMap, err)
+		s.listener(instances, s.tagMap, s.metaMap, err)
 	}
 	go s.loop(index)
 	return s
@@ -96,13 +99,13 @@ func (s *Instancer) loop(lastIndex uint64) {
 			d *= 2
 			s.cache.Update(sd.Event{Err: err})
 			if s.listener != nil {
-				s.listener(instances, s.tagMap, err)
+				s.listener(instances, s.tagMap, s.metaMap, err)
 			}
 		default:
 			s.cache.Update(sd.Event{Instances: instances})
 			d = 10 * time.Millisecond
 			if s.listener != nil {
-				s.listener(instances, s.tagMap, nil)
+				s.listener(instances, s.tagMap, s.metaMap, nil)
 			}
 		}
 	}
@@ -142,21 +145,30 @@ func (s *Instancer) getInstances(lastIndex uint64, interruptc chan struct{}) ([]
 			entries = filterEntries(entries, s.tags[1:]...)
 		}
 
-		// set tags
+		// set tags & meta
 		tagMap := make(map[string][]string)
+		metaMap := make(map[string]map[string]string)
 		instances := makeInstances(entries)
 		for i, entry := range entries {
 			tags := make([]string, 0)
+			meta := make(map[string]string)
 			if entry.Service != nil && entry.Service.Tags != nil {
 				for _, tag := range entry.Service.Tags {
 					tags = append(tags, tag)
 				}
 			}
+			if entry.Service != nil && entry.Service.Meta != nil {
+				for k, v := range entry.Service.Meta {
+					meta[k] = v
+				}
+			}
 
 			tagMap[instances[i]] = tags
+			metaMap[instances[i]] = meta
 		}
 		s.tagMap = tagMap
-		logger.Infof("[Instancer] update tag map of %s: %v", s.service, tagMap)
+		s.metaMap = metaMap
+		logger.Infof("[Instancer] update tag map of %s: %v, meta map: %v", s.service, tagMap, metaMap)
 
 		resc <- response{
 			instances: instances,
@@ -189,6 +201,11 @@ func (s *Instancer) GetTagMap() map[string][]string {
 	return s.tagMap
 }
 
+// GetMetaMap returns service meta of instances as map
+func (s *Instancer) GetMetaMap() map[string]map[string]string {
+	return s.metaMap
+}
+
 func filterEntries(entries []*consul.ServiceEntry, tags ...string) []*consul.ServiceEntry {
 	var es []*consul.ServiceEntry
 
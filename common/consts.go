@@ -2,3 +2,16 @@ package common
 
 // KeyUID key type for user id in metedata
 type KeyUID struct{}
+
+// KeyIdentity key type for authenticated identity of the caller, e.g. service or api key name
+type KeyIdentity struct{}
+
+// KeySPIFFEID key type for SPIFFE ID of the verified peer certificate
+type KeySPIFFEID struct{}
+
+// KeyRequestID key type for request id of the call
+type KeyRequestID struct{}
+
+// HealthService is the method prefix of grpc.health.v1.Health, whose calls are let through
+// by auth, authz and rate limit
+const HealthService = "/grpc.health.v1.Health/"
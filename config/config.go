@@ -13,20 +13,49 @@ type Config struct {
 	ServiceDiscovery ServiceDiscoveryCfg      `yaml:"service-discovery"`
 	Auth             *AuthConfig              `yaml:"auth"`
 	UpstreamSetting  string                   `yaml:"upstream-setting"`
+	GRPCConfig       map[string]string        `yaml:"grpc-service-config"` // grpc service config json by target
+	RateLimit        string                   `yaml:"rate-limit"`          // yaml file of rate limits, reloaded when changed
+	AuthPolicy       string                   `yaml:"auth-policy"`         // yaml file of per-method authz rules, reloaded when changed
+	Baggage          []string                 `yaml:"baggage"`             // keys propagated across hops, default x-uid, x-request-id, x-locale and x-tenant-id
+	Shutdown         ShutdownCfg              `yaml:"shutdown"`
 	Properties       map[string]string        `yaml:"props"`
 }
 
+// ShutdownCfg provides config of graceful shutdown, all values are in seconds
+type ShutdownCfg struct {
+	GracePeriod int `yaml:"graceperiod"` // wait after unregistering before draining, default is 5
+	Timeout     int `yaml:"timeout"`     // max time to drain in-flight calls before force stop, default is 10
+}
+
 // ServiceDiscoveryCfg provides config of service discovery
 type ServiceDiscoveryCfg struct {
-	Type   string            `yaml:"type"` // consul or direct, default is direct
+	Type   string            `yaml:"type"` // consul, direct or file, default is direct
 	Consul *consulapi.Config `yaml:"consul"`
+	File   string            `yaml:"file"` // registry file used by file type
+	Check  SvcCheckCfg       `yaml:"check"`
+	Tags   []string          `yaml:"tags"` // registration tags, e.g. stg, weight_20
+	Meta   map[string]string `yaml:"meta"` // registration metadata, e.g. version, zone, git_sha
+}
+
+// SvcCheckCfg provides config of health check and registration maintaining, all values are in seconds
+type SvcCheckCfg struct {
+	Type             string `yaml:"type"`             // http, ttl or grpc, default is http
+	TTL              int    `yaml:"ttl"`              // heartbeat deadline of ttl check
+	DeregisterAfter  int    `yaml:"deregisterafter"`  // reap the instance after critical for this long, 0 means never
+	MaintainInterval int    `yaml:"maintaininterval"` // re-register check period, 0 means default, negative disables it
 }
 
-// AuthConfig provides auth configuration
+// AuthConfig provides auth configuration, certificates are reloaded when files change
 type AuthConfig struct {
-	TLS      bool   `yaml:"tls"`
-	CertFile string `yaml:"cert"`
-	KeyFile  string `yaml:"key"`
+	TLS            bool   `yaml:"tls"`
+	CertFile       string `yaml:"cert"`
+	KeyFile        string `yaml:"key"`
+	CAFile         string `yaml:"ca"`             // CA bundle verifying client certs and upstream servers, default system roots
+	ClientAuth     string `yaml:"clientauth"`     // none, request or require, default is require if ca is given, none otherwise
+	TrustDomain    string `yaml:"trustdomain"`    // SPIFFE IDs of client certs in the trust domain and namespace are reduced to service names
+	Namespace      string `yaml:"namespace"`      // namespace of SPIFFE IDs, callers of other IDs are identified by the full ID
+	ClientTLS      bool   `yaml:"clienttls"`      // dial grpc upstreams by tls with the key pair as client cert, unless set in upstream setting
+	ReloadInterval int    `yaml:"reloadinterval"` // seconds between checks of file changes, default 30
 }
 
 // ServiceDiscoverySt defines config for consul discovery
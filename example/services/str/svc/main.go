@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"log"
 
 	"github.com/butters-mars/tiki/auth"
 
@@ -13,13 +14,17 @@ import (
 
 // App the app instance
 var (
-	App     *app.App
+	App     app.App
 	cfgName = flag.String("config", "config", "config file name")
 )
 
 func main() {
 	App = app.New(*cfgName)
-	App.AuthFunc = auth.TokenAuth
+	provider, err := auth.NewAPIKeyProviderFromFile("apikeys.yaml")
+	if err != nil {
+		log.Fatalf("fail to load api keys: %v", err)
+	}
+	App.SetAuthFunc(auth.AuthFunc(provider))
 	App.RegisterGRPCServer(func(s *grpc.Server) {
 		svcdef.RegisterStringServer(s, &impl.StrSrv{})
 	})
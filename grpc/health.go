@@ -0,0 +1,106 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/butters-mars/tiki/common"
+	"github.com/butters-mars/tiki/healthcheck"
+)
+
+var healthServiceName = strings.Trim(common.HealthService, "/")
+
+var (
+	healthServers = make(map[*grpc.Server]registeredHealth)
+	healthMutex   = sync.RWMutex{}
+)
+
+// registeredHealth is health server of a grpc server and remover of its offline listener
+type registeredHealth struct {
+	hs     *health.Server
+	remove func()
+}
+
+// readinessHealthServer reports NOT_SERVING on Check if healthcheck readiness fails, e.g. a
+// critical dependency is down, so that registries checking by grpc stop routing to it
+type readinessHealthServer struct {
+	*health.Server
+}
+
+// Check implements healthpb.HealthServer
+func (h readinessHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	resp, err := h.Server.Check(ctx, req)
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return resp, err
+	}
+	if report := healthcheck.Ready(ctx); report.Status != healthcheck.StatusUp {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+	}
+	return resp, nil
+}
+
+// registerHealthServer registers grpc.health.v1.Health service to the server, serving
+// status of all services is flipped with healthcheck offline mode, and checked against
+// healthcheck readiness on Check
+func registerHealthServer(s *grpc.Server) {
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(s, readinessHealthServer{hs})
+
+	remove := healthcheck.OnChange(func(offline bool) {
+		updateHealthStatus(s, hs, offline)
+	})
+
+	healthMutex.Lock()
+	healthServers[s] = registeredHealth{hs: hs, remove: remove}
+	healthMutex.Unlock()
+}
+
+// RemoveHealthStatus stops following healthcheck offline mode for the server, it should be
+// called after the server is stopped
+func RemoveHealthStatus(s *grpc.Server) {
+	healthMutex.Lock()
+	registered, ok := healthServers[s]
+	delete(healthServers, s)
+	healthMutex.Unlock()
+
+	if ok {
+		registered.remove()
+	}
+}
+
+// UpdateHealthStatus sets serving status for every service registered to the
+// server, it should be called after all services registered
+func UpdateHealthStatus(s *grpc.Server) {
+	healthMutex.RLock()
+	registered, ok := healthServers[s]
+	healthMutex.RUnlock()
+
+	if !ok {
+		logger.Warnf("[grpc] health service not registered")
+		return
+	}
+
+	updateHealthStatus(s, registered.hs, healthcheck.IsOffline())
+}
+
+func updateHealthStatus(s *grpc.Server, hs *health.Server, offline bool) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if offline {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	// empty name stands for the overall health of the server
+	hs.SetServingStatus("", status)
+	for name := range s.GetServiceInfo() {
+		if name == healthServiceName {
+			continue
+		}
+		hs.SetServingStatus(name, status)
+		logger.Infof("[grpc] health of %s set to %v", name, status)
+	}
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/butters-mars/tiki/healthcheck"
+)
+
+func TestHealthServer(t *testing.T) {
+	defer healthcheck.SetOffline(false)
+	defer healthcheck.RemoveCheck("db")
+
+	s := grpc.NewServer()
+	registerHealthServer(s)
+	UpdateHealthStatus(s)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	check := func() healthpb.HealthCheckResponse_ServingStatus {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("fail to check: %v", err)
+		}
+		return resp.Status
+	}
+
+	if status := check(); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("should be serving: %v", status)
+	}
+
+	healthcheck.SetOffline(true)
+	if status := check(); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("should not be serving when offline: %v", status)
+	}
+	healthcheck.SetOffline(false)
+	if status := check(); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("should be serving when back online: %v", status)
+	}
+
+	healthcheck.AddCheck("db", func(ctx context.Context) error { return errors.New("down") }, 0, healthcheck.LevelCritical)
+	if status := check(); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("should not be serving when not ready: %v", status)
+	}
+}
+
+func TestRemoveHealthStatus(t *testing.T) {
+	defer healthcheck.SetOffline(false)
+
+	s := grpc.NewServer()
+	registerHealthServer(s)
+	healthMutex.RLock()
+	hs := healthServers[s].hs
+	healthMutex.RUnlock()
+
+	RemoveHealthStatus(s)
+	healthMutex.RLock()
+	_, ok := healthServers[s]
+	healthMutex.RUnlock()
+	if ok {
+		t.Errorf("health server should be removed")
+	}
+
+	healthcheck.SetOffline(true)
+	resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("removed server should not follow offline mode: %v %v", resp, err)
+	}
+}
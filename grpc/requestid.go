@@ -0,0 +1,47 @@
+package grpc
+
+import (
+	"context"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/butters-mars/tiki/logging"
+	"github.com/butters-mars/tiki/propagation"
+)
+
+// HeaderRequestID is the metadata key of request id in requests and response headers
+const HeaderRequestID = propagation.KeyRequestID
+
+// withRequestID accepts request id of the caller if valid, or generates one, the id is put
+// into context and ctxtags, so that it's logged and propagated to upstreams
+func withRequestID(ctx context.Context) (context.Context, string) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(HeaderRequestID); len(vals) > 0 {
+			ctx = propagation.WithRequestID(ctx, vals[0])
+		}
+	}
+	ctx, id := propagation.EnsureRequestID(ctx)
+	grpc_ctxtags.Extract(ctx).Set(logging.FieldRequestID, id)
+	return ctx, id
+}
+
+func unaryRequestID() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, id := withRequestID(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(HeaderRequestID, id))
+		return handler(ctx, req)
+	}
+}
+
+func streamRequestID() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, id := withRequestID(ss.Context())
+		ss.SetHeader(metadata.Pairs(HeaderRequestID, id))
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
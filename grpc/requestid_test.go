@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestID(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	check := func(kv ...string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+
+		var header metadata.MD
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header)); err != nil {
+			t.Fatal(err)
+		}
+		if vals := header.Get(HeaderRequestID); len(vals) == 1 {
+			return vals[0]
+		}
+		return ""
+	}
+
+	if id := check(HeaderRequestID, "req-1"); id != "req-1" {
+		t.Errorf("should accept request id of caller: %s", id)
+	}
+	generated := check()
+	if len(generated) != 32 || generated == check() {
+		t.Errorf("should generate unique request id: %s", generated)
+	}
+	if id := check(HeaderRequestID, "bad id "+strings.Repeat("x", 200)); id == "" || strings.Contains(id, " ") {
+		t.Errorf("should replace invalid request id: %s", id)
+	}
+}
@@ -12,60 +12,121 @@ import (
 	grpc_validator "github.com/grpc-ecosystem/go-grpc-middleware/validator"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 
+	"github.com/butters-mars/tiki/auth"
+	"github.com/butters-mars/tiki/authz"
 	"github.com/butters-mars/tiki/config"
 	"github.com/butters-mars/tiki/logging"
+	"github.com/butters-mars/tiki/propagation"
+	"github.com/butters-mars/tiki/ratelimit"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 )
 
 var (
 	logger = logging.L
 )
 
+// Option customizes the server
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	limiter *ratelimit.Limiter
+	policy  *authz.Policy
+}
+
+// WithPolicy authorizes calls by the policy after auth, unauthenticated calls of its public
+// methods are let through auth
+func WithPolicy(policy *authz.Policy) Option {
+	return func(o *serverOptions) {
+		o.policy = policy
+	}
+}
+
+// WithRateLimiter limits calls by the limiter after auth
+func WithRateLimiter(limiter *ratelimit.Limiter) Option {
+	return func(o *serverOptions) {
+		o.limiter = limiter
+	}
+}
+
 // NewServer creates a grpc server with middlewares setup
-func NewServer(logEntry *logrus.Entry, auth grpc_auth.AuthFunc, authCfg *config.AuthConfig) *grpc.Server {
+func NewServer(logEntry *logrus.Entry, authFunc grpc_auth.AuthFunc, authCfg *config.AuthConfig, opts ...Option) *grpc.Server {
+	options := &serverOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	if logEntry == nil {
 		logEntry = logrus.NewEntry(logger)
 	}
 
-	if auth == nil {
-		auth = func(ctx context.Context) (context.Context, error) {
+	if authFunc == nil {
+		authFunc = func(ctx context.Context) (context.Context, error) {
 			return ctx, nil
 		}
 	}
+	if options.policy != nil {
+		authFunc = options.policy.AuthFunc(authFunc)
+	}
 
 	srvOpts := make([]grpc.ServerOption, 0)
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_ctxtags.StreamServerInterceptor(),
+		grpc_opentracing.StreamServerInterceptor(),
+		grpc_prometheus.StreamServerInterceptor,
+		grpc_logrus.StreamServerInterceptor(logEntry),
+		propagation.StreamServerInterceptor(),
+		streamRequestID(),
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpc_ctxtags.UnaryServerInterceptor(),
+		grpc_opentracing.UnaryServerInterceptor(),
+		grpc_prometheus.UnaryServerInterceptor,
+		grpc_logrus.UnaryServerInterceptor(logEntry),
+		propagation.UnaryServerInterceptor(),
+		unaryRequestID(),
+	}
 	if authCfg != nil && authCfg.TLS {
-		logger.Infof("[grpc] using TLS for server, cert=[%s], key=[%s]", authCfg.CertFile, authCfg.KeyFile)
-		creds, err := credentials.NewServerTLSFromFile(authCfg.CertFile, authCfg.KeyFile)
+		logger.Infof("[grpc] using TLS for server, cert=[%s], key=[%s], ca=[%s], clientauth=[%s]",
+			authCfg.CertFile, authCfg.KeyFile, authCfg.CAFile, authCfg.ClientAuth)
+		creds, err := serverCredentials(authCfg)
 		if err != nil {
 			logger.Fatalf("[grpc] fail to create TLS server: %v", err)
 		}
 		srvOpts = append(srvOpts, grpc.Creds(creds))
+		// identity of client certificate is known before auth
+		streamInterceptors = append(streamInterceptors, streamPeerIdentity(authCfg))
+		unaryInterceptors = append(unaryInterceptors, unaryPeerIdentity(authCfg))
 	}
+	streamInterceptors = append(streamInterceptors, grpc_auth.StreamServerInterceptor(authFunc))
+	unaryInterceptors = append(unaryInterceptors,
+		grpc_auth.UnaryServerInterceptor(authFunc),
+		// signed calls are verified against the request message after auth
+		auth.UnaryContentDigestInterceptor())
+	if options.policy != nil {
+		streamInterceptors = append(streamInterceptors, options.policy.StreamServerInterceptor())
+		unaryInterceptors = append(unaryInterceptors, options.policy.UnaryServerInterceptor())
+	}
+	if options.limiter != nil {
+		streamInterceptors = append(streamInterceptors, options.limiter.StreamServerInterceptor())
+		unaryInterceptors = append(unaryInterceptors, options.limiter.UnaryServerInterceptor())
+	}
+	streamInterceptors = append(streamInterceptors,
+		grpc_recovery.StreamServerInterceptor(),
+		grpc_validator.StreamServerInterceptor(),
+	)
+	unaryInterceptors = append(unaryInterceptors,
+		grpc_recovery.UnaryServerInterceptor(),
+		grpc_validator.UnaryServerInterceptor(),
+	)
 
 	srvOpts = append(srvOpts,
-		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-			grpc_ctxtags.StreamServerInterceptor(),
-			grpc_opentracing.StreamServerInterceptor(),
-			grpc_prometheus.StreamServerInterceptor,
-			grpc_logrus.StreamServerInterceptor(logEntry),
-			grpc_auth.StreamServerInterceptor(auth),
-			grpc_recovery.StreamServerInterceptor(),
-			grpc_validator.StreamServerInterceptor(),
-		)),
-		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			grpc_opentracing.UnaryServerInterceptor(),
-			grpc_prometheus.UnaryServerInterceptor,
-			grpc_logrus.UnaryServerInterceptor(logEntry),
-			grpc_auth.UnaryServerInterceptor(auth),
-			grpc_recovery.UnaryServerInterceptor(),
-			grpc_validator.UnaryServerInterceptor(),
-		)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
 	)
-	return grpc.NewServer(srvOpts...)
+	s := grpc.NewServer(srvOpts...)
+	registerHealthServer(s)
+	return s
 }
 
 // EnableHandlingTiming enables client/server handling timing with prometheus
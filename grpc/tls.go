@@ -0,0 +1,151 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/butters-mars/tiki/common"
+	"github.com/butters-mars/tiki/config"
+	"github.com/butters-mars/tiki/utils"
+)
+
+const (
+	// ClientAuthNone does not ask for client certificates
+	ClientAuthNone = "none"
+	// ClientAuthRequest verifies client certificates if given
+	ClientAuthRequest = "request"
+	// ClientAuthRequire requires verified client certificates
+	ClientAuthRequire = "require"
+
+	// TagPeerID is the ctxtags key of SPIFFE ID of the peer certificate
+	TagPeerID = "peer.spiffe_id"
+
+	schemeSPIFFE = "spiffe"
+)
+
+func clientAuthType(cfg *config.AuthConfig) (tls.ClientAuthType, error) {
+	mode := cfg.ClientAuth
+	if mode == "" {
+		mode = ClientAuthNone
+		if cfg.CAFile != "" {
+			mode = ClientAuthRequire
+		}
+	}
+
+	var authType tls.ClientAuthType
+	switch mode {
+	case ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		authType = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		authType = tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth %s", mode)
+	}
+	// client certs would be verified by system roots, which trust any public issued cert
+	if cfg.CAFile == "" {
+		return tls.NoClientCert, fmt.Errorf("client auth %s without ca", mode)
+	}
+	return authType, nil
+}
+
+// serverCredentials returns tls credentials of the server, certificates are reloaded when
+// files change
+func serverCredentials(cfg *config.AuthConfig) (credentials.TransportCredentials, error) {
+	clientAuth, err := clientAuthType(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CertFile == "" {
+		return nil, fmt.Errorf("no cert of tls server")
+	}
+
+	reloader, err := utils.NewCertReloader(cfg.CAFile, cfg.CertFile, cfg.KeyFile,
+		time.Duration(cfg.ReloadInterval)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(reloader.ServerConfig(clientAuth)), nil
+}
+
+// SPIFFEID returns SPIFFE ID in URI SAN of the certificate, empty if none
+func SPIFFEID(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == schemeSPIFFE && uri.Host != "" {
+			return uri.String()
+		}
+	}
+	return ""
+}
+
+// ServiceOfSPIFFEID returns the service name of SPIFFE ID in the trust domain and namespace,
+// e.g. order-service of spiffe://example.org/ns/prod/sa/order-service, or the full ID if it's
+// of others or the trust domain is not given, so that callers of other domains can't pass as
+// local services
+func ServiceOfSPIFFEID(id, trustDomain, namespace string) string {
+	if trustDomain == "" || namespace == "" {
+		return id
+	}
+
+	prefix := fmt.Sprintf("%s://%s/ns/%s/sa/", schemeSPIFFE, trustDomain, namespace)
+	if !strings.HasPrefix(id, prefix) {
+		return id
+	}
+	if service := id[len(prefix):]; service != "" && !strings.Contains(service, "/") {
+		return service
+	}
+	return id
+}
+
+// PeerIdentity returns SPIFFE ID of the verified client certificate of the call
+func PeerIdentity(ctx context.Context) (id string, ok bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	id = SPIFFEID(info.State.VerifiedChains[0][0])
+	return id, id != ""
+}
+
+// withPeerIdentity puts SPIFFE ID of the peer into context under common.KeySPIFFEID, and its
+// service under common.KeyIdentity
+func withPeerIdentity(ctx context.Context, cfg *config.AuthConfig) context.Context {
+	id, ok := PeerIdentity(ctx)
+	if !ok {
+		return ctx
+	}
+
+	grpc_ctxtags.Extract(ctx).Set(TagPeerID, id)
+	ctx = context.WithValue(ctx, common.KeySPIFFEID{}, id)
+	return context.WithValue(ctx, common.KeyIdentity{}, ServiceOfSPIFFEID(id, cfg.TrustDomain, cfg.Namespace))
+}
+
+func unaryPeerIdentity(cfg *config.AuthConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(withPeerIdentity(ctx, cfg), req)
+	}
+}
+
+func streamPeerIdentity(cfg *config.AuthConfig) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = withPeerIdentity(ss.Context(), cfg)
+		return handler(srv, wrapped)
+	}
+}
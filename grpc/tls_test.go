@@ -0,0 +1,139 @@
+package grpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	fmgrpc "github.com/butters-mars/tiki/client/grpc"
+	fmhttp "github.com/butters-mars/tiki/client/http"
+	"github.com/butters-mars/tiki/common"
+	"github.com/butters-mars/tiki/config"
+	"github.com/butters-mars/tiki/internal/testcerts"
+)
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := testcerts.NewCA(t, dir)
+	caFile := ca.CertFile
+	serverCert, serverKey := ca.Issue(t, "server", "127.0.0.1", "spiffe://tiki.test/ns/prod/sa/payment-service")
+	clientCert, clientKey := ca.Issue(t, "client", "127.0.0.1", "spiffe://tiki.test/ns/prod/sa/order-service")
+
+	authCfg := &config.AuthConfig{TLS: true, CertFile: serverCert, KeyFile: serverKey, CAFile: caFile,
+		TrustDomain: "tiki.test", Namespace: "prod"}
+	creds, err := serverCredentials(authCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities := make(chan [2]interface{}, 1)
+	capture := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		identities <- [2]interface{}{ctx.Value(common.KeyIdentity{}), ctx.Value(common.KeySPIFFEID{})}
+		return handler(ctx, req)
+	}
+	s := grpc.NewServer(grpc.Creds(creds), grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryPeerIdentity(authCfg), capture)))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	defer s.Stop()
+
+	check := func() error {
+		conn, err := fmgrpc.NewClientConn(lis.Addr().String(), config.ServiceDiscoveryCfg{Type: "direct"})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(false))
+		return err
+	}
+
+	// client certificate is required
+	fmgrpc.SetClientTLS(&fmhttp.TLSSetting{CAFile: caFile})
+	defer fmgrpc.SetClientTLS(nil)
+	if err = check(); err == nil {
+		t.Errorf("should reject client without certificate")
+	}
+
+	fmgrpc.SetClientTLS(&fmhttp.TLSSetting{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey})
+	if err = check(); err != nil {
+		t.Fatalf("should call with client certificate: %v", err)
+	}
+	identity := <-identities
+	if identity[0] != "order-service" || identity[1] != "spiffe://tiki.test/ns/prod/sa/order-service" {
+		t.Errorf("should put peer identity into context: %v", identity)
+	}
+
+	// client not trusting server ca
+	otherDir := filepath.Join(dir, "other")
+	os.Mkdir(otherDir, 0700)
+	other := testcerts.NewCA(t, otherDir)
+	fmgrpc.SetClientTLS(&fmhttp.TLSSetting{CAFile: other.CertFile, CertFile: clientCert, KeyFile: clientKey})
+	if err = check(); err == nil {
+		t.Errorf("should reject server of unknown ca")
+	}
+}
+
+func TestClientAuthType(t *testing.T) {
+	cases := []struct {
+		cfg  config.AuthConfig
+		mode string
+	}{
+		{config.AuthConfig{}, ClientAuthNone},
+		{config.AuthConfig{CAFile: "ca.crt"}, ClientAuthRequire},
+		{config.AuthConfig{CAFile: "ca.crt", ClientAuth: ClientAuthRequest}, ClientAuthRequest},
+	}
+	for _, c := range cases {
+		cfg := c.cfg
+		cfg.ClientAuth = c.mode
+		expected, _ := clientAuthType(&cfg)
+		if actual, err := clientAuthType(&c.cfg); err != nil || actual != expected {
+			t.Errorf("%+v should be %s: %v %v", c.cfg, c.mode, actual, err)
+		}
+	}
+	if _, err := clientAuthType(&config.AuthConfig{ClientAuth: "optional"}); err == nil {
+		t.Errorf("should reject unknown client auth")
+	}
+	for _, mode := range []string{ClientAuthRequest, ClientAuthRequire} {
+		if _, err := clientAuthType(&config.AuthConfig{ClientAuth: mode}); err == nil {
+			t.Errorf("should reject client auth %s without ca", mode)
+		}
+	}
+}
+
+func TestServiceOfSPIFFEID(t *testing.T) {
+	cases := []struct {
+		id, trustDomain, namespace, service string
+	}{
+		{"spiffe://tiki.test/ns/prod/sa/order-service", "tiki.test", "prod", "order-service"},
+		{"spiffe://other.org/ns/prod/sa/order-service", "tiki.test", "prod", "spiffe://other.org/ns/prod/sa/order-service"},
+		{"spiffe://tiki.test/ns/dev/sa/order-service", "tiki.test", "prod", "spiffe://tiki.test/ns/dev/sa/order-service"},
+		{"spiffe://tiki.test/ns/prod/sa/order-service/x", "tiki.test", "prod", "spiffe://tiki.test/ns/prod/sa/order-service/x"},
+		{"spiffe://tiki.test/ns/prod/sa/order-service", "", "", "spiffe://tiki.test/ns/prod/sa/order-service"},
+		{"", "tiki.test", "prod", ""},
+	}
+	for _, c := range cases {
+		if service := ServiceOfSPIFFEID(c.id, c.trustDomain, c.namespace); service != c.service {
+			t.Errorf("%s of %s/%s should be %s: %s", c.id, c.trustDomain, c.namespace, c.service, service)
+		}
+	}
+}
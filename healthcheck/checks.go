@@ -0,0 +1,259 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Level defines how a failing check affects the instance
+type Level int
+
+const (
+	// LevelNonCritical check is only reported, instance is considered degraded but ready
+	LevelNonCritical Level = iota
+	// LevelCritical check fails readiness, so instance stops receiving traffic
+	LevelCritical
+	// LevelLiveness check fails both liveness and readiness, so instance gets restarted
+	LevelLiveness
+)
+
+const (
+	// StatusUp check passed
+	StatusUp = "UP"
+	// StatusDown check failed
+	StatusDown = "DOWN"
+	// StatusOffline instance is set offline
+	StatusOffline = "OFFLINE"
+
+	defaultCheckTimeout = time.Second
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelCritical:
+		return "critical"
+	case LevelLiveness:
+		return "liveness"
+	}
+	return "noncritical"
+}
+
+// MarshalJSON implements json.Marshaler
+func (l Level) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+// CheckFunc checks a dependency, returns error if it's unhealthy
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name    string
+	fn      CheckFunc
+	timeout time.Duration
+	level   Level
+}
+
+// Result is the result of a check
+type Result struct {
+	Name    string  `json:"name"`
+	Level   Level   `json:"level"`
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Report is the result of all checks
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+var (
+	checks      = make(map[string]check)
+	checksMutex = sync.RWMutex{}
+
+	checkStatus metrics.Gauge = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "tiki",
+		Subsystem: "healthcheck",
+		Name:      "status",
+		Help:      "Status of dependency check, 1 for up and 0 for down.",
+	}, []string{"check", "level"})
+
+	checkLatency metrics.Gauge = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "tiki",
+		Subsystem: "healthcheck",
+		Name:      "latency_seconds",
+		Help:      "Latency of the last dependency check.",
+	}, []string{"check", "level"})
+)
+
+// AddCheck registers a named dependency check, an existing check with the same name is replaced.
+// timeout <= 0 means default timeout 1s
+func AddCheck(name string, fn CheckFunc, timeout time.Duration, level Level) {
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+
+	checks[name] = check{
+		name:    name,
+		fn:      fn,
+		timeout: timeout,
+		level:   level,
+	}
+}
+
+// RemoveCheck unregisters check with given name
+func RemoveCheck(name string) {
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+
+	delete(checks, name)
+}
+
+// Live runs liveness checks
+func Live(ctx context.Context) Report {
+	return run(ctx, func(l Level) bool { return l == LevelLiveness }, false)
+}
+
+// Ready runs all checks, instance is ready only if it's online and no critical check fails
+func Ready(ctx context.Context) Report {
+	return run(ctx, func(l Level) bool { return true }, IsOffline())
+}
+
+func run(ctx context.Context, filter func(Level) bool, offline bool) Report {
+	checksMutex.RLock()
+	cs := make([]check, 0, len(checks))
+	for _, c := range checks {
+		if filter(c.level) {
+			cs = append(cs, c)
+		}
+	}
+	checksMutex.RUnlock()
+
+	results := make([]Result, len(cs))
+	wg := sync.WaitGroup{}
+	for i, c := range cs {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	report := Report{
+		Status: StatusUp,
+		Checks: results,
+	}
+	for _, r := range results {
+		if r.Status == StatusDown && r.Level != LevelNonCritical {
+			report.Status = StatusDown
+		}
+	}
+	if offline {
+		report.Status = StatusOffline
+	}
+
+	return report
+}
+
+func runCheck(ctx context.Context, c check) (result Result) {
+	result = Result{
+		Name:   c.name,
+		Level:  c.level,
+		Status: StatusUp,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errC := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errC <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errC <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errC:
+	case <-ctx.Done():
+		err = fmt.Errorf("timeout after %v", c.timeout)
+	}
+
+	latency := time.Since(start)
+	result.Latency = float64(latency) / float64(time.Millisecond)
+
+	status := 1.0
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+		status = 0
+	}
+	checkStatus.With("check", c.name, "level", c.level.String()).Set(status)
+	checkLatency.With("check", c.name, "level", c.level.String()).Set(latency.Seconds())
+
+	return
+}
+
+// LiveHandler returns a "/healthz/live" HTTP GET handler
+func LiveHandler() http.Handler {
+	return reportHandler(Live)
+}
+
+// ReadyHandler returns a "/healthz/ready" HTTP GET handler
+func ReadyHandler() http.Handler {
+	return reportHandler(Ready)
+}
+
+func reportHandler(fn func(context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := fn(req.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// ConsulCheck returns a check of consul agent reachability
+func ConsulCheck(addr string) CheckFunc {
+	client := &http.Client{}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/status/leader", addr), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("consul status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,56 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	defer RemoveCheck("db")
+	defer RemoveCheck("cache")
+
+	AddCheck("cache", func(ctx context.Context) error { return fmt.Errorf("down") }, 0, LevelNonCritical)
+	report := Ready(context.TODO())
+	if report.Status != StatusUp || len(report.Checks) != 1 || report.Checks[0].Status != StatusDown {
+		t.Errorf("non-critical failure should be reported but ready: %v", report)
+		return
+	}
+
+	AddCheck("db", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}, 10*time.Millisecond, LevelCritical)
+	report = Ready(context.TODO())
+	if report.Status != StatusDown {
+		t.Errorf("critical timeout should fail readiness: %v", report)
+		return
+	}
+
+	if report := Live(context.TODO()); report.Status != StatusUp {
+		t.Errorf("critical failure should not fail liveness: %v", report)
+		return
+	}
+
+	w := httptest.NewRecorder()
+	ReadyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("should return 503: %d", w.Code)
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	defer SetOffline(false)
+
+	calls := 0
+	remove := OnChange(func(offline bool) { calls++ })
+	SetOffline(true)
+	remove()
+	SetOffline(false)
+	if calls != 1 {
+		t.Errorf("removed listener should not be called: %d", calls)
+	}
+}
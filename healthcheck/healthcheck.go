@@ -1,18 +1,78 @@
 package healthcheck
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
 
-var offline = false
+var (
+	offline    = false
+	listeners  = make(map[int]func(offline bool))
+	listenerID = 0
+	mutex      = sync.RWMutex{}
+)
 
 // SetOffline set offline mode of healthcheck
 func SetOffline(off bool) {
+	mutex.Lock()
+	changed := offline != off
 	offline = off
+	ls := make([]func(bool), 0, len(listeners))
+	for _, l := range listeners {
+		ls = append(ls, l)
+	}
+	mutex.Unlock()
+
+	if changed {
+		for _, l := range ls {
+			l(off)
+		}
+	}
+}
+
+// IsOffline returns whether in offline mode
+func IsOffline() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return offline
+}
+
+// OnChange adds a listener called when offline mode changes, the returned function removes it
+func OnChange(l func(offline bool)) (remove func()) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	listenerID++
+	id := listenerID
+	listeners[id] = l
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		delete(listeners, id)
+	}
+}
+
+// Status returns an error if offline or not ready, used by ttl heartbeats
+func Status() error {
+	report := Ready(context.Background())
+	if report.Status != StatusUp {
+		for _, r := range report.Checks {
+			if r.Status == StatusDown && r.Level != LevelNonCritical {
+				return fmt.Errorf("%s: %s", r.Name, r.Error)
+			}
+		}
+		return errors.New(report.Status)
+	}
+	return nil
 }
 
 // Handler returns a "/healthcheck" HTTP GET handler
 func Handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if offline {
+		if IsOffline() {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("OFFLINE"))
 		} else {
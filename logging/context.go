@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"context"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc"
+
+	"github.com/butters-mars/tiki/common"
+)
+
+const (
+	// FieldRequestID is the log field of request id
+	FieldRequestID = "request_id"
+	// FieldTraceID is the log field of trace id
+	FieldTraceID = "trace_id"
+	// FieldMethod is the log field of grpc method being served
+	FieldMethod = "method"
+	// FieldUID is the log field of authenticated uid
+	FieldUID = "uid"
+)
+
+// FromContext returns an entry of L with request id, trace id, method and uid of the context
+func FromContext(ctx context.Context) *logrus.Entry {
+	fields := logrus.Fields{}
+	if ctx == nil {
+		return L.WithFields(fields)
+	}
+
+	if id, ok := ctx.Value(common.KeyRequestID{}).(string); ok && id != "" {
+		fields[FieldRequestID] = id
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if sc, ok := span.Context().(jaeger.SpanContext); ok && sc.IsValid() {
+			fields[FieldTraceID] = sc.TraceID().String()
+		}
+	}
+	if method, ok := grpc.Method(ctx); ok {
+		fields[FieldMethod] = method
+	}
+	if uid, ok := ctx.Value(common.KeyUID{}).(string); ok && uid != "" {
+		fields[FieldUID] = uid
+	}
+
+	return L.WithFields(fields)
+}
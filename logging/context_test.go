@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc"
+
+	"github.com/butters-mars/tiki/common"
+)
+
+type testStream struct {
+	grpc.ServerTransportStream
+}
+
+func (s *testStream) Method() string {
+	return "/a.B/C"
+}
+
+func TestFromContext(t *testing.T) {
+	if fields := FromContext(context.Background()).Data; len(fields) != 0 {
+		t.Errorf("should have no fields: %v", fields)
+	}
+
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	span := tracer.StartSpan("op")
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &testStream{})
+	ctx = context.WithValue(ctx, common.KeyRequestID{}, "r1")
+	ctx = context.WithValue(ctx, common.KeyUID{}, "u1")
+
+	fields := FromContext(ctx).Data
+	expected := map[string]interface{}{
+		FieldRequestID: "r1",
+		FieldTraceID:   span.Context().(jaeger.SpanContext).TraceID().String(),
+		FieldMethod:    "/a.B/C",
+		FieldUID:       "u1",
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("%s should be %v: %v", k, v, fields[k])
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package propagation
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/butters-mars/tiki/common"
+	"github.com/butters-mars/tiki/logging"
+)
+
+const (
+	// KeyUID is the baggage key of uid, which is the authenticated uid of the hop sending it,
+	// downstream services should not take it as authenticated unless the caller is trusted
+	KeyUID = "x-uid"
+	// KeyRequestID is the baggage key of request id, which is always propagated
+	KeyRequestID = "x-request-id"
+	// KeyLocale is the baggage key of locale
+	KeyLocale = "x-locale"
+	// KeyTenant is the baggage key of tenant id
+	KeyTenant = "x-tenant-id"
+
+	// values longer are dropped
+	maxValueLen = 512
+)
+
+var logger = logging.L
+
+var (
+	keysMutex = &sync.RWMutex{}
+	keys      = []string{KeyUID, KeyRequestID, KeyLocale, KeyTenant}
+)
+
+// SetKeys sets the allowlist of baggage keys, which are grpc metadata keys and http headers,
+// keys are case insensitive
+func SetKeys(allowed ...string) {
+	normalized := make([]string, 0, len(allowed))
+	for _, k := range allowed {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			normalized = append(normalized, k)
+		}
+	}
+
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+
+	keys = normalized
+}
+
+// Keys returns the allowlist of baggage keys
+func Keys() []string {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	return keys
+}
+
+// Baggage is the set of propagated values by lower case key
+type Baggage map[string]string
+
+type baggageKey struct{}
+
+// FromContext returns baggage of the context, nil if none
+func FromContext(ctx context.Context) Baggage {
+	b, _ := ctx.Value(baggageKey{}).(Baggage)
+	return b
+}
+
+// NewContext returns a context carrying the baggage
+func NewContext(ctx context.Context, b Baggage) context.Context {
+	return context.WithValue(ctx, baggageKey{}, b)
+}
+
+// Get returns value of the key in baggage of the context
+func Get(ctx context.Context, key string) string {
+	return FromContext(ctx)[strings.ToLower(key)]
+}
+
+// With returns a context with the value set in its baggage, baggage of the parent context
+// is not changed
+func With(ctx context.Context, key, value string) context.Context {
+	parent := FromContext(ctx)
+	b := make(Baggage, len(parent)+1)
+	for k, v := range parent {
+		b[k] = v
+	}
+	b[strings.ToLower(key)] = value
+	return NewContext(ctx, b)
+}
+
+// outgoing returns baggage to send of allowed keys and request id, uid is the authenticated
+// one if any
+func outgoing(ctx context.Context) Baggage {
+	b := FromContext(ctx)
+	uid, _ := ctx.Value(common.KeyUID{}).(string)
+
+	out := make(Baggage)
+	for _, k := range Keys() {
+		v := b[k]
+		if k == KeyUID && uid != "" {
+			v = uid
+		}
+		if v != "" {
+			out[k] = v
+		}
+	}
+	if id := RequestID(ctx); id != "" {
+		out[KeyRequestID] = id
+	}
+	return out
+}
+
+func allowed(key, value string) bool {
+	if len(value) > maxValueLen {
+		logger.Warnf("[Propagation] drop %s of %d bytes", key, len(value))
+		return false
+	}
+	return value != ""
+}
+
+// ExtractMetadata returns a context with baggage of allowed keys in the metadata
+func ExtractMetadata(ctx context.Context, md metadata.MD) context.Context {
+	b := make(Baggage)
+	for _, k := range Keys() {
+		if vals := md.Get(k); len(vals) > 0 && allowed(k, vals[0]) {
+			b[k] = vals[0]
+		}
+	}
+	return NewContext(ctx, b)
+}
+
+// ExtractHeader returns a context with baggage of allowed keys in the header
+func ExtractHeader(ctx context.Context, h http.Header) context.Context {
+	b := make(Baggage)
+	for _, k := range Keys() {
+		if v := h.Get(k); allowed(k, v) {
+			b[k] = v
+		}
+	}
+	return NewContext(ctx, b)
+}
+
+// InjectMetadata returns a context with baggage appended to outgoing metadata, keys already
+// in outgoing metadata are kept
+func InjectMetadata(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+
+	kv := make([]string, 0)
+	for k, v := range outgoing(ctx) {
+		if len(md.Get(k)) == 0 {
+			kv = append(kv, k, v)
+		}
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// InjectHeader sets baggage into the header, headers already set are kept
+func InjectHeader(ctx context.Context, h http.Header) {
+	for k, v := range outgoing(ctx) {
+		if h.Get(k) == "" {
+			h.Set(k, v)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package propagation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/butters-mars/tiki/common"
+)
+
+func TestExtract(t *testing.T) {
+	md := metadata.Pairs(
+		KeyRequestID, "r1",
+		KeyTenant, "t1",
+		KeyLocale, strings.Repeat("x", maxValueLen+1),
+		"x-secret", "s1",
+	)
+	ctx := ExtractMetadata(context.Background(), md)
+	if Get(ctx, KeyRequestID) != "r1" || Get(ctx, "X-Tenant-ID") != "t1" {
+		t.Errorf("should extract allowed keys: %v", FromContext(ctx))
+	}
+	if Get(ctx, KeyLocale) != "" || Get(ctx, "x-secret") != "" {
+		t.Errorf("should drop long values and keys not allowed: %v", FromContext(ctx))
+	}
+
+	h := http.Header{}
+	h.Set("X-Request-Id", "r2")
+	h.Set("X-Secret", "s2")
+	ctx = ExtractHeader(context.Background(), h)
+	if b := FromContext(ctx); len(b) != 1 || b[KeyRequestID] != "r2" {
+		t.Errorf("should extract allowed headers: %v", b)
+	}
+
+	SetKeys("X-Secret")
+	defer SetKeys(KeyUID, KeyRequestID, KeyLocale, KeyTenant)
+	if ctx = ExtractHeader(context.Background(), h); Get(ctx, "x-secret") != "s2" || Get(ctx, KeyRequestID) != "" {
+		t.Errorf("should follow allowlist: %v", FromContext(ctx))
+	}
+}
+
+func TestInject(t *testing.T) {
+	ctx := With(context.Background(), KeyRequestID, "r1")
+	ctx = With(ctx, KeyUID, "forged")
+	child := With(ctx, KeyTenant, "t1")
+	if Get(ctx, KeyTenant) != "" {
+		t.Errorf("should not change parent baggage")
+	}
+
+	h := http.Header{}
+	h.Set(KeyTenant, "t2")
+	InjectHeader(child, h)
+	if h.Get(KeyRequestID) != "r1" || h.Get(KeyTenant) != "t2" || h.Get(KeyUID) != "forged" {
+		t.Errorf("should inject without overriding: %v", h)
+	}
+
+	// authenticated uid takes precedence
+	authed := context.WithValue(child, common.KeyUID{}, "u1")
+	out, _ := metadata.FromOutgoingContext(InjectMetadata(authed))
+	if out.Get(KeyUID)[0] != "u1" || out.Get(KeyTenant)[0] != "t1" {
+		t.Errorf("should inject metadata: %v", out)
+	}
+
+	out, _ = metadata.FromOutgoingContext(InjectMetadata(metadata.AppendToOutgoingContext(authed, KeyTenant, "t2")))
+	if vals := out.Get(KeyTenant); len(vals) != 1 || vals[0] != "t2" {
+		t.Errorf("should keep outgoing metadata: %v", out)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	in := metadata.NewIncomingContext(context.Background(), metadata.Pairs(KeyRequestID, "r1", KeyLocale, "fr"))
+
+	// server extracts and client injects in the next hop
+	var out metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		out, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	UnaryServerInterceptor()(in, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, UnaryClientInterceptor()(ctx, "/a.B/C", nil, nil, nil, invoker)
+	})
+	if out.Get(KeyRequestID)[0] != "r1" || out.Get(KeyLocale)[0] != "fr" {
+		t.Errorf("should propagate metadata: %v", out)
+	}
+
+	var locale string
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale = Get(r.Context(), KeyLocale)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Locale", "de")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if locale != "de" {
+		t.Errorf("should extract headers: %s", locale)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	SetKeys(KeyTenant)
+	defer SetKeys(KeyUID, KeyRequestID, KeyLocale, KeyTenant)
+
+	ctx, id := EnsureRequestID(context.Background())
+	if !ValidRequestID(id) || RequestID(ctx) != id {
+		t.Errorf("should generate request id: %s", id)
+	}
+	if _, kept := EnsureRequestID(ctx); kept != id {
+		t.Errorf("should keep request id: %s", kept)
+	}
+	if _, replaced := EnsureRequestID(WithRequestID(ctx, "a b")); replaced == "a b" {
+		t.Errorf("should replace invalid request id")
+	}
+
+	h := http.Header{}
+	InjectHeader(ctx, h)
+	if h.Get(KeyRequestID) != id {
+		t.Errorf("request id should be propagated regardless of allowlist: %v", h)
+	}
+}
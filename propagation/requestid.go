@@ -0,0 +1,62 @@
+package propagation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/butters-mars/tiki/common"
+)
+
+const maxRequestIDLen = 128
+
+// NewRequestID generates a random request id
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logger.Errorf("[Propagation] fail to generate request id: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID returns whether the id is accepted from callers, which is at most 128
+// letters, digits, '-', '_', '.' or ':'
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID returns request id of the context
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(common.KeyRequestID{}).(string); ok && id != "" {
+		return id
+	}
+	return Get(ctx, KeyRequestID)
+}
+
+// WithRequestID returns a context with the request id, which is propagated to upstreams
+// regardless of the allowlist
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = With(ctx, KeyRequestID, id)
+	return context.WithValue(ctx, common.KeyRequestID{}, id)
+}
+
+// EnsureRequestID returns a context with the request id of the context if it's valid, or
+// a new one otherwise
+func EnsureRequestID(ctx context.Context) (context.Context, string) {
+	id := RequestID(ctx)
+	if !ValidRequestID(id) {
+		id = NewRequestID()
+	}
+	return WithRequestID(ctx, id), id
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"math"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Limit is a token bucket refilled by rate tokens per second, holding at most burst tokens
+type Limit struct {
+	Rate  float64 `yaml:"rate"`  // <= 0 means unlimited
+	Burst int     `yaml:"burst"` // default is rate, at least 1
+}
+
+func (l Limit) valid() bool {
+	return l.Rate > 0
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return int(math.Max(1, math.Ceil(l.Rate)))
+}
+
+// Config defines limits of a server, a call takes a token from each of its caller,
+// method and global buckets, and is rejected if any of them is empty
+type Config struct {
+	Global      Limit            `yaml:"global"`      // all calls
+	Methods     map[string]Limit `yaml:"methods"`     // by full method, e.g. /package.Service/Method
+	Caller      Limit            `yaml:"caller"`      // each caller
+	Callers     map[string]Limit `yaml:"callers"`     // by caller key, e.g. uid:1, id:svc-a or ip:10.0.0.1
+	Exempt      []string         `yaml:"exempt"`      // full methods or /package.Service/ never limited
+	Distributed bool             `yaml:"distributed"` // coordinate quotas by the store of the limiter, limit locally without store
+}
+
+// LoadConfig loads config from a yaml file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,318 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/butters-mars/tiki/common"
+	"github.com/butters-mars/tiki/logging"
+)
+
+const (
+	// HeaderRetryAfter is the response header of seconds to wait before retry of a rejected call
+	HeaderRetryAfter = "retry-after"
+
+	bucketGlobal = "global"
+	bucketMethod = "method"
+	bucketCaller = "caller"
+
+	defaultWatchInterval = 5 * time.Second
+)
+
+var logger = logging.L
+
+var rejected metrics.Counter = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Namespace: "service",
+	Subsystem: "grpc",
+	Name:      "ratelimited",
+	Help:      "Calls rejected by rate limit.",
+}, []string{"method", "bucket"})
+
+// CallerKey returns key of the caller, which is the authenticated identity, uid or peer ip
+func CallerKey(ctx context.Context) string {
+	if id, ok := ctx.Value(common.KeyIdentity{}).(string); ok && id != "" {
+		return "id:" + id
+	}
+	if uid, ok := ctx.Value(common.KeyUID{}).(string); ok && uid != "" {
+		return "uid:" + uid
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host := p.Addr.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return "ip:" + host
+	}
+	return ""
+}
+
+// Limiter limits calls of a server by global, per method and per caller buckets
+type Limiter struct {
+	mutex  *sync.RWMutex
+	cfg    Config
+	store  Store
+	local  *MemoryStore
+	keyFor func(ctx context.Context) string
+
+	path    string
+	modTime time.Time
+	size    int64
+	stop    chan struct{}
+}
+
+// New creates a limiter with the config
+func New(cfg *Config) *Limiter {
+	l := &Limiter{
+		mutex:  &sync.RWMutex{},
+		local:  NewMemoryStore(),
+		keyFor: CallerKey,
+	}
+	l.SetConfig(cfg)
+	return l
+}
+
+// NewFromFile creates a limiter with config in the yaml file, which is reloaded when changed
+// once Watch is called
+func NewFromFile(path string) (*Limiter, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := New(cfg)
+	l.path = path
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	return l, nil
+}
+
+// SetConfig changes limits, buckets are kept so that changes take effect smoothly
+func (l *Limiter) SetConfig(cfg *Config) {
+	c := Config{}
+	if cfg != nil {
+		c = *cfg
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.cfg = c
+}
+
+// SetStore sets store of distributed mode
+func (l *Limiter) SetStore(store Store) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.store = store
+}
+
+// SetCallerKeyFunc overrides CallerKey
+func (l *Limiter) SetCallerKeyFunc(keyFor func(ctx context.Context) string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.keyFor = keyFor
+}
+
+// Watch starts polling the config file with given interval
+func (l *Limiter) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	l.mutex.Lock()
+	if l.path == "" || l.stop != nil {
+		l.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	l.stop = stop
+	l.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				l.Reload()
+			}
+		}
+	}()
+}
+
+// StopWatch stops watching the config file
+func (l *Limiter) StopWatch() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+}
+
+// Reload reloads the config file if it's changed
+func (l *Limiter) Reload() {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		logger.Errorf("[RateLimit] fail to stat %s: %v", l.path, err)
+		return
+	}
+
+	l.mutex.RLock()
+	unchanged := info.ModTime().Equal(l.modTime) && info.Size() == l.size
+	l.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	cfg, err := LoadConfig(l.path)
+	if err != nil {
+		logger.Errorf("[RateLimit] fail to reload %s, keep current limits: %v", l.path, err)
+		return
+	}
+
+	l.SetConfig(cfg)
+	l.mutex.Lock()
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	l.mutex.Unlock()
+	logger.Infof("[RateLimit] reloaded from %s", l.path)
+}
+
+func (l *Limiter) exempt(cfg *Config, fullMethod string) bool {
+	if strings.HasPrefix(fullMethod, common.HealthService) {
+		return true
+	}
+	for _, m := range cfg.Exempt {
+		if m == fullMethod || (strings.HasSuffix(m, "/") && strings.HasPrefix(fullMethod, m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow takes tokens of the call from caller, method and global buckets in order, returns
+// the bucket rejecting it and time to wait if not allowed. Tokens taken before the rejecting
+// bucket are given back, so that rejected calls don't drain quotas
+func (l *Limiter) Allow(ctx context.Context, fullMethod string) (ok bool, bucket string, retryAfter time.Duration) {
+	l.mutex.RLock()
+	cfg := l.cfg
+	store := Store(l.local)
+	if cfg.Distributed && l.store != nil {
+		store = l.store
+	}
+	keyFor := l.keyFor
+	l.mutex.RUnlock()
+
+	if l.exempt(&cfg, fullMethod) {
+		return true, "", 0
+	}
+
+	type check struct {
+		bucket string
+		key    string
+		limit  Limit
+	}
+	checks := make([]check, 0, 3)
+	if caller := keyFor(ctx); caller != "" {
+		limit, found := cfg.Callers[caller]
+		if !found {
+			limit = cfg.Caller
+		}
+		checks = append(checks, check{bucketCaller, "caller:" + caller, limit})
+	}
+	checks = append(checks, check{bucketMethod, "method:" + fullMethod, cfg.Methods[fullMethod]})
+	checks = append(checks, check{bucketGlobal, "global", cfg.Global})
+
+	type token struct {
+		check
+		from Store
+	}
+	taken := make([]token, 0, len(checks))
+	for _, c := range checks {
+		if !c.limit.valid() {
+			continue
+		}
+
+		from := store
+		allowed, wait, err := from.Take(ctx, c.key, c.limit)
+		if err != nil && store != Store(l.local) {
+			// fail over to local buckets if the shared store is down
+			logging.FromContext(ctx).Warnf("[RateLimit] fail to take %s from store, limit locally: %v", c.key, err)
+			from = l.local
+			allowed, wait, err = from.Take(ctx, c.key, c.limit)
+		}
+		if err != nil {
+			logging.FromContext(ctx).Errorf("[RateLimit] fail to take %s, allow the call: %v", c.key, err)
+			continue
+		}
+		if !allowed {
+			for _, t := range taken {
+				if err = t.from.Return(ctx, t.key, t.limit); err != nil {
+					logging.FromContext(ctx).Warnf("[RateLimit] fail to return token of %s: %v", t.key, err)
+				}
+			}
+			return false, c.bucket, wait
+		}
+		taken = append(taken, token{c, from})
+	}
+
+	return true, "", 0
+}
+
+// reject returns ResourceExhausted with retry-after header in seconds
+func reject(ctx context.Context, fullMethod, bucket string, retryAfter time.Duration) error {
+	seconds := int(math.Max(1, math.Ceil(retryAfter.Seconds())))
+	grpc.SetHeader(ctx, metadata.Pairs(HeaderRetryAfter, strconv.Itoa(seconds)))
+	rejected.With("method", fullMethod, "bucket", bucket).Add(1)
+
+	return status.Error(codes.ResourceExhausted, fmt.Sprintf("rate limit of %s exceeded, retry after %ds", bucket, seconds))
+}
+
+// UnaryServerInterceptor returns an interceptor limiting unary calls, it should be chained
+// after auth so that callers are identified
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if ok, bucket, retryAfter := l.Allow(ctx, info.FullMethod); !ok {
+			return nil, reject(ctx, info.FullMethod, bucket, retryAfter)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor limiting establishing of streams
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		if ok, bucket, retryAfter := l.Allow(ctx, info.FullMethod); !ok {
+			return reject(ctx, info.FullMethod, bucket, retryAfter)
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/butters-mars/tiki/common"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 10, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := s.Take(context.TODO(), "k", limit); !ok {
+			t.Fatalf("burst should be allowed")
+		}
+	}
+	ok, retryAfter, _ := s.Take(context.TODO(), "k", limit)
+	if ok || retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+		t.Fatalf("should be rejected with retry after <= 100ms: %v %v", ok, retryAfter)
+	}
+
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	if ok, _, _ = s.Take(context.TODO(), "k", limit); !ok {
+		t.Errorf("should be refilled after retry after")
+	}
+	if ok, _, _ = s.Take(context.TODO(), "other", limit); !ok {
+		t.Errorf("buckets should be separated by key")
+	}
+}
+
+func uidContext(uid string) context.Context {
+	return context.WithValue(context.TODO(), common.KeyUID{}, uid)
+}
+
+func TestLimiterBuckets(t *testing.T) {
+	l := New(&Config{
+		Global:  Limit{Rate: 0.001, Burst: 4},
+		Methods: map[string]Limit{"/test.Svc/Hot": {Rate: 0.001, Burst: 2}},
+		Caller:  Limit{Rate: 0.001, Burst: 1},
+		Callers: map[string]Limit{"uid:vip": {Rate: 0.001, Burst: 3}},
+		Exempt:  []string{"/test.Free/"},
+	})
+
+	cases := []struct {
+		ctx    context.Context
+		method string
+		ok     bool
+		bucket string
+	}{
+		{uidContext("a"), "/test.Svc/Hot", true, ""},
+		{uidContext("a"), "/test.Svc/Cold", false, bucketCaller},
+		{uidContext("vip"), "/test.Svc/Hot", true, ""},
+		{uidContext("vip"), "/test.Svc/Hot", false, bucketMethod},
+		{uidContext("vip"), "/test.Svc/Cold", true, ""},
+		{context.WithValue(context.TODO(), common.KeyIdentity{}, "svc"), "/test.Svc/Cold", true, ""},
+		{uidContext("b"), "/test.Svc/Cold", false, bucketGlobal},
+		{uidContext("b"), "/test.Free/Any", true, ""},
+		{uidContext("b"), "/grpc.health.v1.Health/Check", true, ""},
+	}
+	for i, c := range cases {
+		ok, bucket, _ := l.Allow(c.ctx, c.method)
+		if ok != c.ok || bucket != c.bucket {
+			t.Errorf("case %d: %s should be %v by [%s]: %v [%s]", i, c.method, c.ok, c.bucket, ok, bucket)
+		}
+	}
+
+	// tokens of callers rejected by method or global bucket are given back
+	if ok, _, _ := l.Allow(uidContext("vip"), "/test.Svc/Cold"); ok {
+		t.Errorf("global bucket should be drained")
+	}
+	l.SetConfig(&Config{Callers: map[string]Limit{"uid:vip": {Rate: 0.001, Burst: 3}}})
+	if ok, _, _ := l.Allow(uidContext("vip"), "/test.Svc/Cold"); !ok {
+		t.Errorf("caller bucket should not be drained by rejected calls")
+	}
+
+	// limits changed, buckets kept
+	l.SetConfig(&Config{Caller: Limit{Rate: 0.001, Burst: 2}})
+	if ok, _, _ := l.Allow(uidContext("a"), "/test.Svc/Cold"); !ok {
+		t.Errorf("should be allowed by new limits")
+	}
+}
+
+type failingStore struct{}
+
+func (s failingStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	return false, 0, errors.New("store down")
+}
+
+func (s failingStore) Return(ctx context.Context, key string, limit Limit) error {
+	return errors.New("store down")
+}
+
+func TestDistributed(t *testing.T) {
+	cfg := &Config{Global: Limit{Rate: 0.001, Burst: 3}, Distributed: true}
+	store := NewMemoryStore()
+
+	a, b := New(cfg), New(cfg)
+	a.SetStore(store)
+	b.SetStore(store)
+
+	allowed := 0
+	for i := 0; i < 4; i++ {
+		for _, l := range []*Limiter{a, b} {
+			if ok, _, _ := l.Allow(context.TODO(), "/test.Svc/Any"); ok {
+				allowed++
+			}
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("quota should be shared by instances: %d", allowed)
+	}
+
+	// fail over to local buckets
+	a.SetStore(failingStore{})
+	if ok, _, _ := a.Allow(context.TODO(), "/test.Svc/Any"); !ok {
+		t.Errorf("should limit locally if store fails")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ratelimit.yaml")
+	ioutil.WriteFile(path, []byte("global: {rate: 0.001, burst: 1}\n"), 0644)
+	l, err := NewFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Allow(context.TODO(), "/test.Svc/Any")
+	if ok, _, _ := l.Allow(context.TODO(), "/test.Svc/Any"); ok {
+		t.Fatalf("should be limited")
+	}
+
+	ioutil.WriteFile(path, []byte("global: {rate: 0.001, burst: 10}\n"), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	l.Reload()
+	if ok, _, _ := l.Allow(context.TODO(), "/test.Svc/Any"); !ok {
+		t.Errorf("should be allowed after reload")
+	}
+
+	ioutil.WriteFile(path, []byte("global: [bad\n"), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	l.Reload()
+	if ok, _, _ := l.Allow(context.TODO(), "/test.Svc/Any"); !ok {
+		t.Errorf("should keep limits if reload fails")
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := New(&Config{Caller: Limit{Rate: 0.5, Burst: 1}})
+	srv := grpc.NewServer(
+		grpc.StreamInterceptor(l.StreamServerInterceptor()),
+		grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+			if err := stream.RecvMsg(&empty.Empty{}); err != nil {
+				return err
+			}
+			return stream.SendMsg(&empty.Empty{})
+		}))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	if err = cc.Invoke(context.TODO(), "/test.Svc/Any", &empty.Empty{}, &empty.Empty{}); err != nil {
+		t.Fatalf("first call should be allowed: %v", err)
+	}
+
+	md := metadata.MD{}
+	err = cc.Invoke(context.TODO(), "/test.Svc/Any", &empty.Empty{}, &empty.Empty{}, grpc.Header(&md))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("should be ResourceExhausted: %v", err)
+	}
+	if vals := md.Get(HeaderRetryAfter); len(vals) != 1 || vals[0] != "2" {
+		t.Errorf("should have retry-after 2s: %v", md)
+	}
+}
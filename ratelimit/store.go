@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+// Store keeps token buckets, a shared store like redis coordinates quotas among instances,
+// implementations should prefix keys if the store is shared by services
+type Store interface {
+	// Take takes a token from the bucket of key, or returns time to wait for a token
+	Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+	// Return gives back a token taken from the bucket of key, e.g. the call is rejected by
+	// another bucket
+	Return(ctx context.Context, key string, limit Limit) error
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// full returns whether the bucket is refilled by now, so it's the same as a new one
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.burst())
+}
+
+// MemoryStore keeps buckets in memory, it's the store of local mode
+type MemoryStore struct {
+	mutex   *sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+// NewMemoryStore creates a memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mutex:   &sync.Mutex{},
+		buckets: make(map[string]*bucket),
+		sweptAt: time.Now(),
+	}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error) {
+	now := time.Now()
+	burst := float64(limit.burst())
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sweep(now)
+
+	b, found := s.buckets[key]
+	if !found {
+		b = &bucket{tokens: burst, last: now}
+		s.buckets[key] = b
+	} else if prev := b.limit.burst(); prev != int(burst) {
+		// burst changed, keep tokens taken
+		b.tokens = math.Max(0, b.tokens+burst-float64(prev))
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	b.limit = limit
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), nil
+}
+
+// Return implements Store
+func (s *MemoryStore) Return(ctx context.Context, key string, limit Limit) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b, found := s.buckets[key]; found {
+		b.tokens = math.Min(float64(limit.burst()), b.tokens+1)
+	}
+	return nil
+}
+
+// sweep drops refilled buckets periodically, so that buckets of gone callers do not pile up
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < sweepInterval {
+		return
+	}
+	s.sweptAt = now
+
+	for key, b := range s.buckets {
+		if b.full(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -7,19 +7,31 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"sync"
 	"time"
 )
 
 const (
 	uriRegister   = "v1/agent/service/register"
 	uriUnregister = "v1/agent/service/deregister"
+	uriServices   = "v1/agent/services"
+	uriCheckPass  = "v1/agent/check/pass"
+	uriCheckFail  = "v1/agent/check/fail"
+
+	defaultMaintainInterval = 10 // seconds
+	defaultTTL              = 10 // seconds
 )
 
 // ConsulRegisteror implements service registeror backed by Consul
 type ConsulRegisteror struct {
 	httpClient *http.Client
 	addr       string
+
+	// maintainers keeps stop channels of maintaining goroutines by service id
+	maintainers map[string]chan struct{}
+	mutex       *sync.Mutex
 }
 
 // NewConsulRegisteror creates new ConsulRegisteror
@@ -36,15 +48,31 @@ func NewConsulRegisteror(addr string) *ConsulRegisteror {
 		Timeout:   5 * time.Second,
 	}
 	reg := &ConsulRegisteror{
-		addr:       addr,
-		httpClient: httpClient,
+		addr:        addr,
+		httpClient:  httpClient,
+		maintainers: make(map[string]chan struct{}),
+		mutex:       &sync.Mutex{},
 	}
 
 	return reg
 }
 
-// Register implements method of SvcRegisteror
+// Register implements method of SvcRegisteror, and starts maintaining the
+// registration in background until Unregister is called. If the first attempt
+// fails, e.g. agent is not reachable yet, it's retried by the maintainer
 func (r ConsulRegisteror) Register(svc *SvcDef) (interface{}, error) {
+	result, err := r.register(svc)
+	if !r.startMaintainer(svc) && err != nil {
+		return nil, err
+	}
+	if err != nil {
+		logger.Warnf("[SD] Fail to register service(%s id=%s), retry in background: %v", svc.Name, svc.ID, err)
+		return false, nil
+	}
+	return result, nil
+}
+
+func (r ConsulRegisteror) register(svc *SvcDef) (interface{}, error) {
 	regurl := fmt.Sprintf("%s/%s", r.addr, uriRegister)
 
 	payload := map[string]interface{}{
@@ -55,29 +83,43 @@ func (r ConsulRegisteror) Register(svc *SvcDef) (interface{}, error) {
 	}
 
 	if svc.HealthCheck != nil {
-		check := map[string]interface{}{}
+		check := map[string]interface{}{
+			"CheckID": checkID(svc),
+		}
 		hasErr := false
+		isTTL := false
 		switch svc.HealthCheck.Type {
 		case "http":
 			check["http"] = svc.HealthCheck.Content
 		case "script":
 			check["script"] = svc.HealthCheck.Content
+		case "ttl":
+			check["TTL"] = fmt.Sprintf("%ds", ttl(svc.HealthCheck))
+			isTTL = true
+		case "grpc":
+			check["GRPC"] = svc.HealthCheck.Content
+			check["GRPCUseTLS"] = svc.HealthCheck.TLS
 		default:
 			logger.Warn("Unsupported health check:", svc.HealthCheck.Type)
 			hasErr = true
 		}
 
 		if !hasErr {
-			interval := 3
-			if svc.HealthCheck.Interval > 0 {
-				interval = svc.HealthCheck.Interval
+			if !isTTL {
+				interval := 3
+				if svc.HealthCheck.Interval > 0 {
+					interval = svc.HealthCheck.Interval
+				}
+				timeout := 1
+				if svc.HealthCheck.Timeout > 0 {
+					timeout = svc.HealthCheck.Timeout
+				}
+				check["interval"] = fmt.Sprintf("%ds", interval)
+				check["timeout"] = fmt.Sprintf("%ds", timeout)
 			}
-			timeout := 1
-			if svc.HealthCheck.Timeout > 0 {
-				timeout = svc.HealthCheck.Timeout
+			if svc.HealthCheck.DeregisterAfter > 0 {
+				check["DeregisterCriticalServiceAfter"] = fmt.Sprintf("%ds", svc.HealthCheck.DeregisterAfter)
 			}
-			check["interval"] = fmt.Sprintf("%ds", interval)
-			check["timeout"] = fmt.Sprintf("%ds", timeout)
 
 			payload["check"] = check
 		}
@@ -94,6 +136,9 @@ func (r ConsulRegisteror) Register(svc *SvcDef) (interface{}, error) {
 	if len(tags) > 0 {
 		payload["tags"] = tags
 	}
+	if len(svc.Meta) > 0 {
+		payload["Meta"] = svc.Meta
+	}
 
 	jsonBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -117,12 +162,19 @@ func (r ConsulRegisteror) Register(svc *SvcDef) (interface{}, error) {
 	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
 
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logger.Errorf("[SD] Fail to register service(%s id=%s), status: %d, return:[%s]", svc.Name, svc.ID, resp.StatusCode, string(body))
+		return nil, fmt.Errorf("fail to register service %s, status: %d", svc.ID, resp.StatusCode)
+	}
+
 	logger.Infof("[SD] service(%s id=%s, addr=%s:%d) registerd, return:[%s]", svc.Name, svc.ID, svc.Addr, svc.Port, string(body))
 	return true, nil
 }
 
 // Unregister implements method of SvcRegisteror
 func (r ConsulRegisteror) Unregister(svc *SvcDef) (interface{}, error) {
+	r.stopMaintainer(svc)
+
 	regurl := fmt.Sprintf("%s/%s/%s", r.addr, uriUnregister, svc.ID)
 
 	req, err := http.NewRequest(http.MethodPut, regurl, nil)
@@ -143,3 +195,150 @@ func (r ConsulRegisteror) Unregister(svc *SvcDef) (interface{}, error) {
 	logger.Infof("[SD] service(%s id=%s) unregisterd, return:[%s]", svc.Name, svc.ID, string(body))
 	return true, nil
 }
+
+func checkID(svc *SvcDef) string {
+	return fmt.Sprintf("service:%s", svc.ID)
+}
+
+func ttl(chk *SvcHealthChk) int {
+	if chk.TTL > 0 {
+		return chk.TTL
+	}
+	return defaultTTL
+}
+
+// startMaintainer runs a goroutine which re-registers the service when it's lost
+// by consul agent (e.g. agent restarted with state wiped), and heartbeats ttl check.
+// It returns false if maintaining is disabled
+func (r ConsulRegisteror) startMaintainer(svc *SvcDef) bool {
+	if r.mutex == nil || svc.MaintainInterval < 0 {
+		return false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.maintainers[svc.ID]; ok {
+		return true
+	}
+	stopC := make(chan struct{})
+	r.maintainers[svc.ID] = stopC
+
+	interval := defaultMaintainInterval
+	if svc.MaintainInterval > 0 {
+		interval = svc.MaintainInterval
+	}
+
+	isTTL := svc.HealthCheck != nil && svc.HealthCheck.Type == "ttl"
+	if isTTL {
+		// make ttl check passing right after registered
+		r.heartbeat(svc)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		// heartbeat twice per ttl
+		var heartbeatC <-chan time.Time
+		if isTTL {
+			heartbeat := time.NewTicker(time.Duration(ttl(svc.HealthCheck)) * time.Second / 2)
+			defer heartbeat.Stop()
+			heartbeatC = heartbeat.C
+		}
+
+		logger.Infof("[SD] maintaining service(%s id=%s) every %ds", svc.Name, svc.ID, interval)
+		for {
+			select {
+			case <-stopC:
+				logger.Infof("[SD] stop maintaining service(%s id=%s)", svc.Name, svc.ID)
+				return
+			case <-heartbeatC:
+				r.heartbeat(svc)
+			case <-ticker.C:
+				r.ensureRegistered(svc)
+			}
+		}
+	}()
+	return true
+}
+
+func (r ConsulRegisteror) stopMaintainer(svc *SvcDef) {
+	if r.mutex == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if stopC, ok := r.maintainers[svc.ID]; ok {
+		close(stopC)
+		delete(r.maintainers, svc.ID)
+	}
+}
+
+// ensureRegistered checks service by agent API, and re-registers it if missing
+func (r ConsulRegisteror) ensureRegistered(svc *SvcDef) {
+	regurl := fmt.Sprintf("%s/%s", r.addr, uriServices)
+
+	resp, err := r.httpClient.Get(regurl)
+	if err != nil {
+		logger.Warnf("[SD] Fail to GET request url: %s, %v", regurl, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Warnf("[SD] Fail to list services from %s, status: %d", regurl, resp.StatusCode)
+		return
+	}
+
+	services := make(map[string]interface{})
+	if err = json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		logger.Warnf("[SD] Fail to decode services from %s: %v", regurl, err)
+		return
+	}
+
+	if _, ok := services[svc.ID]; ok {
+		return
+	}
+
+	logger.Warnf("[SD] service(%s id=%s) is missing from consul agent, re-registering", svc.Name, svc.ID)
+	if _, err = r.register(svc); err != nil {
+		return
+	}
+	if svc.HealthCheck != nil && svc.HealthCheck.Type == "ttl" {
+		r.heartbeat(svc)
+	}
+}
+
+// heartbeat updates ttl check with the status reported by health check
+func (r ConsulRegisteror) heartbeat(svc *SvcDef) {
+	uri := uriCheckPass
+	note := ""
+	if svc.HealthCheck.Status != nil {
+		if err := svc.HealthCheck.Status(); err != nil {
+			uri = uriCheckFail
+			note = err.Error()
+		}
+	}
+
+	regurl := fmt.Sprintf("%s/%s/%s?note=%s", r.addr, uri, checkID(svc), url.QueryEscape(note))
+	req, err := http.NewRequest(http.MethodPut, regurl, nil)
+	if err != nil {
+		logger.Errorf("[SD] Fail to build request from url: %s, %v", regurl, err)
+		return
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		logger.Warnf("[SD] Fail to PUT request url: %s, %v", regurl, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		logger.Warnf("[SD] Fail to heartbeat service(%s id=%s), status: %d, return:[%s]", svc.Name, svc.ID, resp.StatusCode, string(body))
+	}
+}
@@ -0,0 +1,107 @@
+package sd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsulReregister(t *testing.T) {
+	mutex := sync.Mutex{}
+	registers := 0
+	passes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch {
+		case strings.HasSuffix(req.URL.Path, uriRegister):
+			registers++
+		case strings.Contains(req.URL.Path, uriCheckPass):
+			passes++
+		case strings.HasSuffix(req.URL.Path, uriServices):
+			// agent lost its state
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer srv.Close()
+
+	r := NewConsulRegisteror(srv.URL)
+	svc := &SvcDef{
+		ID:               "a-1",
+		Name:             "a",
+		HealthCheck:      &SvcHealthChk{Type: "ttl", TTL: 1},
+		MaintainInterval: 1,
+	}
+	if _, err := r.Register(svc); err != nil {
+		t.Errorf("fail to register: %v", err)
+		return
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	r.Unregister(svc)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if registers != 2 {
+		t.Errorf("should re-register once: %d", registers)
+	}
+	if passes < 2 {
+		t.Errorf("should heartbeat ttl check: %d", passes)
+	}
+}
+
+func TestConsulRegisterRetry(t *testing.T) {
+	mutex := sync.Mutex{}
+	registers := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch {
+		case strings.HasSuffix(req.URL.Path, uriRegister):
+			registers++
+			if registers == 1 {
+				// agent not ready yet
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		case strings.HasSuffix(req.URL.Path, uriServices):
+			if registers > 1 {
+				w.Write([]byte(`{"a-1": {}}`))
+			} else {
+				w.Write([]byte("{}"))
+			}
+		}
+	}))
+	defer srv.Close()
+
+	r := NewConsulRegisteror(srv.URL)
+	svc := &SvcDef{ID: "a-1", Name: "a", MaintainInterval: 1}
+	if _, err := r.register(svc); err == nil {
+		t.Errorf("should fail on error status")
+	}
+	mutex.Lock()
+	registers = 0
+	mutex.Unlock()
+
+	if _, err := r.Register(svc); err != nil {
+		t.Errorf("should retry in background: %v", err)
+		return
+	}
+	time.Sleep(2500 * time.Millisecond)
+	r.Unregister(svc)
+
+	mutex.Lock()
+	if registers != 2 {
+		t.Errorf("should be registered by the maintainer once: %d", registers)
+	}
+	registers = 0
+	mutex.Unlock()
+
+	if _, err := r.Register(&SvcDef{ID: "b-1", Name: "b", MaintainInterval: -1}); err == nil {
+		t.Errorf("should fail without maintainer")
+	}
+}
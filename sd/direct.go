@@ -0,0 +1,22 @@
+package sd
+
+// DirectRegisteror implements a no-op registeror, used when clients connect
+// to services by address directly, e.g. in local development
+type DirectRegisteror struct {
+}
+
+// NewDirectRegisteror creates new DirectRegisteror
+func NewDirectRegisteror() *DirectRegisteror {
+	return &DirectRegisteror{}
+}
+
+// Register implements method of SvcRegisteror
+func (r DirectRegisteror) Register(svc *SvcDef) (interface{}, error) {
+	logger.Infof("[SD] direct mode, service(%s id=%s, addr=%s:%d) not registered", svc.Name, svc.ID, svc.Addr, svc.Port)
+	return true, nil
+}
+
+// Unregister implements method of SvcRegisteror
+func (r DirectRegisteror) Unregister(svc *SvcDef) (interface{}, error) {
+	return true, nil
+}
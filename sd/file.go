@@ -0,0 +1,256 @@
+package sd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultFilePath default file for file registeror
+	DefaultFilePath = "/tmp/tiki-services.json"
+
+	defaultFileWatchInterval = 2 * time.Second
+)
+
+// FileEntry is a registered service instance in the registry file
+type FileEntry struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// FileRegisteror implements service registeror backed by a JSON file, which
+// maps service name to its instances, so that other processes could watch it
+type FileRegisteror struct {
+	path  string
+	mutex *sync.Mutex
+}
+
+// NewFileRegisteror creates new FileRegisteror
+func NewFileRegisteror(path string) *FileRegisteror {
+	return &FileRegisteror{
+		path:  path,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// LoadFileRegistry reads all registered instances from the given file
+func LoadFileRegistry(path string) (map[string][]FileEntry, error) {
+	entries := make(map[string][]FileEntry)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return entries, nil
+	}
+
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Register implements method of SvcRegisteror
+func (r FileRegisteror) Register(svc *SvcDef) (interface{}, error) {
+	err := r.update(svc.Name, func(instances []FileEntry) []FileEntry {
+		instances = removeEntry(instances, svc.ID)
+		return append(instances, FileEntry{
+			ID:      svc.ID,
+			Name:    svc.Name,
+			Address: svc.Addr,
+			Port:    svc.Port,
+			Tags:    svc.Tags,
+			Meta:    svc.Meta,
+		})
+	})
+	if err != nil {
+		logger.Errorf("[SD] Fail to register service(%s id=%s) to %s: %v", svc.Name, svc.ID, r.path, err)
+		return nil, err
+	}
+
+	logger.Infof("[SD] service(%s id=%s, addr=%s:%d) registered to %s", svc.Name, svc.ID, svc.Addr, svc.Port, r.path)
+	return true, nil
+}
+
+// Unregister implements method of SvcRegisteror
+func (r FileRegisteror) Unregister(svc *SvcDef) (interface{}, error) {
+	err := r.update(svc.Name, func(instances []FileEntry) []FileEntry {
+		return removeEntry(instances, svc.ID)
+	})
+	if err != nil {
+		logger.Errorf("[SD] Fail to unregister service(%s id=%s) from %s: %v", svc.Name, svc.ID, r.path, err)
+		return nil, err
+	}
+
+	logger.Infof("[SD] service(%s id=%s) unregistered from %s", svc.Name, svc.ID, r.path)
+	return true, nil
+}
+
+// update reads the file, applies fn to instances of the service and writes it back
+// atomically by renaming a temp file, so watchers never see a partial file. The update
+// holds an flock on <path>.lock, so that instances on the same host don't overwrite
+// entries of each other.
+func (r FileRegisteror) update(name string, fn func([]FileEntry) []FileEntry) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	unlock, err := lockFile(r.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := LoadFileRegistry(r.path)
+	if err != nil {
+		return err
+	}
+
+	instances := fn(entries[name])
+	if len(instances) == 0 {
+		delete(entries, name)
+	} else {
+		entries[name] = instances
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// lockFile takes an exclusive flock of the file, which is kept as the registry file
+// itself is replaced on each update
+func lockFile(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// FileWatcher polls the registry file and notifies instances of a service when they change
+type FileWatcher struct {
+	path     string
+	service  string
+	listener func([]FileEntry, error)
+	quitc    chan struct{}
+
+	modTime   time.Time
+	size      int64
+	instances []FileEntry
+}
+
+// WatchFile creates a watcher of the service in the registry file, the listener is called
+// with current instances before returning, and then on every change until Stop is called
+func WatchFile(path, service string, interval time.Duration, listener func([]FileEntry, error)) *FileWatcher {
+	if interval <= 0 {
+		interval = defaultFileWatchInterval
+	}
+
+	w := &FileWatcher{
+		path:     path,
+		service:  service,
+		listener: listener,
+		quitc:    make(chan struct{}),
+	}
+	w.check(true)
+	go w.loop(interval)
+	return w
+}
+
+func (w *FileWatcher) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.quitc:
+			return
+		case <-ticker.C:
+			w.check(false)
+		}
+	}
+}
+
+// check reloads the file if it's changed, and notifies if instances of the service changed
+func (w *FileWatcher) check(force bool) {
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(w.path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	if !force && modTime.Equal(w.modTime) && size == w.size {
+		return
+	}
+
+	entries, err := LoadFileRegistry(w.path)
+	if err != nil {
+		logger.Errorf("[SD] fail to load %s: %v", w.path, err)
+		w.listener(nil, err)
+		return
+	}
+	w.modTime, w.size = modTime, size
+
+	instances := entries[w.service]
+	if !force && reflect.DeepEqual(instances, w.instances) {
+		return
+	}
+	w.instances = instances
+	w.listener(instances, nil)
+}
+
+// Stop stops watching the file
+func (w *FileWatcher) Stop() {
+	close(w.quitc)
+}
+
+func removeEntry(instances []FileEntry, id string) []FileEntry {
+	result := make([]FileEntry, 0, len(instances))
+	for _, i := range instances {
+		if i.ID != id {
+			result = append(result, i)
+		}
+	}
+	return result
+}
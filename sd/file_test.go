@@ -0,0 +1,98 @@
+package sd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileRegisteror(t *testing.T) {
+	fn := fmt.Sprintf("/tmp/sd-%d.json", time.Now().Nanosecond())
+	defer os.Remove(fn)
+	defer os.Remove(fn + ".lock")
+
+	r := NewFileRegisteror(fn)
+	s1 := &SvcDef{ID: "a-1", Name: "a", Addr: "10.0.0.1", Port: 80, Tags: []string{"stg"}}
+	s2 := &SvcDef{ID: "a-2", Name: "a", Addr: "10.0.0.2", Port: 80}
+
+	for _, s := range []*SvcDef{s1, s2, s1} {
+		if _, err := r.Register(s); err != nil {
+			t.Errorf("fail to register %s: %v", s.ID, err)
+			return
+		}
+	}
+
+	entries, err := LoadFileRegistry(fn)
+	if err != nil {
+		t.Errorf("fail to load: %v", err)
+		return
+	}
+	if len(entries["a"]) != 2 {
+		t.Errorf("should have 2 instances: %v", entries)
+		return
+	}
+
+	r.Unregister(s1)
+	r.Unregister(s2)
+	entries, _ = LoadFileRegistry(fn)
+	if _, ok := entries["a"]; ok {
+		t.Errorf("service should be removed: %v", entries)
+	}
+}
+
+func TestFileRegisterorConcurrent(t *testing.T) {
+	fn := fmt.Sprintf("/tmp/sd-%d.json", time.Now().Nanosecond())
+	defer os.Remove(fn)
+	defer os.Remove(fn + ".lock")
+
+	// registerors don't share the in-process mutex, like instances on the same host
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := &SvcDef{ID: fmt.Sprintf("a-%d", i), Name: "a", Addr: "10.0.0.1", Port: 8000 + i}
+			if _, err := NewFileRegisteror(fn).Register(s); err != nil {
+				t.Errorf("fail to register %s: %v", s.ID, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, _ := LoadFileRegistry(fn)
+	if len(entries["a"]) != 20 {
+		t.Errorf("should keep all instances: %d", len(entries["a"]))
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	fn := fmt.Sprintf("/tmp/sd-%d.json", time.Now().Nanosecond())
+	defer os.Remove(fn)
+	defer os.Remove(fn + ".lock")
+
+	updates := make(chan []FileEntry, 10)
+	w := WatchFile(fn, "a", 10*time.Millisecond, func(instances []FileEntry, err error) {
+		if err != nil {
+			t.Errorf("should not fail: %v", err)
+		}
+		updates <- instances
+	})
+	defer w.Stop()
+	if instances := <-updates; len(instances) != 0 {
+		t.Errorf("should have no instances before registering: %v", instances)
+	}
+
+	r := NewFileRegisteror(fn)
+	r.Register(&SvcDef{ID: "b-1", Name: "b", Addr: "10.0.0.2", Port: 80})
+	r.Register(&SvcDef{ID: "a-1", Name: "a", Addr: "10.0.0.1", Port: 80, Tags: []string{"stg"}})
+	select {
+	case instances := <-updates:
+		if len(instances) != 1 || instances[0].ID != "a-1" || instances[0].Tags[0] != "stg" {
+			t.Errorf("should notify instances of the service: %v", instances)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("should notify registration")
+	}
+}
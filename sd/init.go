@@ -17,18 +17,16 @@ const (
 
 // InitServiceDiscovery init SD
 func InitServiceDiscovery(sdConfig *ServiceDiscoverySt, listenAddr string) (register SvcRegisteror, svc *SvcDef, err error) {
-	if sdConfig.Type != "consul" {
-		err = fmt.Errorf("service discovery type [%s] not supported", sdConfig.Type)
+	factory, err := GetFactory(sdConfig.Type)
+	if err != nil {
 		return
 	}
 
-	consulAddr := DefaultConsulAddr
-	if sdConfig.RegAddr != "" {
-		consulAddr = sdConfig.RegAddr
+	logger.Infof("[SD] using %s service discovery", sdConfig.Type)
+	reg, err := factory(sdConfig)
+	if err != nil {
+		return
 	}
-
-	logger.Info("[SD] using consul service discovery")
-	cReg := NewConsulRegisteror(consulAddr)
 	ip := utils.GetIP()
 
 	if ip == "" {
@@ -67,25 +65,41 @@ func InitServiceDiscovery(sdConfig *ServiceDiscoverySt, listenAddr string) (regi
 
 	svcName := sdConfig.SvcName
 	id := fmt.Sprintf("%s-%d-%s", ip, port, strings.Replace(svcName, ".", "_", -1))
+	hc := &SvcHealthChk{
+		Type:            "http",
+		Content:         fmt.Sprintf("http://%s%s", hcAddr, hcEndpoint),
+		DeregisterAfter: sdConfig.DeregisterAfter,
+	}
+	if sdConfig.CheckType == "ttl" {
+		hc.Type = "ttl"
+		hc.Content = ""
+		hc.TTL = sdConfig.CheckTTL
+		hc.Status = sdConfig.TTLStatus
+	} else if sdConfig.CheckType == "grpc" {
+		// grpc.health.v1.Health served on the service port
+		hc.Type = "grpc"
+		hc.Content = fmt.Sprintf("%s:%d", ip, port)
+		hc.TLS = sdConfig.CheckTLS
+	}
+
 	svc = &SvcDef{
-		ID:   id,
-		Name: sdConfig.SvcName,
-		Addr: ip,
-		Port: port,
-		HealthCheck: &SvcHealthChk{
-			Type:    "http",
-			Content: fmt.Sprintf("http://%s%s", hcAddr, hcEndpoint),
-		},
+		ID:               id,
+		Name:             sdConfig.SvcName,
+		Addr:             ip,
+		Port:             port,
+		Tags:             sdConfig.Tags,
+		Meta:             sdConfig.Meta,
+		HealthCheck:      hc,
+		MaintainInterval: sdConfig.MaintainInterval,
 	}
 
-	logger.Infof("[SD] registering service[%s id=%s addr=(%s:%d)] to %s ...",
-		sdConfig.SvcName, id, ip, port, consulAddr)
-	_, err = cReg.Register(svc)
+	logger.Infof("[SD] registering service[%s id=%s addr=(%s:%d)] to %s(%s) ...",
+		sdConfig.SvcName, id, ip, port, sdConfig.Type, sdConfig.RegAddr)
+	_, err = reg.Register(svc)
 	if err != nil {
 		return
 	}
 
-	reg := SvcRegisteror(*cReg)
 	register = reg
 
 	return
@@ -0,0 +1,71 @@
+package sd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// TypeConsul registers services to consul agent
+	TypeConsul = "consul"
+	// TypeDirect does no registration, clients connect to given address directly
+	TypeDirect = "direct"
+	// TypeFile registers services to a local JSON file
+	TypeFile = "file"
+)
+
+// RegisterorFactory creates a SvcRegisteror from service discovery config
+type RegisterorFactory func(sdConfig *ServiceDiscoverySt) (SvcRegisteror, error)
+
+var (
+	factories    = make(map[string]RegisterorFactory)
+	factoryMutex = sync.RWMutex{}
+)
+
+func init() {
+	RegisterFactory(TypeConsul, func(sdConfig *ServiceDiscoverySt) (SvcRegisteror, error) {
+		consulAddr := DefaultConsulAddr
+		if sdConfig.RegAddr != "" {
+			consulAddr = sdConfig.RegAddr
+		}
+		return *NewConsulRegisteror(consulAddr), nil
+	})
+	RegisterFactory(TypeDirect, func(sdConfig *ServiceDiscoverySt) (SvcRegisteror, error) {
+		return NewDirectRegisteror(), nil
+	})
+	RegisterFactory(TypeFile, func(sdConfig *ServiceDiscoverySt) (SvcRegisteror, error) {
+		path := DefaultFilePath
+		if sdConfig.RegAddr != "" {
+			path = sdConfig.RegAddr
+		}
+		return NewFileRegisteror(path), nil
+	})
+}
+
+// RegisterFactory adds a registeror factory for the given type,
+// an existing factory of the same type is replaced
+func RegisterFactory(_type string, factory RegisterorFactory) {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+
+	factories[_type] = factory
+}
+
+// GetFactory returns registeror factory of the given type
+func GetFactory(_type string) (RegisterorFactory, error) {
+	factoryMutex.RLock()
+	defer factoryMutex.RUnlock()
+
+	factory, ok := factories[_type]
+	if !ok {
+		types := make([]string, 0, len(factories))
+		for t := range factories {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return nil, fmt.Errorf("service discovery type [%s] not supported, available: %v", _type, types)
+	}
+
+	return factory, nil
+}
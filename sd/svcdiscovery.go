@@ -4,22 +4,40 @@ import "github.com/butters-mars/tiki/logging"
 
 var logger = logging.L
 
-// ServiceDiscoverySt defines config for consul discovery
+// ServiceDiscoverySt defines config for service discovery
 type ServiceDiscoverySt struct {
-	Type          string `json:"type" yaml:"type"`
-	RegAddr       string `json:"regaddr" yaml:"regaddr"`
+	Type          string `json:"type" yaml:"type"`       // consul, direct or file, see RegisterFactory for more
+	RegAddr       string `json:"regaddr" yaml:"regaddr"` // consul address or path of registry file
 	SvcName       string `json:"svcname" yaml:"svcname"`
 	CheckEndpoint string `json:"check" yaml:"check"`
 	CheckAddr     string `json:"checkaddr" yaml:"checkaddr"`
 	DiscoveryInfo string `json:"discinfo" yaml:"discinfo"`
+
+	CheckType        string       `json:"checktype" yaml:"checktype"`               // http, ttl or grpc, default is http
+	CheckTLS         bool         `json:"checktls" yaml:"checktls"`                 // use TLS for grpc check
+	CheckTTL         int          `json:"checkttl" yaml:"checkttl"`                 // seconds, for ttl check
+	DeregisterAfter  int          `json:"deregisterafter" yaml:"deregisterafter"`   // seconds
+	MaintainInterval int          `json:"maintaininterval" yaml:"maintaininterval"` // seconds
+	TTLStatus        func() error `json:"-" yaml:"-"`
+
+	Tags []string          `json:"tags" yaml:"tags"`
+	Meta map[string]string `json:"meta" yaml:"meta"`
 }
 
 // SvcHealthChk defines health check of a service
 type SvcHealthChk struct {
-	Type     string
-	Content  string
+	Type     string // http, script, ttl or grpc
+	Content  string // url for http, addr[/service] for grpc
+	TLS      bool   // use TLS for grpc check
 	Interval int
 	Timeout  int
+	// TTL is the period in seconds the app must heartbeat in for ttl check
+	TTL int
+	// DeregisterAfter makes registry reap the service after its check being
+	// critical for given seconds, 0 means never
+	DeregisterAfter int
+	// Status reports the status sent with ttl heartbeats, nil means always passing
+	Status func() error
 }
 
 // SvcDef defines a service to register
@@ -29,7 +47,11 @@ type SvcDef struct {
 	Addr        string
 	Port        int
 	Tags        []string
+	Meta        map[string]string
 	HealthCheck *SvcHealthChk
+	// MaintainInterval is the period in seconds to verify the registration and
+	// re-register if it's lost, 0 means default, negative disables it
+	MaintainInterval int
 }
 
 // SvcRegisteror represents an interface for Service Discovery registration
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultCertCheckInterval = 30 * time.Second
+
+// CertReloader loads CA bundle and key pair from files, files are checked on
+// access at most once per interval and reloaded when changed, so that rotated
+// certificates are picked up by new connections without restart
+type CertReloader struct {
+	caFile   string
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mutex     *sync.RWMutex
+	pool      *x509.CertPool
+	cert      *tls.Certificate
+	modTimes  map[string]time.Time
+	checkedAt time.Time
+}
+
+// NewCertReloader creates a reloader, each file could be empty, an empty
+// caFile means system roots, certFile and keyFile must be given together
+func NewCertReloader(caFile, certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("cert file and key file must be given together")
+	}
+	if interval <= 0 {
+		interval = defaultCertCheckInterval
+	}
+
+	r := &CertReloader{
+		caFile:   caFile,
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		mutex:    &sync.RWMutex{},
+		modTimes: make(map[string]time.Time),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *CertReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range []string{r.caFile, r.certFile, r.keyFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		data, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificate found in %s", r.caFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	r.mutex.Lock()
+	r.pool = pool
+	r.cert = cert
+	r.modTimes = modTimes
+	r.checkedAt = time.Now()
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// check reloads files if the interval passed and any file changed,
+// current certificates are kept if reloading fails
+func (r *CertReloader) check() {
+	r.mutex.Lock()
+	if time.Since(r.checkedAt) < r.interval {
+		r.mutex.Unlock()
+		return
+	}
+	r.checkedAt = time.Now()
+	modTimes := r.modTimes
+	r.mutex.Unlock()
+
+	changed := false
+	for f, modTime := range modTimes {
+		if info, err := os.Stat(f); err == nil && !info.ModTime().Equal(modTime) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		logger.Errorf("[TLS] fail to reload %s %s %s, keep current: %v", r.caFile, r.certFile, r.keyFile, err)
+		return
+	}
+	logger.Infof("[TLS] reloaded %s %s %s", r.caFile, r.certFile, r.keyFile)
+}
+
+// CAPool returns current CA pool, nil means system roots
+func (r *CertReloader) CAPool() *x509.CertPool {
+	if r == nil {
+		return nil
+	}
+
+	r.check()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.pool
+}
+
+// Certificate returns current key pair, nil if not given
+func (r *CertReloader) Certificate() *tls.Certificate {
+	if r == nil {
+		return nil
+	}
+
+	r.check()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert
+}
+
+// ClientConfig returns a client tls config with current certificates,
+// serverName overrides SNI and the name to verify
+func (r *CertReloader) ClientConfig(serverName string, insecureSkipVerify bool) *tls.Config {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		RootCAs:            r.CAPool(),
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if cert := r.Certificate(); cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+
+	return cfg
+}
+
+// ServerConfig returns a server tls config picking current certificates for each handshake,
+// client certificates are verified by the CA pool according to clientAuth
+func (r *CertReloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{"h2"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				NextProtos: []string{"h2"},
+				ClientAuth: clientAuth,
+				ClientCAs:  r.CAPool(),
+			}
+			if cert := r.Certificate(); cert != nil {
+				cfg.Certificates = []tls.Certificate{*cert}
+			}
+			return cfg, nil
+		},
+	}
+}
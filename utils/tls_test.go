@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/butters-mars/tiki/internal/testcerts"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	ca := testcerts.NewCA(t, dir)
+	ca.IssueTo(t, certFile, keyFile, "v1")
+	r, err := NewCertReloader("", certFile, keyFile, time.Millisecond)
+	if err != nil {
+		t.Fatalf("fail to load: %v", err)
+	}
+	if r.CAPool() != nil {
+		t.Error("CA pool should be nil for system roots")
+	}
+
+	leaf, _ := x509.ParseCertificate(r.Certificate().Certificate[0])
+	if leaf.Subject.CommonName != "v1" {
+		t.Fatalf("wrong cert: %s", leaf.Subject.CommonName)
+	}
+
+	ca.IssueTo(t, certFile, keyFile, "v2")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+	time.Sleep(5 * time.Millisecond)
+
+	leaf, _ = x509.ParseCertificate(r.Certificate().Certificate[0])
+	if leaf.Subject.CommonName != "v2" {
+		t.Errorf("cert not reloaded: %s", leaf.Subject.CommonName)
+	}
+
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	past := time.Now().Add(-time.Minute)
+	os.Chtimes(keyFile, past, past)
+	time.Sleep(5 * time.Millisecond)
+	if leaf, _ = x509.ParseCertificate(r.Certificate().Certificate[0]); leaf.Subject.CommonName != "v2" {
+		t.Error("current cert should be kept when reloading fails")
+	}
+}